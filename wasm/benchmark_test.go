@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkCases are the fixed runs used to spot performance regressions
+var benchmarkCases = []struct {
+	size, points, colors int
+}{
+	{512, 500, 12},
+	{1024, 1000, 16},
+	{1024, 3000, 24},
+	{2048, 2000, 24},
+}
+
+// BenchmarkPipeline converts a synthetic image for each case, reporting the mean time of
+// every pipeline stage as a custom metric next to the total
+func BenchmarkPipeline(b *testing.B) {
+	for _, c := range benchmarkCases {
+		b.Run(fmt.Sprintf("size=%d/points=%d/colors=%d", c.size, c.points, c.colors), func(b *testing.B) {
+			img := syntheticImage(c.size)
+			stageMillis := make(map[string]float64)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				timer := &stageTimer{}
+				convertToPaintByNumbersWithProgress(img, c.points, c.colors, timer.progress)
+				timer.finish(time.Now())
+				for _, s := range timer.timings {
+					if s.Stage == "Complete" {
+						continue // Marks the end; it has no work of its own
+					}
+					stageMillis[s.Stage] += s.Millis
+				}
+			}
+			for stage, total := range stageMillis {
+				unit := "ms/" + strings.ReplaceAll(strings.ToLower(stage), " ", "-")
+				b.ReportMetric(total/float64(b.N), unit)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+)
+
+// budgetProbeDimension is the size of the trial run that times a sheet before the real
+// one, small enough to cost a few percent of a full-size run
+const budgetProbeDimension = 256
+
+// projectCost is how processImage projects a run's cost, replaced by tests that need a
+// sheet projected over budget while it still finishes in time
+var projectCost = projectedMillis
+
+// projectedMillis estimates how long laying out and rendering img would take by timing the
+// same work on a copy shrunk to budgetProbeDimension and scaling by pixel count. Palette
+// clustering works on a capped sample, so the estimate errs on the long side.
+func projectedMillis(img image.Image, numPoints, numColors, lineWidth int, showColors, useVoronoi bool, opts ProcessOptions) float64 {
+	probe := downsampleImageWithGamma(img, budgetProbeDimension, opts.GammaCorrect)
+	opts.Progress = nil
+
+	started := time.Now()
+	prepareLayout(probe, numPoints, numColors, useVoronoi, opts).render(lineWidth, showColors)
+	elapsed := float64(time.Since(started).Microseconds()) / 1000
+
+	full, probed := img.Bounds(), probe.Bounds()
+	return elapsed * float64(full.Dx()*full.Dy()) / float64(probed.Dx()*probed.Dy())
+}
+
+// budgetDimension returns the largest maxDimension at which downsampling bounds, by the
+// pixel budget rule of downsampledSize, leaves no more pixels than a run projected to take
+// projected milliseconds at bounds can process in remaining milliseconds. It returns false
+// when bounds already fits, or when that maxDimension would not shrink bounds. It never goes
+// below the smallest maxDimension processImage accepts.
+func budgetDimension(bounds image.Rectangle, projected, remaining float64) (int, bool) {
+	if projected <= remaining {
+		return 0, false
+	}
+	pixels := float64(bounds.Dx() * bounds.Dy())
+	dimension := int(math.Sqrt(pixels * math.Max(remaining, 0) / projected))
+	if dimension < 256 {
+		dimension = 256
+	}
+	if downsampledSize(bounds, dimension) == bounds.Size() {
+		return 0, false
+	}
+	return dimension, true
+}
+
+// degradedWarning explains a sheet made smaller than requested to fit the time budget
+func degradedWarning(requested, used image.Rectangle, projected, budget float64) string {
+	return fmt.Sprintf("Processing at %dx%d was projected to take %.0f ms, over the %.0f ms budget; the sheet was made at %dx%d instead.",
+		requested.Dx(), requested.Dy(), projected, budget, used.Dx(), used.Dy())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDegradedSheetKeepsTargetRegions(t *testing.T) {
+	const target = 60
+	args := testSheetArgs
+	args.maxDimension = 512
+
+	// Projected far over a budget the run still fits in, the sheet shrinks to 256 pixels
+	projectCost = func(image.Image, int, int, int, bool, bool, ProcessOptions) float64 { return 1e9 }
+	defer func() { projectCost = projectedMillis }()
+	result := mustProcessImage(t, syntheticImage(512), args, map[string]interface{}{
+		"targetRegions": target, "timeBudget": 600000, "regionsByColor": true, "exportRecipe": true,
+	})
+	if !result.Degraded || result.Width != 256 {
+		t.Fatalf("sheet is %dx%d, degraded %v; want it shrunk to 256", result.Width, result.Height, result.Degraded)
+	}
+
+	regions := 0
+	for _, c := range result.RegionsByColor {
+		regions += len(c.Regions)
+	}
+	var recipe Recipe
+	if err := json.Unmarshal([]byte(result.Recipe), &recipe); err != nil {
+		t.Fatal(err)
+	}
+	if len(recipe.SeedPoints) != recipe.Points {
+		t.Errorf("recipe records %d points but the sheet has %d", recipe.Points, len(recipe.SeedPoints))
+	}
+
+	// The point count is tuned at the size actually drawn: the sheet is near the target, or
+	// the warning says how far off it is
+	near := math.Abs(float64(regions-target)) <= regionTolerance*target
+	if warned := strings.Contains(result.Warning, "Asked for about"); near == warned {
+		t.Errorf("%d regions from %d points for a target of %d, warning %q", regions, recipe.Points, target, result.Warning)
+	}
+}
+
+func TestBudgetDimensionFitsNonSquareImages(t *testing.T) {
+	for _, bounds := range []image.Rectangle{
+		image.Rect(0, 0, 1000, 1000),
+		image.Rect(0, 0, 2000, 500),
+		image.Rect(0, 0, 400, 2400),
+		image.Rect(0, 0, 4000, 300),
+	} {
+		// Projected at 4 times the remaining budget, a quarter of the pixels fit
+		pixels := bounds.Dx() * bounds.Dy()
+		dimension, ok := budgetDimension(bounds, 4000, 1000)
+		if !ok {
+			t.Errorf("%v: no downscale for a run projected 4 times over budget", bounds.Size())
+			continue
+		}
+		size := downsampleImage(image.NewRGBA(bounds), dimension).Bounds().Size()
+		if area := size.X * size.Y; area > pixels/4 || float64(area) < 0.95*float64(pixels/4) {
+			t.Errorf("%v: maxDimension %d gives %v, %d pixels, want just under %d", bounds.Size(), dimension, size, area, pixels/4)
+		}
+		if aspect := aspectRatio(image.Rectangle{Max: size}); math.Abs(aspect-aspectRatio(bounds)) > 0.05*aspectRatio(bounds) {
+			t.Errorf("%v: downscaled to %v, changing the aspect ratio", bounds.Size(), size)
+		}
+	}
+
+	// A long, thin image already under the smallest maxDimension's pixel count is left alone
+	if dimension, ok := budgetDimension(image.Rect(0, 0, 1000, 60), 4000, 1000); ok {
+		t.Errorf("1000x60 image downscaled to maxDimension %d, though 256² pixels already hold it", dimension)
+	}
+}
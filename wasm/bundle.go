@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+)
+
+// buildResultZip packages the numbered sheet, colored reference, legend and palette into a
+// ZIP archive, encoding the images at the named PNG compression level
+func buildResultZip(sheet, reference, legend image.Image, palette []ColorInfo, compression string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	images := []struct {
+		name string
+		img  image.Image
+	}{
+		{"sheet.png", sheet},
+		{"reference.png", reference},
+		{"legend.png", legend},
+	}
+
+	for _, entry := range images {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := encodePNG(w, entry.img, compression); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", entry.name, err)
+		}
+	}
+
+	paletteJSON, err := json.MarshalIndent(palette, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	w, err := zw.Create("palette.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(paletteJSON); err != nil {
+		return nil, err
+	}
+
+	w, err = zw.Create("palette.csv")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(paletteToCSV(palette))); err != nil {
+		return nil, err
+	}
+
+	w, err = zw.Create("palette.gpl")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(paletteToGPL(palette))); err != nil {
+		return nil, err
+	}
+
+	w, err = zw.Create("palette.ase")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(paletteToASE(palette)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"testing"
+)
+
+func TestZipFormatBundlesEveryArtifact(t *testing.T) {
+	result := mustProcessImage(t, syntheticImage(192), testSheetArgs, map[string]interface{}{"format": "zip"})
+
+	data := decodeBase64(t, result.Zip)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("result zip does not open: %v", err)
+	}
+
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	for _, name := range []string{"sheet.png", "reference.png", "legend.png", "palette.json", "palette.csv", "palette.gpl", "palette.ase"} {
+		if entries[name] == nil {
+			t.Errorf("zip has no %s", name)
+		}
+	}
+
+	for _, name := range []string{"sheet.png", "reference.png", "legend.png"} {
+		if f := entries[name]; f != nil {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatalf("opening %s: %v", name, err)
+			}
+			if _, err := png.Decode(r); err != nil {
+				t.Errorf("%s is not a PNG: %v", name, err)
+			}
+			r.Close()
+		}
+	}
+
+	if f := entries["palette.json"]; f != nil {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening palette.json: %v", err)
+		}
+		defer r.Close()
+		var palette []ColorInfo
+		if err := json.NewDecoder(r).Decode(&palette); err != nil {
+			t.Fatalf("palette.json does not parse: %v", err)
+		}
+		if len(palette) != len(result.Palette) {
+			t.Errorf("palette.json has %d colors, the result %d", len(palette), len(result.Palette))
+		}
+	}
+}
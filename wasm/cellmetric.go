@@ -0,0 +1,52 @@
+package main
+
+import "math"
+
+// CellMetric selects the distance that decides which seed point owns a pixel, and so
+// the shape of the Voronoi cells
+type CellMetric int
+
+const (
+	// CellEuclidean gives the usual rounded cells (the default)
+	CellEuclidean CellMetric = iota
+	// CellManhattan gives cells with diagonal, diamond-like edges
+	CellManhattan
+	// CellChebyshev gives cells with axis-aligned, blocky edges
+	CellChebyshev
+)
+
+// parseCellMetric converts a cell metric name to a CellMetric
+func parseCellMetric(name string) (CellMetric, bool) {
+	switch name {
+	case "", "euclidean":
+		return CellEuclidean, true
+	case "manhattan":
+		return CellManhattan, true
+	case "chebyshev":
+		return CellChebyshev, true
+	}
+	return CellEuclidean, false
+}
+
+// distance returns a comparable distance between two pixels; the Euclidean value is squared
+func (m CellMetric) distance(x1, y1, x2, y2 int) float64 {
+	switch m {
+	case CellManhattan:
+		return math.Abs(float64(x1-x2)) + math.Abs(float64(y1-y2))
+	case CellChebyshev:
+		return math.Max(math.Abs(float64(x1-x2)), math.Abs(float64(y1-y2)))
+	default:
+		return distanceSquared(x1, y1, x2, y2)
+	}
+}
+
+// planeDistance is the smallest possible distance, in the same units as distance, to any
+// point on the far side of a splitting line diff pixels away. k-d tree searches skip
+// the far side when this already exceeds the best match.
+func (m CellMetric) planeDistance(diff int) float64 {
+	d := float64(diff)
+	if m == CellEuclidean {
+		return d * d
+	}
+	return math.Abs(d)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// cmykVector converts a color to naive process CMYK components in the 0-1 range,
+// using the same separation as rgbToCMYK
+func cmykVector(c color.Color) [4]float64 {
+	r, g, b, _ := c.RGBA()
+	rf := float64(r) / 65535.0
+	gf := float64(g) / 65535.0
+	bf := float64(b) / 65535.0
+
+	k := 1.0 - math.Max(rf, math.Max(gf, bf))
+	if k >= 1.0 {
+		return [4]float64{0, 0, 0, 1}
+	}
+	return [4]float64{
+		(1.0 - rf - k) / (1.0 - k),
+		(1.0 - gf - k) / (1.0 - k),
+		(1.0 - bf - k) / (1.0 - k),
+		k,
+	}
+}
+
+// cmykToRGB converts CMYK components back to RGB for on-screen preview
+func cmykToRGB(v [4]float64) color.RGBA {
+	channel := func(ink float64) uint8 {
+		return clampChannel((1 - ink) * (1 - v[3]) * 255)
+	}
+	return color.RGBA{channel(v[0]), channel(v[1]), channel(v[2]), 255}
+}
+
+// cmykDistanceSquared is the squared Euclidean distance between two colors in CMYK space
+func cmykDistanceSquared(c1, c2 color.Color) float64 {
+	a, b := cmykVector(c1), cmykVector(c2)
+	return vectorDistanceSquared(a[:], b[:])
+}
+
+// generateCMYKPalette clusters the image in CMYK space so palette separation follows
+// ink amounts (K in particular) rather than RGB light, for print workflows
+func generateCMYKPalette(ctx context.Context, img image.Image, numColors, step, maxSamples int, rng *rand.Rand) []color.Color {
+	// Sample colors from the image
+	var samples [][4]float64
+	for _, p := range paletteSamplePoints(img.Bounds(), step, maxSamples, rng) {
+		samples = append(samples, cmykVector(img.At(p.X, p.Y)))
+	}
+
+	centroids := kMeansCMYK(ctx, samples, numColors, rng)
+
+	palette := make([]color.Color, len(centroids))
+	for i, c := range centroids {
+		palette[i] = cmykToRGB(c)
+	}
+	return palette
+}
+
+// kMeansCMYK runs k-means++ on CMYK vectors, averaging clusters in CMYK space, until it
+// converges or ctx is done
+func kMeansCMYK(ctx context.Context, samples [][4]float64, k int, rng *rand.Rand) [][4]float64 {
+	if len(samples) == 0 {
+		return [][4]float64{cmykVector(color.RGBA{128, 128, 128, 255})}
+	}
+
+	// With no more distinct vectors than clusters, every vector is its own cluster
+	seen := make(map[[4]float64]bool)
+	var distinct [][4]float64
+	for _, s := range samples {
+		if !seen[s] {
+			seen[s] = true
+			distinct = append(distinct, s)
+		}
+	}
+	if k >= len(distinct) {
+		return distinct
+	}
+
+	vectors := make([][]float64, len(samples))
+	for i := range samples {
+		vectors[i] = samples[i][:]
+	}
+	centroids := make([][4]float64, k)
+	for i, c := range vectorKMeans(ctx, vectors, nil, k, vectorDistanceSquared, rng) {
+		copy(centroids[i][:], c)
+	}
+	return centroids
+}
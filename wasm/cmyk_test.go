@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestCMYKPaletteDiffersFromRGB(t *testing.T) {
+	// Noisy patches of near-black shades and saturated primaries
+	base := []color.RGBA{
+		{12, 10, 10, 255}, {30, 8, 8, 255}, {8, 8, 34, 255},
+		{230, 20, 20, 255}, {20, 200, 40, 255}, {30, 40, 220, 255}, {240, 220, 20, 255},
+	}
+	rng := rand.New(rand.NewSource(3))
+	img := image.NewRGBA(image.Rect(0, 0, 140, 100))
+	jitter := func(v uint8) uint8 { return uint8(min(255, max(0, float64(v)+float64(rng.Intn(9)-4)))) }
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 140; x++ {
+			c := base[x/20]
+			img.SetRGBA(x, y, color.RGBA{jitter(c.R), jitter(c.G), jitter(c.B), 255})
+		}
+	}
+
+	ctx := context.Background()
+	rgb := generatePaletteWithMetric(ctx, img, 4, MetricEuclidean, 2, 0, rand.New(rand.NewSource(1)))
+	cmyk := generateCMYKPalette(ctx, img, 4, 2, 0, rand.New(rand.NewSource(1)))
+
+	inRGB := make(map[color.RGBA]bool)
+	for _, c := range rgb {
+		inRGB[color.RGBAModel.Convert(c).(color.RGBA)] = true
+	}
+	same := 0
+	for _, c := range cmyk {
+		if inRGB[color.RGBAModel.Convert(c).(color.RGBA)] {
+			same++
+		}
+	}
+	if len(cmyk) != 4 || same == len(cmyk) {
+		t.Errorf("CMYK palette %v matches the RGB palette %v", cmyk, rgb)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// ColorMask is the paint mask of one layout color, for screen printing and layered crafts
+type ColorMask struct {
+	Number int    `json:"number"` // Number of the color in the palette, 0 if it has no numbered region
+	Hex    string `json:"hex"`
+	Image  string `json:"image"` // Base64 grayscale PNG, white where the color is painted
+}
+
+// renderColorMasks returns one mask per palette index of assignment (row-major over
+// bounds): white where that index is painted, black elsewhere. Every painted pixel is
+// white in exactly one mask; pixels outside the layout's mask (maskedOut) are black in all.
+func renderColorMasks(assignment []int, bounds image.Rectangle, numColors int) []image.Image {
+	masks := make([]*image.Gray, numColors)
+	for i := range masks {
+		masks[i] = image.NewGray(bounds)
+	}
+
+	width := bounds.Dx()
+	for i, idx := range assignment {
+		if idx < 0 || idx >= numColors {
+			continue
+		}
+		masks[idx].SetGray(bounds.Min.X+i%width, bounds.Min.Y+i/width, color.Gray{Y: 255})
+	}
+
+	images := make([]image.Image, numColors)
+	for i, mask := range masks {
+		images[i] = mask
+	}
+	return images
+}
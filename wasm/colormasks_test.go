@@ -0,0 +1,38 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestColorMasksCoverEachPaintedPixelOnce(t *testing.T) {
+	const numColors = 5
+	bounds := image.Rect(10, 20, 74, 68)
+	rng := rand.New(rand.NewSource(1))
+	assignment := make([]int, bounds.Dx()*bounds.Dy())
+	for i := range assignment {
+		// About one pixel in six lies outside the mask
+		assignment[i] = rng.Intn(numColors+1) - 1
+	}
+
+	masks := renderColorMasks(assignment, bounds, numColors)
+	if len(masks) != numColors {
+		t.Fatalf("%d masks, want %d", len(masks), numColors)
+	}
+	for i, idx := range assignment {
+		x, y := bounds.Min.X+i%bounds.Dx(), bounds.Min.Y+i/bounds.Dx()
+		var white []int
+		for m, mask := range masks {
+			if mask.(*image.Gray).GrayAt(x, y).Y == 255 {
+				white = append(white, m)
+			}
+		}
+		if idx == maskedOut && len(white) != 0 {
+			t.Fatalf("masked out pixel (%d, %d) is white in masks %v", x, y, white)
+		}
+		if idx != maskedOut && (len(white) != 1 || white[0] != idx) {
+			t.Fatalf("pixel (%d, %d) of color %d is white in masks %v, want only %d", x, y, idx, white, idx)
+		}
+	}
+}
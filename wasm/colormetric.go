@@ -0,0 +1,194 @@
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// ColorMetric selects how the difference between two colors is measured
+type ColorMetric int
+
+const (
+	// MetricEuclidean is plain RGB Euclidean distance (fast, the default)
+	MetricEuclidean ColorMetric = iota
+	// MetricRedmean weights RGB channels by the mean red level, a cheap perceptual approximation
+	MetricRedmean
+	// MetricCIEDE2000 is the CIE perceptual color difference (accurate but slow)
+	MetricCIEDE2000
+	// MetricCMYK compares ink amounts; palettes are also clustered in CMYK space for print
+	MetricCMYK
+)
+
+// parseColorMetric converts a metric name to a ColorMetric
+func parseColorMetric(name string) (ColorMetric, bool) {
+	switch name {
+	case "", "euclidean":
+		return MetricEuclidean, true
+	case "redmean":
+		return MetricRedmean, true
+	case "ciede2000":
+		return MetricCIEDE2000, true
+	case "cmyk":
+		return MetricCMYK, true
+	}
+	return MetricEuclidean, false
+}
+
+// distance returns a comparable distance between two colors; smaller means more similar.
+// Values are squared where that is cheaper, so they are only meaningful relative to each other.
+func (m ColorMetric) distance(c1, c2 color.Color) float64 {
+	switch m {
+	case MetricRedmean:
+		return redmeanDistanceSquared(c1, c2)
+	case MetricCIEDE2000:
+		l1, a1, b1 := colorToLab(c1)
+		l2, a2, b2 := colorToLab(c2)
+		return ciede2000(l1, a1, b1, l2, a2, b2)
+	case MetricCMYK:
+		return cmykDistanceSquared(c1, c2)
+	default:
+		return colorDistanceSquared(c1, c2)
+	}
+}
+
+// redmeanDistanceSquared is the "redmean" weighted RGB distance on 8-bit channels
+func redmeanDistanceSquared(c1, c2 color.Color) float64 {
+	r1, g1, b1, _ := c1.RGBA()
+	r2, g2, b2, _ := c2.RGBA()
+
+	rMean := (float64(r1>>8) + float64(r2>>8)) / 2
+	dr := float64(r1>>8) - float64(r2>>8)
+	dg := float64(g1>>8) - float64(g2>>8)
+	db := float64(b1>>8) - float64(b2>>8)
+
+	return (2+rMean/256)*dr*dr + 4*dg*dg + (2+(255-rMean)/256)*db*db
+}
+
+// colorToLab converts a color to CIE L*a*b* (D65 white point)
+func colorToLab(c color.Color) (float64, float64, float64) {
+	r, g, b, _ := c.RGBA()
+
+	rl := srgbToLinearTable[r>>8]
+	gl := srgbToLinearTable[g>>8]
+	bl := srgbToLinearTable[b>>8]
+
+	// Linear sRGB to XYZ, normalized by the D65 reference white
+	x := (0.4124564*rl + 0.3575761*gl + 0.1804375*bl) / 0.95047
+	y := 0.2126729*rl + 0.7151522*gl + 0.0721750*bl
+	z := (0.0193339*rl + 0.1191920*gl + 0.9503041*bl) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// ciede2000 computes the CIEDE2000 color difference between two Lab colors
+func ciede2000(l1, a1, b1, l2, a2, b2 float64) float64 {
+	c1 := math.Hypot(a1, b1)
+	c2 := math.Hypot(a2, b2)
+	cMean := (c1 + c2) / 2
+
+	cMean7 := math.Pow(cMean, 7)
+	g := 0.5 * (1 - math.Sqrt(cMean7/(cMean7+math.Pow(25, 7))))
+
+	a1p := (1 + g) * a1
+	a2p := (1 + g) * a2
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+
+	hueAngle := func(b, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := math.Atan2(b, a) * 180 / math.Pi
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	h1p := hueAngle(b1, a1p)
+	h2p := hueAngle(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(dhp*math.Pi/360)
+
+	lMean := (l1 + l2) / 2
+	cMeanP := (c1p + c2p) / 2
+
+	hMeanP := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) > 180 {
+			if hMeanP < 360 {
+				hMeanP += 360
+			} else {
+				hMeanP -= 360
+			}
+		}
+		hMeanP /= 2
+	}
+
+	t := 1 - 0.17*math.Cos((hMeanP-30)*math.Pi/180) +
+		0.24*math.Cos(2*hMeanP*math.Pi/180) +
+		0.32*math.Cos((3*hMeanP+6)*math.Pi/180) -
+		0.20*math.Cos((4*hMeanP-63)*math.Pi/180)
+
+	dTheta := 30 * math.Exp(-math.Pow((hMeanP-275)/25, 2))
+	cMeanP7 := math.Pow(cMeanP, 7)
+	rc := 2 * math.Sqrt(cMeanP7/(cMeanP7+math.Pow(25, 7)))
+	lOffset := (lMean - 50) * (lMean - 50)
+	sl := 1 + 0.015*lOffset/math.Sqrt(20+lOffset)
+	sc := 1 + 0.045*cMeanP
+	sh := 1 + 0.015*cMeanP*t
+	rt := -math.Sin(2*dTheta*math.Pi/180) * rc
+
+	lTerm := dLp / sl
+	cTerm := dCp / sc
+	hTerm := dHp / sh
+
+	return math.Sqrt(lTerm*lTerm + cTerm*cTerm + hTerm*hTerm + rt*cTerm*hTerm)
+}
+
+// minLineContrast is the smallest contrast ratio between border and number colors that
+// still keeps numbers readable where they touch a border (the WCAG minimum for large text)
+const minLineContrast = 3.0
+
+// luminance returns the Rec. 601 luma of c, from 0 (black) to 1 (white): the weighted sum
+// of the gamma-encoded channels, which tracks perceived brightness closely enough for edge
+// detection and ordering colors. relativeLuminance is the gamma-correct counterpart.
+func luminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 65535
+}
+
+// relativeLuminance returns the WCAG relative luminance of c, from 0 (black) to 1 (white),
+// computed in linear light
+func relativeLuminance(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return 0.2126*srgbToLinearTable[r>>8] + 0.7152*srgbToLinearTable[g>>8] + 0.0722*srgbToLinearTable[b>>8]
+}
+
+// contrastRatio returns the WCAG contrast ratio between two colors, from 1 to 21
+func contrastRatio(c1, c2 color.Color) float64 {
+	l1, l2 := relativeLuminance(c1), relativeLuminance(c2)
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestCIEDE2000MatchesReferenceData(t *testing.T) {
+	// Pairs from Sharma, Wu and Dalal's CIEDE2000 test data
+	for _, tc := range []struct {
+		lab1, lab2 [3]float64
+		want       float64
+	}{
+		{[3]float64{50, 2.6772, -79.7751}, [3]float64{50, 0, -82.7485}, 2.0425},
+		{[3]float64{50, -1.3802, -84.2814}, [3]float64{50, 0, -82.7485}, 1.0000},
+		{[3]float64{50, 2.5, 0}, [3]float64{73, 25, -18}, 27.1492},
+		{[3]float64{50, 0, 0}, [3]float64{50, -1, 2}, 2.3669},
+	} {
+		got := ciede2000(tc.lab1[0], tc.lab1[1], tc.lab1[2], tc.lab2[0], tc.lab2[1], tc.lab2[2])
+		if math.Abs(got-tc.want) > 1e-3 {
+			t.Errorf("ciede2000(%v, %v) = %.4f, want %.4f", tc.lab1, tc.lab2, got, tc.want)
+		}
+	}
+}
+
+func TestColorMetricsDisagreeOnNearestMatch(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 255}
+	for _, tc := range []struct {
+		name    string
+		palette []color.Color
+		want    map[ColorMetric]int
+	}{
+		{
+			// Pure blue is far from black in RGB but dark to the eye
+			name:    "blue vs mid green",
+			palette: []color.Color{color.RGBA{0, 0, 255, 255}, color.RGBA{64, 192, 128, 255}},
+			want:    map[ColorMetric]int{MetricEuclidean: 1, MetricRedmean: 0, MetricCIEDE2000: 0},
+		},
+		{
+			// Redmean's channel weights still favor the dark blue; CIEDE2000 sees the gray as closer
+			name:    "dark blue vs dark gray",
+			palette: []color.Color{color.RGBA{0, 0, 64, 255}, color.RGBA{64, 64, 64, 255}},
+			want:    map[ColorMetric]int{MetricEuclidean: 0, MetricRedmean: 0, MetricCIEDE2000: 1},
+		},
+	} {
+		for metric, want := range tc.want {
+			if got := findNearestColorWithMetric(black, tc.palette, metric); got != want {
+				t.Errorf("%s: metric %d picked palette color %d, want %d", tc.name, metric, got, want)
+			}
+		}
+	}
+}
+
+func TestParseColorMetric(t *testing.T) {
+	for name, want := range map[string]ColorMetric{
+		"":          MetricEuclidean,
+		"euclidean": MetricEuclidean,
+		"redmean":   MetricRedmean,
+		"ciede2000": MetricCIEDE2000,
+		"cmyk":      MetricCMYK,
+	} {
+		if got, ok := parseColorMetric(name); !ok || got != want {
+			t.Errorf("parseColorMetric(%q) = %d, %v, want %d", name, got, ok, want)
+		}
+	}
+	if _, ok := parseColorMetric("manhattan"); ok {
+		t.Error("parseColorMetric accepted an unknown metric")
+	}
+}
+
+func TestGreenIsBrighterThanBlue(t *testing.T) {
+	red, green, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
+
+	// Rec. 601 luma weights green 0.587 against blue 0.114; in linear light it is 0.7152 to 0.0722
+	if r := luminance(green) / luminance(blue); math.Abs(r-0.587/0.114) > 1e-9 {
+		t.Errorf("luma green/blue = %.4f, want %.4f", r, 0.587/0.114)
+	}
+	if r := relativeLuminance(green) / relativeLuminance(blue); math.Abs(r-0.7152/0.0722) > 1e-3 {
+		t.Errorf("relative luminance green/blue = %.4f, want %.4f", r, 0.7152/0.0722)
+	}
+	if math.Abs(luminance(color.White)-1) > 1e-9 || luminance(color.Black) != 0 {
+		t.Errorf("luma of white %v and black %v, want 1 and 0", luminance(color.White), luminance(color.Black))
+	}
+
+	// Brightness sorting uses the same weights
+	sorted := sortColorsByBrightness([]color.Color{green, red, blue})
+	if sorted[0] != blue || sorted[1] != red || sorted[2] != green {
+		t.Errorf("sorted by brightness: %v, want blue, red, green", sorted)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// Connectivity selects which neighbors of a pixel are compared to decide whether it lies
+// on a border between regions
+type Connectivity int
+
+const (
+	// ConnectivityDisk compares every pixel within the line radius (a disk, just the four direct
+	// neighbors at radius 1), so both sides of a boundary are marked and the line is centered on
+	// it (the default)
+	ConnectivityDisk Connectivity = iota
+	// Connectivity4 compares the pixels reachable in up to radius steps up, down, left or right,
+	// a diamond that thins wide lines where they run diagonally
+	Connectivity4
+	// Connectivity8 also compares diagonal neighbors, for heavier lines without gaps at corners
+	Connectivity8
+	// ConnectivityForward compares only the pixels to the right and below, the thinnest line, but
+	// drawn on one side of the boundary so it sits half a pixel off the true edge
+	ConnectivityForward
+)
+
+// parseConnectivity converts a connectivity name to a Connectivity
+func parseConnectivity(name string) (Connectivity, bool) {
+	switch name {
+	case "", "disk":
+		return ConnectivityDisk, true
+	case "4":
+		return Connectivity4, true
+	case "8":
+		return Connectivity8, true
+	case "forward":
+		return ConnectivityForward, true
+	}
+	return ConnectivityDisk, false
+}
+
+// within reports whether the pixel offset by (dx, dy) is a neighbor to compare for a line
+// of the given radius
+func (n Connectivity) within(dx, dy, radius int) bool {
+	if dx == 0 && dy == 0 {
+		return false
+	}
+	switch n {
+	case Connectivity8:
+		return dx >= -radius && dx <= radius && dy >= -radius && dy <= radius
+	case Connectivity4:
+		return CellManhattan.distance(0, 0, dx, dy) <= float64(radius)
+	case ConnectivityForward:
+		return dx >= 0 && dy >= 0 && dx*dx+dy*dy <= radius*radius
+	default:
+		return dx*dx+dy*dy <= radius*radius
+	}
+}
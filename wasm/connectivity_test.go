@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// borderColumns draws borders between the two halves of a 40×20 assignment and returns how
+// many border pixels fall in each column
+func borderColumns(width int, connectivity Connectivity) []int {
+	bounds := image.Rect(0, 0, 40, 20)
+	img := image.NewRGBA(bounds)
+	drawColorBorders(img, stripeAssignment(40, 20, 0, 1), width, connectivity, nil)
+
+	columns := make([]int, 40)
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if img.RGBAAt(x, y) == (color.RGBA{0, 0, 0, 255}) {
+				columns[x]++
+			}
+		}
+	}
+	return columns
+}
+
+func TestSymmetricBordersMarkBothSidesOfBoundary(t *testing.T) {
+	// The halves meet between columns 19 and 20
+	for _, tc := range []struct {
+		width       int
+		left, right int // Full border columns on each side of the boundary
+	}{
+		{1, 1, 1},
+		{3, 2, 2},
+	} {
+		for _, connectivity := range []Connectivity{ConnectivityDisk, Connectivity4, Connectivity8} {
+			columns := borderColumns(tc.width, connectivity)
+			for x, n := range columns {
+				want := 0
+				if x >= 20-tc.left && x < 20+tc.right {
+					want = 20
+				}
+				if n != want {
+					t.Errorf("width %d, connectivity %d: column %d has %d border pixels, want %d", tc.width, connectivity, x, n, want)
+				}
+			}
+		}
+	}
+
+	// The one-sided mode draws only left of the boundary
+	columns := borderColumns(1, ConnectivityForward)
+	if columns[19] != 20 || columns[20] != 0 {
+		t.Errorf("forward: columns 19 and 20 have %d and %d border pixels, want 20 and 0", columns[19], columns[20])
+	}
+}
+
+func TestConnectivity4ReachesADiamond(t *testing.T) {
+	// One odd pixel; every pixel within 3 four-neighbor steps of it sees a different color
+	bounds := image.Rect(0, 0, 15, 15)
+	assignment := make([]int, 15*15)
+	assignment[7*15+7] = 1
+	img := image.NewRGBA(bounds)
+	drawColorBorders(img, assignment, 5, Connectivity4, nil)
+
+	for y := 0; y < 15; y++ {
+		for x := 0; x < 15; x++ {
+			dx, dy := x-7, y-7
+			inside := CellManhattan.distance(0, 0, dx, dy) <= 3
+			if border := img.RGBAAt(x, y).A != 0; border != inside {
+				t.Errorf("pixel %d,%d at offset (%d, %d): border = %v, want %v", x, y, dx, dy, border, inside)
+			}
+		}
+	}
+}
+
+func TestDefaultConnectivityKeepsTheDisk(t *testing.T) {
+	if connectivity, ok := parseConnectivity(""); !ok || connectivity != ConnectivityDisk {
+		t.Fatalf("default connectivity = %d, want the disk", connectivity)
+	}
+
+	// Width 5 draws out to radius 3: the disk reaches the diagonal (2, 2), the diamond does not
+	bounds := image.Rect(0, 0, 15, 15)
+	assignment := make([]int, 15*15)
+	assignment[7*15+7] = 1
+	img := image.NewRGBA(bounds)
+	drawColorBorders(img, assignment, 5, ConnectivityDisk, nil)
+
+	for y := 0; y < 15; y++ {
+		for x := 0; x < 15; x++ {
+			dx, dy := x-7, y-7
+			inside := dx*dx+dy*dy <= 9
+			if border := img.RGBAAt(x, y).A != 0; border != inside {
+				t.Errorf("pixel %d,%d at offset (%d, %d): border = %v, want %v", x, y, dx, dy, border, inside)
+			}
+		}
+	}
+}
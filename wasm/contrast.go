@@ -0,0 +1,200 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// minNeighborContrast is the smallest CIEDE2000 difference between the colors of two
+// touching regions before they count as too alike to tell apart while painting
+const minNeighborContrast = 10.0
+
+// maxContrastCandidates is how many of the palette colors nearest a region's own average
+// color improveLocalContrast may pick from, so a region never drifts far from how it looks
+const maxContrastCandidates = 3
+
+// regionAdjacency lists, for each region, the regions it shares an edge with
+func regionAdjacency(regions []Region, bounds image.Rectangle) [][]int {
+	width, height := bounds.Dx(), bounds.Dy()
+	owner := make([]int, width*height)
+	for i := range owner {
+		owner[i] = -1
+	}
+	for ri, region := range regions {
+		for _, p := range region.Pixels {
+			owner[(p.Y-bounds.Min.Y)*width+(p.X-bounds.Min.X)] = ri
+		}
+	}
+
+	neighbors := make([]map[int]bool, len(regions))
+	link := func(a, b int) {
+		if a < 0 || b < 0 || a == b {
+			return
+		}
+		if neighbors[a] == nil {
+			neighbors[a] = make(map[int]bool)
+		}
+		if neighbors[b] == nil {
+			neighbors[b] = make(map[int]bool)
+		}
+		neighbors[a][b] = true
+		neighbors[b][a] = true
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if x < width-1 {
+				link(owner[i], owner[i+1])
+			}
+			if y < height-1 {
+				link(owner[i], owner[i+width])
+			}
+		}
+	}
+
+	adjacency := make([][]int, len(regions))
+	for ri, set := range neighbors {
+		for n := range set {
+			adjacency[ri] = append(adjacency[ri], n)
+		}
+		sort.Ints(adjacency[ri])
+	}
+	return adjacency
+}
+
+// improveLocalContrast looks for regions whose palette color is within minNeighborContrast
+// of a touching region's color and moves each to the next-nearest palette color (to the
+// region's average color in img) that stands apart from all of its neighbors. Smaller
+// regions move first, since changing them alters the picture least; regions without such
+// a color keep theirs. ColorIndex is updated in place and the number of changed regions
+// is returned.
+func improveLocalContrast(regions []Region, adjacency [][]int, palette []color.Color, img image.Image) int {
+	labs := make([][3]float64, len(palette))
+	for i, c := range palette {
+		labs[i][0], labs[i][1], labs[i][2] = colorToLab(c)
+	}
+	difference := func(a, b [3]float64) float64 {
+		return ciede2000(a[0], a[1], a[2], b[0], b[1], b[2])
+	}
+	tooClose := func(a, b int) bool {
+		return difference(labs[a], labs[b]) < minNeighborContrast
+	}
+
+	order := make([]int, len(regions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return regions[order[a]].Area < regions[order[b]].Area
+	})
+
+	changed := 0
+	for _, ri := range order {
+		current := regions[ri].ColorIndex
+		conflict := false
+		for _, n := range adjacency[ri] {
+			if other := regions[n].ColorIndex; other != current && tooClose(current, other) {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			continue
+		}
+
+		// Rank the palette by closeness to what the region actually looks like
+		mean := regionMeanLab(regions[ri], img)
+		candidates := make([]int, len(palette))
+		for i := range candidates {
+			candidates[i] = i
+		}
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return difference(mean, labs[candidates[a]]) < difference(mean, labs[candidates[b]])
+		})
+		if len(candidates) > maxContrastCandidates {
+			candidates = candidates[:maxContrastCandidates]
+		}
+
+		for _, c := range candidates {
+			if c == current {
+				continue
+			}
+			distinct := true
+			for _, n := range adjacency[ri] {
+				if tooClose(c, regions[n].ColorIndex) {
+					distinct = false
+					break
+				}
+			}
+			if distinct {
+				regions[ri].ColorIndex = c
+				changed++
+				break
+			}
+		}
+	}
+
+	return changed
+}
+
+// regionMeanLab returns the average color of img over a region's pixels in L*a*b*
+func regionMeanLab(region Region, img image.Image) [3]float64 {
+	var sumR, sumG, sumB uint64
+	for _, p := range region.Pixels {
+		r, g, b, _ := img.At(p.X, p.Y).RGBA()
+		sumR += uint64(r >> 8)
+		sumG += uint64(g >> 8)
+		sumB += uint64(b >> 8)
+	}
+	n := uint64(len(region.Pixels))
+	if n == 0 {
+		n = 1
+	}
+
+	var lab [3]float64
+	lab[0], lab[1], lab[2] = colorToLab(color.RGBA{uint8(sumR / n), uint8(sumG / n), uint8(sumB / n), 255})
+	return lab
+}
+
+// applyLocalContrast runs improveLocalContrast over the layout's numbered regions and
+// repaints the ones it moves: pixel indices in grid layouts, seed points in Voronoi ones.
+func (l *sheetLayout) applyLocalContrast(img image.Image) {
+	assignment := l.assignment()
+	regions := buildLabeledRegions(assignment, l.bounds, l.palette, l.minArea, l.labeling, l.keepAllColors)
+	original := make([]int, len(regions))
+	for i, region := range regions {
+		original[i] = region.ColorIndex
+	}
+	if improveLocalContrast(regions, regionAdjacency(regions, l.bounds), l.palette, img) == 0 {
+		return
+	}
+
+	width := l.bounds.Dx()
+	recolor := make(map[int]int)
+	for i, region := range regions {
+		if region.ColorIndex == original[i] {
+			continue
+		}
+		for _, p := range region.Pixels {
+			recolor[(p.Y-l.bounds.Min.Y)*width+(p.X-l.bounds.Min.X)] = region.ColorIndex
+		}
+	}
+
+	if l.points == nil {
+		for idx, c := range recolor {
+			l.colorIndices[idx] = c
+		}
+		return
+	}
+
+	// A Voronoi cell lies within one region, so its seed pixel tells which color it takes
+	for i, p := range l.points {
+		idx := (p.Y-l.bounds.Min.Y)*width + (p.X - l.bounds.Min.X)
+		if c, ok := recolor[idx]; ok && p.ColorIndex == assignment[idx] {
+			l.points[i].ColorIndex = c
+			l.points[i].Color = l.palette[c]
+		}
+	}
+	l.colorIndices = nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestImproveLocalContrastPushesSimilarNeighborsApart(t *testing.T) {
+	palette := []color.Color{
+		color.RGBA{200, 40, 40, 255},  // Red
+		color.RGBA{206, 44, 38, 255},  // A red almost nobody could tell from it
+		color.RGBA{230, 120, 40, 255}, // Orange
+		color.RGBA{40, 70, 200, 255},  // Blue
+	}
+	bounds := image.Rect(0, 0, 60, 30)
+	left, right := image.Rect(0, 0, 40, 30), image.Rect(40, 0, 60, 30)
+
+	// The right region looks a little orange, though it was quantized to the second red
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, left, &image.Uniform{palette[0]}, image.Point{}, draw.Src)
+	draw.Draw(img, right, &image.Uniform{color.RGBA{214, 70, 40, 255}}, image.Point{}, draw.Src)
+
+	regions := []Region{rectRegion(left, 0), rectRegion(right, 1)}
+	adjacency := regionAdjacency(regions, bounds)
+	if len(adjacency[0]) != 1 || adjacency[0][0] != 1 {
+		t.Fatalf("adjacency = %v, want the two regions touching", adjacency)
+	}
+
+	if changed := improveLocalContrast(regions, adjacency, palette, img); changed != 1 {
+		t.Errorf("%d regions changed, want 1", changed)
+	}
+	if regions[0].ColorIndex != 0 {
+		t.Errorf("larger region moved to color %d, want it to keep red", regions[0].ColorIndex)
+	}
+	if regions[1].ColorIndex != 2 {
+		t.Errorf("smaller region got color %d, want orange (2)", regions[1].ColorIndex)
+	}
+
+	// Already distinct neighbors are left alone
+	if changed := improveLocalContrast(regions, adjacency, palette, img); changed != 0 {
+		t.Errorf("second pass changed %d regions, want none", changed)
+	}
+}
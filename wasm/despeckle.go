@@ -0,0 +1,67 @@
+package main
+
+import "image"
+
+// maxDespeckle is the largest despeckle strength: a pixel sharing its color with more than
+// this many of its 8 neighbors is part of an edge or corner, not a speck
+const maxDespeckle = 3
+
+// despeckleAssignment reassigns every pixel that shares its palette index with fewer than
+// minRun of its 8 neighbors to the index most of its neighbors have, so salt-and-pepper
+// pixels left by quantization don't become unpaintable one-pixel regions. minRun 1 only
+// removes fully isolated pixels. Neighbors are read from the unchanged assignment, so one
+// pass never grows a speck into its surroundings. Returns how many pixels changed.
+func despeckleAssignment(assignment []int, bounds image.Rectangle, minRun int) int {
+	if minRun <= 0 {
+		return 0
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	original := make([]int, len(assignment))
+	copy(original, assignment)
+
+	changed := 0
+	var neighbors [8]int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			current := original[y*width+x]
+			count, same := 0, 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if (dx == 0 && dy == 0) || nx < 0 || nx >= width || ny < 0 || ny >= height {
+						continue
+					}
+					neighbors[count] = original[ny*width+nx]
+					if neighbors[count] == current {
+						same++
+					}
+					count++
+				}
+			}
+			if same >= minRun {
+				continue
+			}
+
+			// Majority neighbor color, ties going to the lower index so the result is stable
+			majority, best := current, 0
+			for i := 0; i < count; i++ {
+				votes := 0
+				for j := 0; j < count; j++ {
+					if neighbors[j] == neighbors[i] {
+						votes++
+					}
+				}
+				if votes > best || (votes == best && neighbors[i] < majority) {
+					majority, best = neighbors[i], votes
+				}
+			}
+			if majority != current {
+				assignment[y*width+x] = majority
+				changed++
+			}
+		}
+	}
+
+	return changed
+}
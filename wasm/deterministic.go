@@ -0,0 +1,21 @@
+package main
+
+// deterministicSeed is the seed Deterministic runs use when none is given
+const deterministicSeed = 1
+
+// reproducible pins down everything outside the inputs that could make two runs differ,
+// when Deterministic is set: a zero seed becomes deterministicSeed, and the time budget
+// and context are dropped, since where they cut processing short depends on how fast the
+// host happens to be. Parallel stages already merge their work in a fixed order, so they
+// need nothing here. Without Deterministic the options come back unchanged.
+func (o ProcessOptions) reproducible() ProcessOptions {
+	if !o.Deterministic {
+		return o
+	}
+	if o.Seed == 0 {
+		o.Seed = deterministicSeed
+	}
+	o.TimeBudget = 0
+	o.Context = nil
+	return o
+}
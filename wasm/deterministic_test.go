@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestDeterministicRunsAreByteIdentical(t *testing.T) {
+	img := syntheticImage(384)
+	args := testSheetArgs
+	args.maxDimension = 384
+	args.showColors = true
+
+	// Left alone, the seed is random and a 1 ms budget shrinks the sheet by how fast the host is
+	opts := map[string]interface{}{"deterministic": true, "timeBudget": 1, "paletteShards": 4, "texture": 0.3}
+	first := mustProcessImage(t, img, args, opts)
+	second := mustProcessImage(t, img, args, opts)
+
+	if first.Seed != deterministicSeed || second.Seed != deterministicSeed {
+		t.Errorf("seeds %d and %d, want the fixed %d", first.Seed, second.Seed, deterministicSeed)
+	}
+	if first.Degraded || first.Width != 384 {
+		t.Errorf("deterministic sheet is %dx%d, degraded %v; want the time budget ignored", first.Width, first.Height, first.Degraded)
+	}
+	if first.Image != second.Image {
+		t.Error("two deterministic runs gave different PNGs")
+	}
+}
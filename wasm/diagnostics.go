@@ -0,0 +1,56 @@
+package main
+
+import "time"
+
+// StageTiming is how long one pipeline stage took
+type StageTiming struct {
+	Stage  string  `json:"stage"`
+	Millis float64 `json:"ms"`
+}
+
+// Diagnostics reports where the time went in one processImage call, for support
+type Diagnostics struct {
+	InputWidth   int           `json:"inputWidth"`
+	InputHeight  int           `json:"inputHeight"`
+	OutputWidth  int           `json:"outputWidth"`
+	OutputHeight int           `json:"outputHeight"`
+	TotalMillis  float64       `json:"totalMs"`
+	Stages       []StageTiming `json:"stages"`
+}
+
+// stageTimer turns progress callbacks into per-stage timings. A stage runs from its first
+// report until a report names a different stage.
+type stageTimer struct {
+	stage   string
+	started time.Time
+	timings []StageTiming
+}
+
+// progress is a ProgressCallback that records stage changes
+func (t *stageTimer) progress(stage string, percent int) {
+	t.begin(stage)
+}
+
+// begin starts timing stage, closing the previous one. It does nothing on a nil timer, so
+// callers can time unconditionally.
+func (t *stageTimer) begin(stage string) {
+	if t == nil || stage == t.stage {
+		return
+	}
+	now := time.Now()
+	t.finish(now)
+	t.stage = stage
+	t.started = now
+}
+
+// finish closes the current stage at now
+func (t *stageTimer) finish(now time.Time) {
+	if t.stage == "" {
+		return
+	}
+	t.timings = append(t.timings, StageTiming{
+		Stage:  t.stage,
+		Millis: float64(now.Sub(t.started).Microseconds()) / 1000,
+	})
+	t.stage = ""
+}
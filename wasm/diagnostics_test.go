@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestDiagnosticsStagesAddUpToTotal(t *testing.T) {
+	result := mustProcessImage(t, syntheticImage(300), testSheetArgs, map[string]interface{}{"diagnostics": true})
+	d := result.Diagnostics
+	if d == nil {
+		t.Fatal("no diagnostics returned with diagnostics=true")
+	}
+	if d.InputWidth != 300 || d.InputHeight != 300 || d.OutputWidth == 0 || d.OutputHeight == 0 {
+		t.Errorf("sizes: input %dx%d, output %dx%d", d.InputWidth, d.InputHeight, d.OutputWidth, d.OutputHeight)
+	}
+	if len(d.Stages) < 3 {
+		t.Fatalf("only %d stages timed: %+v", len(d.Stages), d.Stages)
+	}
+
+	sum := 0.0
+	for _, s := range d.Stages {
+		if s.Millis <= 0 {
+			t.Errorf("stage %q took %vms, want a positive duration", s.Stage, s.Millis)
+		}
+		sum += s.Millis
+	}
+	if d.TotalMillis <= 0 || sum > d.TotalMillis || sum < d.TotalMillis*0.8 {
+		t.Errorf("stages sum to %.1fms of a %.1fms total", sum, d.TotalMillis)
+	}
+
+	if result := mustProcessImage(t, syntheticImage(64), testSheetArgs, nil); result.Diagnostics != nil {
+		t.Error("diagnostics returned without diagnostics=true")
+	}
+}
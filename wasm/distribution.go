@@ -0,0 +1,96 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// PointDistribution selects where Voronoi seed points concentrate
+type PointDistribution int
+
+const (
+	// DistributionEdge favors high-detail areas found by edge detection (the default)
+	DistributionEdge PointDistribution = iota
+	// DistributionUniform spreads points evenly, for flat, poster-like cells
+	DistributionUniform
+	// DistributionCenter falls off like a Gaussian away from the image center, for portraits
+	DistributionCenter
+	// DistributionCustom follows a caller-supplied density map, brighter meaning denser
+	DistributionCustom
+	// DistributionMultiScale favors edges found at fine and coarse scales, so both small
+	// details and large soft structure attract points (the multiScale option)
+	DistributionMultiScale
+)
+
+// centerFloor keeps some points at the image border under DistributionCenter, and
+// densityFloor keeps some in black areas of a custom density map
+const (
+	centerFloor  = 0.1
+	densityFloor = 0.02
+)
+
+// parsePointDistribution converts a distribution name to a PointDistribution
+func parsePointDistribution(name string) (PointDistribution, bool) {
+	switch name {
+	case "", "edge":
+		return DistributionEdge, true
+	case "uniform":
+		return DistributionUniform, true
+	case "center":
+		return DistributionCenter, true
+	case "custom":
+		return DistributionCustom, true
+	}
+	return DistributionEdge, false
+}
+
+// weights returns the relative chance of each pixel of img (row-major) becoming a seed
+// point. density is only used by DistributionCustom and is stretched to img's size.
+func (d PointDistribution) weights(img image.Image, density image.Image) []float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	weights := make([]float64, width*height)
+
+	switch d {
+	case DistributionUniform:
+		for i := range weights {
+			weights[i] = 1
+		}
+
+	case DistributionCenter:
+		// One standard deviation reaches a third of the shorter side from the center
+		sigma := float64(min(width, height)) / 3
+		cx, cy := float64(width-1)/2, float64(height-1)/2
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				dx, dy := float64(x)-cx, float64(y)-cy
+				weights[y*width+x] = centerFloor + math.Exp(-(dx*dx+dy*dy)/(2*sigma*sigma))
+			}
+		}
+
+	case DistributionCustom:
+		if db := density.Bounds(); db.Dx() != width || db.Dy() != height {
+			density = resizeBilinear(density, width, height)
+		}
+		db := density.Bounds()
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				weights[y*width+x] = densityFloor + luminance(density.At(x+db.Min.X, y+db.Min.Y))
+			}
+		}
+
+	default:
+		// Higher edge strength = higher weight
+		var edgeMap []float64
+		if d == DistributionMultiScale {
+			edgeMap = computeMultiScaleEdgeMap(img)
+		} else {
+			edgeMap = computeEdgeMap(img)
+		}
+		for i, edge := range edgeMap {
+			weights[i] = 1.0 + edge*10.0 // Bias toward edges
+		}
+	}
+
+	return weights
+}
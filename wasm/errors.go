@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Error kinds reported by the conversion pipeline. Match them with errors.Is; the
+// result JSON carries the matching errorCode so JavaScript callers can branch on it.
+var (
+	ErrInvalidParam      = errors.New("invalid parameter")
+	ErrDecode            = errors.New("image decode failed")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image too large")
+	ErrTimeout           = errors.New("conversion timed out")
+	ErrInternal          = errors.New("internal error")
+)
+
+// ConversionError is a user-facing message tagged with one of the error kinds above
+type ConversionError struct {
+	Kind    error
+	Message string
+}
+
+func (e *ConversionError) Error() string { return e.Message }
+
+func (e *ConversionError) Unwrap() error { return e.Kind }
+
+// conversionError builds a ConversionError of the given kind
+func conversionError(kind error, format string, args ...interface{}) error {
+	return &ConversionError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// invalidParam builds an ErrInvalidParam error
+func invalidParam(format string, args ...interface{}) error {
+	return conversionError(ErrInvalidParam, format, args...)
+}
+
+// decodeError classifies an image.Decode failure
+func decodeError(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return conversionError(ErrUnsupportedFormat, "Unsupported image format: %v", err)
+	}
+	return conversionError(ErrDecode, "Failed to decode image: %v", err)
+}
+
+// budgetExceeded returns an ErrTimeout error once ctx, which carries the time budget of
+// budget milliseconds, is past its deadline, and nil before then
+func budgetExceeded(ctx context.Context, budget int) error {
+	if !clusteringDone(ctx) {
+		return nil
+	}
+	return conversionError(ErrTimeout, "Processing ran past the time budget of %d ms; allow more time or lower the max dimension", budget)
+}
+
+// errorCode maps an error to the stable code returned to JavaScript
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidParam):
+		return "invalid_param"
+	case errors.Is(err, ErrUnsupportedFormat):
+		return "unsupported_format"
+	case errors.Is(err, ErrDecode):
+		return "decode"
+	case errors.Is(err, ErrTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	default:
+		return "internal"
+	}
+}
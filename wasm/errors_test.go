@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"syscall/js"
+	"testing"
+	"time"
+)
+
+func TestErrorCodeMapsEachKind(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		kind error
+		code string
+	}{
+		{invalidParam("Points must be between %d and %d", 50, 50000), ErrInvalidParam, "invalid_param"},
+		{decodeError(image.ErrFormat), ErrUnsupportedFormat, "unsupported_format"},
+		{decodeError(errors.New("unexpected EOF")), ErrDecode, "decode"},
+		{conversionError(ErrTooLarge, "Image too large"), ErrTooLarge, "too_large"},
+		{conversionError(ErrTimeout, "Processing ran past the time budget"), ErrTimeout, "timeout"},
+		{conversionError(ErrInternal, "Failed to encode"), ErrInternal, "internal"},
+		{errors.New("untyped"), nil, "internal"},
+	} {
+		// Wrapping keeps the kind visible to errors.Is and errors.As
+		wrapped := fmt.Errorf("processing: %w", tc.err)
+		if got := errorCode(wrapped); got != tc.code {
+			t.Errorf("errorCode(%v) = %q, want %q", tc.err, got, tc.code)
+		}
+		if tc.kind == nil {
+			continue
+		}
+		if !errors.Is(wrapped, tc.kind) {
+			t.Errorf("errors.Is(%v, %v) = false", tc.err, tc.kind)
+		}
+		var ce *ConversionError
+		if !errors.As(wrapped, &ce) || ce.Kind != tc.kind {
+			t.Errorf("errors.As(%v) did not find a ConversionError of kind %v", tc.err, tc.kind)
+		}
+	}
+}
+
+func TestProcessImageReportsErrorCodes(t *testing.T) {
+	badPoints := testSheetArgs
+	badPoints.points = 10
+	if result := callProcessImage(t, syntheticImage(64), badPoints, nil); result.ErrorCode != "invalid_param" {
+		t.Errorf("10 points: errorCode %q (%s), want invalid_param", result.ErrorCode, result.Error)
+	}
+
+	if result := callProcessImageBytes(t, []byte("not an image at all"), testSheetArgs, nil); result.ErrorCode != "unsupported_format" {
+		t.Errorf("garbage bytes: errorCode %q (%s), want unsupported_format", result.ErrorCode, result.Error)
+	}
+}
+
+func TestOverrunTimeBudgetFailsWithTimeout(t *testing.T) {
+	// 1 ms is gone before the layout starts, even at the smallest sheet
+	result := callProcessImage(t, syntheticImage(512), testSheetArgs, map[string]interface{}{"timeBudget": 1})
+	if result.ErrorCode != "timeout" || result.Image != "" {
+		t.Errorf("1 ms budget: errorCode %q (%s), want timeout and no image", result.ErrorCode, result.Error)
+	}
+
+	// A deadline passing while the layout is built is caught before encoding
+	beforeLayoutHook = func() { time.Sleep(300 * time.Millisecond) }
+	defer func() { beforeLayoutHook = nil }()
+	projectCost = func(image.Image, int, int, int, bool, bool, ProcessOptions) float64 { return 0 }
+	defer func() { projectCost = projectedMillis }()
+	result = callProcessImage(t, syntheticImage(128), testSheetArgs, map[string]interface{}{"timeBudget": 250})
+	if result.ErrorCode != "timeout" {
+		t.Errorf("deadline passed during layout: errorCode %q (%s), want timeout", result.ErrorCode, result.Error)
+	}
+
+	beforeLayoutHook = nil
+	if result := callProcessImage(t, syntheticImage(128), testSheetArgs, map[string]interface{}{"timeBudget": 600000}); result.Error != "" {
+		t.Errorf("generous budget failed: %s", result.Error)
+	}
+}
+
+func TestZeroAndNegativeCountsAreRejected(t *testing.T) {
+	img := syntheticImage(64)
+	recipe := mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"exportRecipe": true}).Recipe
+
+	for _, tc := range []struct {
+		name  string
+		apply func(args *sheetArgs)
+		field string // Recipe field set to the same value
+		value int
+	}{
+		{"points 0", func(a *sheetArgs) { a.points = 0 }, "points", 0},
+		{"points -200", func(a *sheetArgs) { a.points = -200 }, "points", -200},
+		{"colors 0", func(a *sheetArgs) { a.colors = 0 }, "colors", 0},
+		{"colors -6", func(a *sheetArgs) { a.colors = -6 }, "colors", -6},
+	} {
+		// Passed as arguments
+		args := testSheetArgs
+		tc.apply(&args)
+		if result := callProcessImage(t, img, args, nil); result.ErrorCode != "invalid_param" {
+			t.Errorf("%s: errorCode %q (%s), want invalid_param", tc.name, result.ErrorCode, result.Error)
+		}
+
+		// Replayed from a recipe
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(recipe), &fields); err != nil {
+			t.Fatal(err)
+		}
+		fields[tc.field] = tc.value
+		edited, _ := json.Marshal(fields)
+		if result := callProcessImage(t, img, testSheetArgs, map[string]interface{}{"recipe": string(edited)}); result.ErrorCode != "invalid_param" {
+			t.Errorf("recipe with %s: errorCode %q (%s), want invalid_param", tc.name, result.ErrorCode, result.Error)
+		}
+	}
+
+	// A count that is not a number is reported, not a panic
+	jsArgs := []js.Value{jsBytes(encodeTestPNG(t, img)), js.ValueOf("200"), js.ValueOf(6), js.ValueOf(1), js.ValueOf(256), js.ValueOf(false), js.ValueOf(true)}
+	var result ProcessResult
+	if err := json.Unmarshal([]byte(processImage(js.Undefined(), jsArgs).(string)), &result); err != nil || result.ErrorCode != "invalid_param" {
+		t.Errorf("points as a string: errorCode %q (%s, %v), want invalid_param", result.ErrorCode, result.Error, err)
+	}
+}
+
+func TestPanicFailsOnlyThatCall(t *testing.T) {
+	data := encodeTestPNG(t, syntheticImage(64))
+	call := func(opts map[string]interface{}) ProcessResult {
+		jsArgs := []js.Value{jsBytes(data), js.ValueOf(200), js.ValueOf(6), js.ValueOf(1), js.ValueOf(256), js.ValueOf(false), js.ValueOf(true), js.ValueOf(opts)}
+		var result ProcessResult
+		if err := json.Unmarshal([]byte(recovering("processImage", processImage)(js.Undefined(), jsArgs).(string)), &result); err != nil {
+			t.Fatalf("processImage returned invalid JSON: %v", err)
+		}
+		return result
+	}
+
+	beforeLayoutHook = func() { panic("injected failure") }
+	defer func() { beforeLayoutHook = nil }()
+	for _, tc := range []struct {
+		opts  map[string]interface{}
+		token string
+	}{
+		{map[string]interface{}{"seed": 77}, "seed 77"},
+		{map[string]interface{}{}, "random seed"},
+	} {
+		result := call(tc.opts)
+		if result.ErrorCode != "internal" || !strings.Contains(result.Error, "injected failure") || !strings.Contains(result.Error, "("+tc.token+")") {
+			t.Errorf("panicking call: errorCode %q, error %q; want internal, naming %q and the panic", result.ErrorCode, result.Error, tc.token)
+		}
+	}
+
+	// The next call runs normally
+	beforeLayoutHook = nil
+	if result := call(map[string]interface{}{"seed": 77}); result.Error != "" || result.Image == "" {
+		t.Errorf("call after a panic failed: %s", result.Error)
+	}
+}
+
+func TestCallTokenNamesRecipeOrSeed(t *testing.T) {
+	for _, tc := range []struct {
+		args []js.Value
+		want string
+	}{
+		{nil, "no options"},
+		{[]js.Value{js.ValueOf(map[string]interface{}{"seed": 12})}, "seed 12"},
+		{[]js.Value{js.ValueOf(map[string]interface{}{"seed": 12, "recipe": "{}"})}, "recipe a3a6bf43"},
+		{[]js.Value{jsBytes([]byte{1, 2, 3})}, "random seed"},
+	} {
+		if got := callToken(tc.args); got != tc.want {
+			t.Errorf("callToken(%v) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
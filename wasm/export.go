@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// paletteToCSV renders the palette as CSV for spreadsheet-based paint mixing
+func paletteToCSV(palette []ColorInfo) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+
+	w.Write([]string{"number", "hex", "R", "G", "B", "C", "M", "Y", "K", "coverage"})
+	for _, c := range palette {
+		w.Write([]string{
+			strconv.Itoa(c.Number),
+			c.Hex,
+			strconv.Itoa(c.R),
+			strconv.Itoa(c.G),
+			strconv.Itoa(c.B),
+			strconv.Itoa(c.C),
+			strconv.Itoa(c.M),
+			strconv.Itoa(c.Y),
+			strconv.Itoa(c.K),
+			strconv.FormatFloat(c.Coverage, 'f', 4, 64),
+		})
+	}
+	w.Flush()
+
+	return sb.String()
+}
+
+// paletteToGPL renders the palette as a GIMP palette file, which GIMP, Inkscape, Krita and
+// Aseprite import: a header, then one "R G B<tab>name" line per color
+func paletteToGPL(palette []ColorInfo) string {
+	var sb strings.Builder
+	sb.WriteString("GIMP Palette\n")
+	sb.WriteString("Name: Paint by Numbers\n")
+	sb.WriteString("Columns: 8\n")
+	sb.WriteString("#\n")
+	for _, c := range palette {
+		fmt.Fprintf(&sb, "%3d %3d %3d\t%d %s\n", c.R, c.G, c.B, c.Number, c.Hex)
+	}
+	return sb.String()
+}
+
+// paletteToASE renders the palette as an Adobe Swatch Exchange file for Photoshop,
+// Illustrator and InDesign: the "ASEF" signature, version 1.0 and a block count, then one
+// color entry block per color holding its UTF-16 name ("<number> <hex>"), the RGB model
+// and three 0-1 float channels. All values are big-endian.
+func paletteToASE(palette []ColorInfo) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("ASEF")
+	binary.Write(&buf, binary.BigEndian, [2]uint16{1, 0})
+	binary.Write(&buf, binary.BigEndian, uint32(len(palette)))
+
+	for _, c := range palette {
+		name := utf16.Encode([]rune(fmt.Sprintf("%d %s", c.Number, c.Hex)))
+		name = append(name, 0)
+
+		var block bytes.Buffer
+		binary.Write(&block, binary.BigEndian, uint16(len(name)))
+		binary.Write(&block, binary.BigEndian, name)
+		block.WriteString("RGB ")
+		binary.Write(&block, binary.BigEndian, [3]float32{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255})
+		binary.Write(&block, binary.BigEndian, uint16(2)) // Normal (not global or spot) color
+
+		binary.Write(&buf, binary.BigEndian, uint16(0x0001)) // Color entry
+		binary.Write(&buf, binary.BigEndian, uint32(block.Len()))
+		buf.Write(block.Bytes())
+	}
+	return buf.Bytes()
+}
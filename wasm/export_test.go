@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testPaletteInfo is a small palette as processImage reports it
+var testPaletteInfo = []ColorInfo{
+	{Number: 1, Hex: "#dc2828", R: 220, G: 40, B: 40, C: 0, M: 81, Y: 81, K: 13, Coverage: 0.5},
+	{Number: 2, Hex: "#28a03c", R: 40, G: 160, B: 60, C: 75, M: 0, Y: 62, K: 37, Coverage: 0.3},
+	{Number: 3, Hex: "#ffffff", R: 255, G: 255, B: 255, Coverage: 0.2},
+}
+
+func TestPaletteToCSVParsesBack(t *testing.T) {
+	records, err := csv.NewReader(strings.NewReader(paletteToCSV(testPaletteInfo))).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV does not parse: %v", err)
+	}
+	if len(records) != len(testPaletteInfo)+1 {
+		t.Fatalf("CSV has %d rows, want %d colors plus a header", len(records), len(testPaletteInfo))
+	}
+
+	header := strings.Join(records[0], ",")
+	if header != "number,hex,R,G,B,C,M,Y,K,coverage" {
+		t.Errorf("header = %q", header)
+	}
+	for i, row := range records[1:] {
+		c := testPaletteInfo[i]
+		if row[0] != strconv.Itoa(c.Number) || row[1] != c.Hex || row[2] != strconv.Itoa(c.R) || row[6] != strconv.Itoa(c.M) {
+			t.Errorf("row %d = %v, want %+v", i+1, row, c)
+		}
+		if coverage, err := strconv.ParseFloat(row[9], 64); err != nil || coverage != c.Coverage {
+			t.Errorf("row %d coverage = %q, want %v", i+1, row[9], c.Coverage)
+		}
+	}
+}
+
+// paletteFieldNames returns the keys of the first palette entry in a result JSON
+func paletteFieldNames(t *testing.T, data []byte) map[string]bool {
+	t.Helper()
+	var raw struct {
+		Image   string                   `json:"image"`
+		Palette []map[string]interface{} `json:"palette"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("result does not parse: %v", err)
+	}
+	if raw.Image == "" || len(raw.Palette) == 0 {
+		t.Fatalf("result has no image or palette: %s", data)
+	}
+	names := make(map[string]bool)
+	for name := range raw.Palette[0] {
+		names[name] = true
+	}
+	return names
+}
+
+func TestCompactAndVerbosePaletteShapes(t *testing.T) {
+	result := ProcessResult{Image: "aW1n", Palette: testPaletteInfo}
+
+	compact, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verbose, err := json.Marshal(verboseProcessResult{ProcessResult: result, Palette: verbosePalette(testPaletteInfo)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		want, not []string
+	}{
+		{"compact", compact, []string{"r", "g", "b", "c", "m", "y", "k"}, []string{"red", "cyan"}},
+		{"verbose", verbose, []string{"red", "green", "blue", "cyan", "magenta", "yellow", "black"}, []string{"r", "c", "k"}},
+	} {
+		names := paletteFieldNames(t, tc.data)
+		for _, n := range append(tc.want, "number", "hex", "coverage") {
+			if !names[n] {
+				t.Errorf("%s palette has no %q field: %s", tc.name, n, tc.data)
+			}
+		}
+		for _, n := range tc.not {
+			if names[n] {
+				t.Errorf("%s palette has a %q field", tc.name, n)
+			}
+		}
+	}
+
+	var decoded struct {
+		Palette []VerboseColorInfo `json:"palette"`
+	}
+	if err := json.Unmarshal(verbose, &decoded); err != nil || decoded.Palette[0].Magenta != testPaletteInfo[0].M || decoded.Palette[1].Green != testPaletteInfo[1].G {
+		t.Errorf("verbose palette decoded to %+v (%v), want the values of %+v", decoded.Palette, err, testPaletteInfo)
+	}
+}
+
+// parseGPL reads the colors of a GIMP palette file the way GIMP does: the magic first line,
+// optional Name and Columns headers, comments, then "R G B name" lines
+func parseGPL(t *testing.T, text string) [][3]int {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if lines[0] != "GIMP Palette" {
+		t.Fatalf("first line %q, want GIMP Palette", lines[0])
+	}
+	var colors [][3]int
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			t.Fatalf("color line %q has no R G B", line)
+		}
+		var rgb [3]int
+		for i := range rgb {
+			v, err := strconv.Atoi(fields[i])
+			if err != nil || v < 0 || v > 255 {
+				t.Fatalf("color line %q: channel %q is not 0-255", line, fields[i])
+			}
+			rgb[i] = v
+		}
+		colors = append(colors, rgb)
+	}
+	return colors
+}
+
+func TestPaletteToGPLParses(t *testing.T) {
+	colors := parseGPL(t, paletteToGPL(testPaletteInfo))
+	if len(colors) != len(testPaletteInfo) {
+		t.Fatalf("GPL has %d colors, want %d", len(colors), len(testPaletteInfo))
+	}
+	for i, c := range testPaletteInfo {
+		if colors[i] != [3]int{c.R, c.G, c.B} {
+			t.Errorf("color %d = %v, want %d %d %d", i+1, colors[i], c.R, c.G, c.B)
+		}
+	}
+
+	result := mustProcessImage(t, syntheticImage(64), testSheetArgs, map[string]interface{}{"format": "gpl"})
+	if got := len(parseGPL(t, result.GPL)); got != len(result.Palette) {
+		t.Errorf("format gpl returned %d colors for a %d color palette", got, len(result.Palette))
+	}
+}
+
+// aseSwatch is one color entry read back from an ASE file
+type aseSwatch struct {
+	name string
+	rgb  [3]int
+}
+
+// parseASE reads the color entries of an Adobe Swatch Exchange file, failing on anything
+// that does not follow the format
+func parseASE(t *testing.T, data []byte) []aseSwatch {
+	t.Helper()
+	r := bytes.NewReader(data)
+	read := func(v interface{}) {
+		t.Helper()
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			t.Fatalf("ASE ends early: %v", err)
+		}
+	}
+
+	var header struct {
+		Signature [4]byte
+		Version   [2]uint16
+		Blocks    uint32
+	}
+	read(&header)
+	if string(header.Signature[:]) != "ASEF" || header.Version != [2]uint16{1, 0} {
+		t.Fatalf("ASE header %q version %v, want ASEF 1.0", header.Signature, header.Version)
+	}
+
+	var swatches []aseSwatch
+	for i := uint32(0); i < header.Blocks; i++ {
+		var kind uint16
+		var length uint32
+		read(&kind)
+		read(&length)
+		if kind != 0x0001 {
+			t.Fatalf("block %d has type %#x, want a color entry", i, kind)
+		}
+		start := r.Len()
+
+		var nameLen uint16
+		read(&nameLen)
+		name := make([]uint16, nameLen)
+		read(name)
+		var model [4]byte
+		read(&model)
+		if string(model[:]) != "RGB " {
+			t.Fatalf("block %d color model %q, want RGB", i, model)
+		}
+		var channels [3]float32
+		var colorType uint16
+		read(&channels)
+		read(&colorType)
+		if consumed := start - r.Len(); uint32(consumed) != length {
+			t.Fatalf("block %d declares %d bytes but holds %d", i, length, consumed)
+		}
+
+		swatch := aseSwatch{name: string(utf16.Decode(name[:len(name)-1]))}
+		for c, v := range channels {
+			swatch.rgb[c] = int(math.Round(float64(v) * 255))
+		}
+		swatches = append(swatches, swatch)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes after the last block", r.Len())
+	}
+	return swatches
+}
+
+func TestPaletteToASERoundTrips(t *testing.T) {
+	swatches := parseASE(t, paletteToASE(testPaletteInfo))
+	if len(swatches) != len(testPaletteInfo) {
+		t.Fatalf("ASE has %d swatches, want %d", len(swatches), len(testPaletteInfo))
+	}
+	for i, c := range testPaletteInfo {
+		want := aseSwatch{name: strconv.Itoa(c.Number) + " " + c.Hex, rgb: [3]int{c.R, c.G, c.B}}
+		if swatches[i] != want {
+			t.Errorf("swatch %d = %+v, want %+v", i+1, swatches[i], want)
+		}
+	}
+
+	result := mustProcessImage(t, syntheticImage(64), testSheetArgs, map[string]interface{}{"format": "ase"})
+	if got := len(parseASE(t, decodeBase64(t, result.ASE))); got != len(result.Palette) {
+		t.Errorf("format ase returned %d swatches for a %d color palette", got, len(result.Palette))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// harrisK is the usual Harris detector sensitivity: larger values reject more edges
+	// that are not corners
+	harrisK = 0.04
+	// harrisThreshold keeps corners whose response is at least this fraction of the strongest
+	harrisThreshold = 0.01
+	// featureMinDistance is the least spacing between two feature points in pixels, so one
+	// blurry corner does not seed a cluster of tiny cells
+	featureMinDistance = 4
+	// featureShare is the part of the seed points features may take (one in featureShare);
+	// the rest still follow the chosen distribution
+	featureShare = 2
+)
+
+// detectFeaturePoints finds up to max corners in img with a Harris detector (Sobel
+// gradients, 3×3 structure tensor), strongest first, no two closer than featureMinDistance
+func detectFeaturePoints(img image.Image, max int) []image.Point {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if max <= 0 || width < 5 || height < 5 {
+		return nil
+	}
+
+	gray := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray[y*width+x] = luminance(img.At(x+bounds.Min.X, y+bounds.Min.Y))
+		}
+	}
+
+	// Products of the Sobel gradients, the entries of the structure tensor
+	ixx := make([]float64, width*height)
+	iyy := make([]float64, width*height)
+	ixy := make([]float64, width*height)
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			at := func(dx, dy int) float64 { return gray[(y+dy)*width+x+dx] }
+			gx := at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1)
+			gy := at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1)
+			i := y*width + x
+			ixx[i], iyy[i], ixy[i] = gx*gx, gy*gy, gx*gy
+		}
+	}
+
+	// Corner response over each 3×3 window
+	response := make([]float64, width*height)
+	strongest := 0.0
+	for y := 2; y < height-2; y++ {
+		for x := 2; x < width-2; x++ {
+			var sxx, syy, sxy float64
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					i := (y+dy)*width + x + dx
+					sxx += ixx[i]
+					syy += iyy[i]
+					sxy += ixy[i]
+				}
+			}
+			trace := sxx + syy
+			r := sxx*syy - sxy*sxy - harrisK*trace*trace
+			response[y*width+x] = r
+			if r > strongest {
+				strongest = r
+			}
+		}
+	}
+	if strongest <= 0 {
+		return nil
+	}
+
+	// Keep local maxima above the threshold
+	var candidates []int
+	for y := 2; y < height-2; y++ {
+		for x := 2; x < width-2; x++ {
+			i := y*width + x
+			r := response[i]
+			if r < strongest*harrisThreshold {
+				continue
+			}
+			isMax := true
+			for dy := -1; dy <= 1 && isMax; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && response[i+dy*width+dx] > r {
+						isMax = false
+						break
+					}
+				}
+			}
+			if isMax {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return response[candidates[a]] > response[candidates[b]]
+	})
+
+	var features []image.Point
+	for _, i := range candidates {
+		p := image.Point{X: i%width + bounds.Min.X, Y: i/width + bounds.Min.Y}
+		crowded := false
+		for _, f := range features {
+			dx, dy := p.X-f.X, p.Y-f.Y
+			if dx*dx+dy*dy < featureMinDistance*featureMinDistance {
+				crowded = true
+				break
+			}
+		}
+		if !crowded {
+			features = append(features, p)
+		}
+		if len(features) == max {
+			break
+		}
+	}
+	return features
+}
+
+// generateFeatureVoronoiPoints seeds points at up to numPoints/featureShare detected
+// corners and fills the rest of numPoints by sampling the chosen distribution. Corners
+// outside inside (when set) are passed over.
+func generateFeatureVoronoiPoints(img image.Image, numPoints int, distribution PointDistribution, density image.Image, inside []bool, progress ProgressCallback, rng *rand.Rand) []Point {
+	if progress != nil {
+		progress("Detecting features", 3)
+	}
+	features := detectFeaturePoints(img, numPoints/featureShare)
+
+	points := make([]Point, 0, numPoints)
+	bounds := img.Bounds()
+	for _, p := range features {
+		if inside != nil && !inside[(p.Y-bounds.Min.Y)*bounds.Dx()+(p.X-bounds.Min.X)] {
+			continue
+		}
+		points = append(points, Point{X: p.X, Y: p.Y, Color: img.At(p.X, p.Y), Index: len(points)})
+	}
+	for _, p := range generateVoronoiPointsWithDistribution(img, numPoints-len(points), distribution, density, inside, progress, rng) {
+		p.Index = len(points)
+		points = append(points, p)
+	}
+	return points
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+)
+
+func TestFeaturePointsLandOnCorners(t *testing.T) {
+	// A black rectangle on white has four corners and otherwise only straight edges
+	img := image.NewRGBA(image.Rect(0, 0, 90, 70))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	rect := image.Rect(20, 15, 65, 50)
+	draw.Draw(img, rect, image.Black, image.Point{}, draw.Src)
+	corners := []image.Point{rect.Min, {rect.Max.X, rect.Min.Y}, {rect.Min.X, rect.Max.Y}, rect.Max}
+
+	near := func(p, q image.Point) bool {
+		d := p.Sub(q)
+		return d.X >= -3 && d.X <= 3 && d.Y >= -3 && d.Y <= 3
+	}
+	features := detectFeaturePoints(img, 20)
+	for _, f := range features {
+		onCorner := false
+		for _, c := range corners {
+			onCorner = onCorner || near(f, c)
+		}
+		if !onCorner {
+			t.Errorf("feature at %v is not near a corner of %v", f, rect)
+		}
+	}
+	for _, c := range corners {
+		found := false
+		for _, f := range features {
+			found = found || near(f, c)
+		}
+		if !found {
+			t.Errorf("no feature near corner %v; found %v", c, features)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"sort"
+)
+
+// fixedPalettes are the built-in catalogs selectable with the fixedPalette option.
+// When one is chosen, k-means is skipped and points are quantized straight to the
+// catalog colors the image uses most (see catalogColorsInUse).
+var fixedPalettes = map[string][]color.Color{
+	"websafe": webSafePalette(),
+	"paint24": paint24Palette,
+}
+
+// webSafePalette builds the 216-color web-safe cube (each channel one of 00, 33, 66, 99, CC, FF)
+func webSafePalette() []color.Color {
+	palette := make([]color.Color, 0, 216)
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				palette = append(palette, color.RGBA{uint8(r * 51), uint8(g * 51), uint8(b * 51), 255})
+			}
+		}
+	}
+	return palette
+}
+
+// paint24Palette is a basic 24-tube acrylic set, approximated in sRGB
+var paint24Palette = []color.Color{
+	color.RGBA{255, 255, 255, 255}, // Titanium White
+	color.RGBA{20, 20, 20, 255},    // Mars Black
+	color.RGBA{128, 128, 128, 255}, // Neutral Gray
+	color.RGBA{84, 88, 95, 255},    // Payne's Gray
+	color.RGBA{255, 236, 64, 255},  // Lemon Yellow
+	color.RGBA{255, 200, 0, 255},   // Cadmium Yellow Medium
+	color.RGBA{227, 168, 87, 255},  // Yellow Ochre
+	color.RGBA{255, 128, 0, 255},   // Cadmium Orange
+	color.RGBA{227, 0, 34, 255},    // Cadmium Red Light
+	color.RGBA{175, 17, 41, 255},   // Alizarin Crimson
+	color.RGBA{215, 38, 127, 255},  // Quinacridone Magenta
+	color.RGBA{251, 174, 185, 255}, // Light Pink
+	color.RGBA{100, 50, 130, 255},  // Dioxazine Purple
+	color.RGBA{18, 10, 143, 255},   // Ultramarine Blue
+	color.RGBA{0, 71, 171, 255},    // Cobalt Blue
+	color.RGBA{0, 49, 83, 255},     // Prussian Blue
+	color.RGBA{0, 123, 167, 255},   // Cerulean Blue
+	color.RGBA{0, 158, 150, 255},   // Turquoise
+	color.RGBA{0, 110, 81, 255},    // Phthalo Green
+	color.RGBA{80, 160, 60, 255},   // Permanent Green Light
+	color.RGBA{107, 142, 35, 255},  // Sap Green
+	color.RGBA{138, 54, 15, 255},   // Burnt Sienna
+	color.RGBA{99, 81, 71, 255},    // Burnt Umber
+	color.RGBA{230, 214, 180, 255}, // Unbleached Titanium
+}
+
+// layoutPalette returns the palette locked in opts as is, the most used numColors colors
+// of the fixed palette selected in opts, the image's own colors if it is already flat, or
+// a k-means palette of numColors shifted by opts.Temperature, with colors within
+// opts.MergeDistance of each other merged. Only pixels inside opts.Mask count.
+func layoutPalette(img image.Image, numColors int, opts ProcessOptions, rng *rand.Rand) []color.Color {
+	if opts.Palette != nil {
+		return opts.Palette
+	}
+	// Only the masked subject gets painted, so only its colors belong in the palette
+	img = maskedSamples(img, maskInside(opts.Mask, img.Bounds()))
+	if fixed, ok := fixedPalettes[opts.FixedPalette]; ok {
+		return catalogColorsInUse(img, fixed, numColors, opts, rng)
+	}
+	// Clustering would blur the exact colors of line art, so keep them as they are
+	if flat := flatImagePalette(img, numColors); flat != nil {
+		return flat
+	}
+	palette := shiftTemperature(clusterPalette(img, numColors, opts, rng), opts.Temperature)
+	return mergeSimilarColors(palette, opts.MergeDistance)
+}
+
+// catalogColorsInUse returns the at most numColors catalog colors that sampled pixels of
+// img are most often nearest to, most used first. A sheet then numbers only the paints it
+// needs, never more than numColors of them, rather than all 216 web-safe colors.
+func catalogColorsInUse(img image.Image, catalog []color.Color, numColors int, opts ProcessOptions, rng *rand.Rand) []color.Color {
+	counts := make([]int, len(catalog))
+	for _, p := range paletteSamplePoints(img.Bounds(), paletteSampleStep, opts.PaletteSamples, rng) {
+		counts[findNearestColorWithMetric(img.At(p.X, p.Y), catalog, opts.ColorMetric)]++
+	}
+
+	var used []int
+	for i, n := range counts {
+		if n > 0 {
+			used = append(used, i)
+		}
+	}
+	sort.SliceStable(used, func(a, b int) bool { return counts[used[a]] > counts[used[b]] })
+	if len(used) > numColors {
+		used = used[:numColors]
+	}
+
+	palette := make([]color.Color, len(used))
+	for i, idx := range used {
+		palette[i] = catalog[idx]
+	}
+	return palette
+}
+
+// clusteringChoices names the options in o that each pick a palette clustering variant.
+// clusterPalette runs only one of them, so more than one is an invalid combination.
+func (o ProcessOptions) clusteringChoices() []string {
+	var chosen []string
+	if o.SpatialWeight > 0 {
+		chosen = append(chosen, "spatialWeight")
+	}
+	if o.ColorMetric == MetricCMYK {
+		chosen = append(chosen, "colorMetric cmyk")
+	}
+	if o.WeightedKMeans {
+		chosen = append(chosen, "weightedKMeans")
+	}
+	if o.PaletteShards > 1 {
+		chosen = append(chosen, "paletteShards")
+	}
+	if o.MiniBatch {
+		chosen = append(chosen, "miniBatch")
+	}
+	return chosen
+}
+
+// clusterPalette returns a k-means palette of numColors, clustered on color and position
+// when a spatial weight is set, in CMYK for the cmyk metric, over a histogram of every
+// pixel with opts.WeightedKMeans, in shards or mini-batches when asked, on a downsampled
+// copy when opts.PaletteSize is set. Every variant stops iterating early, keeping its
+// centroids so far, once opts.Context is done.
+func clusterPalette(img image.Image, numColors int, opts ProcessOptions, rng *rand.Rand) []color.Color {
+	ctx := opts.context()
+
+	// Optionally cluster a small copy instead. It is already coarse, so every one of its
+	// pixels is sampled rather than every paletteSampleStep-th.
+	step := paletteSampleStep
+	if opts.PaletteSize > 0 {
+		img = downsampleImageWithGamma(img, opts.PaletteSize, opts.GammaCorrect)
+		step = 1
+	}
+
+	if opts.SpatialWeight > 0 {
+		return generateSpatialPalette(ctx, img, numColors, opts.SpatialWeight, step, opts.PaletteSamples, rng)
+	}
+	if opts.ColorMetric == MetricCMYK {
+		return generateCMYKPalette(ctx, img, numColors, step, opts.PaletteSamples, rng)
+	}
+	if opts.WeightedKMeans {
+		return generateHistogramPalette(ctx, img, numColors, opts.ColorMetric, rng)
+	}
+	if opts.PaletteShards > 1 {
+		return generateShardedPalette(ctx, img, numColors, opts.ColorMetric, opts.PaletteShards, step, opts.PaletteSamples, rng)
+	}
+	if opts.MiniBatch {
+		return generateMiniBatchPalette(ctx, img, numColors, opts.ColorMetric, step, opts.PaletteSamples, rng)
+	}
+	return generatePaletteWithMetric(ctx, img, numColors, opts.ColorMetric, step, opts.PaletteSamples, rng)
+}
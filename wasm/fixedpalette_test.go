@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// isWebSafe reports whether every channel of c is a multiple of 0x33
+func isWebSafe(c color.RGBA) bool {
+	return c.R%51 == 0 && c.G%51 == 0 && c.B%51 == 0
+}
+
+func TestWebSafePaletteProducesOnlyWebSafeColors(t *testing.T) {
+	args := testSheetArgs
+	args.showColors = true
+	result := mustProcessImage(t, syntheticImage(192), args, map[string]interface{}{"fixedPalette": "websafe"})
+
+	if len(result.Palette) == 0 || len(result.Palette) > args.colors {
+		t.Errorf("palette has %d colors, want 1 to %d", len(result.Palette), args.colors)
+	}
+	for _, c := range result.Palette {
+		if !isWebSafe(color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}) {
+			t.Errorf("palette color %s is not web-safe", c.Hex)
+		}
+	}
+
+	if n := countPixels(decodeBase64PNG(t, result.Image), func(c color.RGBA) bool { return !isWebSafe(c) }); n > 0 {
+		t.Errorf("%d output pixels are not web-safe", n)
+	}
+}
+
+func TestLockedPaletteIsSharedAcrossImages(t *testing.T) {
+	first := mustProcessImage(t, syntheticImage(128), testSheetArgs, nil)
+	locked := make([]interface{}, len(first.Palette))
+	for i, c := range first.Palette {
+		locked[i] = c.Hex
+	}
+
+	// A different picture: a warm diagonal gradient
+	other := image.NewRGBA(image.Rect(0, 0, 160, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 160; x++ {
+			other.Set(x, y, color.RGBA{uint8(255 - x), uint8(y * 2), 40, 255})
+		}
+	}
+
+	var palettes [][]string
+	for _, img := range []image.Image{syntheticImage(128), other} {
+		result := mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"palette": locked})
+		var hexes []string
+		for _, c := range result.Palette {
+			hexes = append(hexes, c.Hex)
+		}
+		palettes = append(palettes, hexes)
+	}
+	if fmt.Sprint(palettes[0]) != fmt.Sprint(locked) || fmt.Sprint(palettes[1]) != fmt.Sprint(locked) {
+		t.Errorf("locked palettes %v and %v, want both %v", palettes[0], palettes[1], locked)
+	}
+
+	// A repeated color, in any case, would give two numbers the same regions
+	duplicate := []interface{}{"#ff0000", "#00ff00", "#FF0000"}
+	if result := callProcessImage(t, other, testSheetArgs, map[string]interface{}{"palette": duplicate}); result.ErrorCode != "invalid_param" || !strings.Contains(result.Error, "both") {
+		t.Errorf("palette %v: errorCode %q (%s), want invalid_param naming the repeat", duplicate, result.ErrorCode, result.Error)
+	}
+
+	for _, bad := range [][]interface{}{{"#ff0000"}, {"#ff0000", "red"}, {"#ff0000", 12}} {
+		if result := callProcessImage(t, other, testSheetArgs, map[string]interface{}{"palette": bad}); result.ErrorCode != "invalid_param" {
+			t.Errorf("palette %v: errorCode %q (%s), want invalid_param", bad, result.ErrorCode, result.Error)
+		}
+	}
+}
+
+func TestConflictingClusteringOptionsAreRejected(t *testing.T) {
+	img := syntheticImage(64)
+	for _, options := range []map[string]interface{}{
+		{"spatialWeight": 1, "colorMetric": "cmyk"},
+		{"spatialWeight": 1, "weightedKMeans": true},
+		{"colorMetric": "cmyk", "paletteShards": 4},
+		{"weightedKMeans": true, "miniBatch": true},
+	} {
+		result := callProcessImage(t, img, testSheetArgs, options)
+		if result.ErrorCode != "invalid_param" || !strings.Contains(result.Error, "cannot be used together") {
+			t.Errorf("%v: errorCode %q (%s), want invalid_param naming the conflict", options, result.ErrorCode, result.Error)
+		}
+	}
+
+	// One variant alone, or with options that combine with every variant, still runs
+	for _, options := range []map[string]interface{}{
+		{"spatialWeight": 1},
+		{"colorMetric": "cmyk", "paletteResolution": 64},
+		{"colorMetric": "redmean", "miniBatch": true},
+	} {
+		if result := callProcessImage(t, img, testSheetArgs, options); result.Error != "" {
+			t.Errorf("%v failed: %s", options, result.Error)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+// histogramBits is how many high bits of each channel pick a histogram bin, so at most
+// 32×32×32 bins whatever the image size
+const histogramBits = 5
+
+// histogramBin is one cell of a color histogram: the mean color of the pixels that fell in
+// it and how many there were
+type histogramBin struct {
+	Color color.RGBA
+	Count int
+}
+
+// colorHistogram counts every pixel of img into bins by the top histogramBits of each
+// channel. Bins are returned in bin order with their mean color, so nearby shades that
+// share a bin still average to their true color.
+func colorHistogram(img image.Image) []histogramBin {
+	const side = 1 << histogramBits
+	var sums [side * side * side][3]int
+	var counts [side * side * side]int
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r8, g8, b8 := int(r>>8), int(g>>8), int(b>>8)
+			shift := 8 - histogramBits
+			bin := (r8>>shift)*side*side + (g8>>shift)*side + (b8 >> shift)
+			sums[bin][0] += r8
+			sums[bin][1] += g8
+			sums[bin][2] += b8
+			counts[bin]++
+		}
+	}
+
+	var histogram []histogramBin
+	for bin, n := range counts {
+		if n == 0 {
+			continue
+		}
+		histogram = append(histogram, histogramBin{
+			Color: color.RGBA{
+				R: uint8((sums[bin][0] + n/2) / n),
+				G: uint8((sums[bin][1] + n/2) / n),
+				B: uint8((sums[bin][2] + n/2) / n),
+				A: 255,
+			},
+			Count: n,
+		})
+	}
+	return histogram
+}
+
+// generateHistogramPalette clusters a histogram of every pixel rather than a sample grid,
+// so each color pulls on the palette exactly as much as the area it covers
+func generateHistogramPalette(ctx context.Context, img image.Image, numColors int, metric ColorMetric, rng *rand.Rand) []color.Color {
+	return weightedKMeans(ctx, colorHistogram(img), numColors, metric, rng)
+}
+
+// weightedKMeans runs k-means++ over histogram bins, treating each bin as Count copies of
+// its color. Seeding and centroid means are both weighted, so a dominant color is far more
+// likely to get a centroid of its own and pulls its cluster's mean toward itself. Iteration
+// ends early once ctx is done.
+func weightedKMeans(ctx context.Context, histogram []histogramBin, k int, metric ColorMetric, rng *rand.Rand) []color.Color {
+	if len(histogram) == 0 {
+		return []color.Color{color.RGBA{128, 128, 128, 255}}
+	}
+	if k >= len(histogram) {
+		palette := make([]color.Color, len(histogram))
+		for i, bin := range histogram {
+			palette[i] = bin.Color
+		}
+		return palette
+	}
+
+	samples := make([][]float64, len(histogram))
+	weights := make([]float64, len(histogram))
+	for i, bin := range histogram {
+		samples[i] = []float64{float64(bin.Color.R), float64(bin.Color.G), float64(bin.Color.B)}
+		weights[i] = float64(bin.Count)
+	}
+	distance := vectorDistanceSquared
+	if metric != MetricEuclidean {
+		distance = func(a, b []float64) float64 { return metric.distance(vectorColor(a), vectorColor(b)) }
+	}
+
+	centroids := vectorKMeans(ctx, samples, weights, k, distance, rng)
+	palette := make([]color.Color, len(centroids))
+	for i, c := range centroids {
+		palette[i] = vectorColor(c)
+	}
+	return palette
+}
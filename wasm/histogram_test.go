@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWeightedKMeansRepresentsDominantColor(t *testing.T) {
+	// 90% a noisy blue; the last 20 rows a ramp through many different colors
+	rng := rand.New(rand.NewSource(1))
+	blue := color.RGBA{40, 70, 200, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			if y >= 180 {
+				img.Set(x, y, color.RGBA{uint8(x), uint8(255 - x), uint8((x * 7) % 256), 255})
+				continue
+			}
+			n := uint8(rng.Intn(13))
+			img.Set(x, y, color.RGBA{blue.R - 6 + n, blue.G - 6 + n, blue.B - 6 + n, 255})
+		}
+	}
+
+	histogram := colorHistogram(img)
+	palette := weightedKMeans(context.Background(), histogram, 6, MetricEuclidean, rand.New(rand.NewSource(1)))
+
+	// Share of the pixels each palette color would take
+	shares := make([]int, len(palette))
+	for _, bin := range histogram {
+		shares[findNearestColor(bin.Color, palette)] += bin.Count
+	}
+	nearest := findNearestColor(blue, palette)
+	if d := colorDistance(blue, palette[nearest]) / 257; d > 6 {
+		t.Errorf("nearest palette color to the dominant blue is %v, %.1f away", palette[nearest], d)
+	}
+	if share := float64(shares[nearest]) / (200 * 200); share < 0.88 || share > 0.92 {
+		t.Errorf("the blue palette color covers %.0f%% of the pixels, want about 90%%", share*100)
+	}
+}
+
+func TestWeightedKMeansHonorsMetricAndCancellation(t *testing.T) {
+	// Random pixels fill most of the histogram's bins
+	rng := rand.New(rand.NewSource(2))
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255
+	}
+	histogram := colorHistogram(img)
+	const k = 24
+
+	// Under a perceptual metric it still returns k colors
+	palette := weightedKMeans(context.Background(), colorHistogram(syntheticImage(128)), 8, MetricCIEDE2000, rand.New(rand.NewSource(1)))
+	if len(palette) != 8 {
+		t.Errorf("CIEDE2000 weighted k-means returned %d colors, want 8", len(palette))
+	}
+
+	started := time.Now()
+	weightedKMeans(context.Background(), histogram, k, MetricEuclidean, rand.New(rand.NewSource(1)))
+	full := time.Since(started)
+
+	// Cancelled up front, seeding makes no weighted distance passes over the bins
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started = time.Now()
+	palette = weightedKMeans(ctx, histogram, k, MetricEuclidean, rand.New(rand.NewSource(1)))
+	if elapsed := time.Since(started); elapsed > full/4 {
+		t.Errorf("cancelled weighted k-means took %v, full run %v", elapsed, full)
+	}
+	if len(palette) != k {
+		t.Errorf("cancelled weighted k-means returned %d colors, want %d", len(palette), k)
+	}
+}
@@ -1,37 +1,280 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"math"
+	"strings"
 )
 
-// downsampleImage resizes an image to fit within maxDimension while preserving aspect ratio
-func downsampleImage(img image.Image, maxDimension int) image.Image {
+// srgbToLinearTable maps 8-bit sRGB channel values to linear light
+var srgbToLinearTable = func() [256]float64 {
+	var table [256]float64
+	for i := range table {
+		v := float64(i) / 255.0
+		if v <= 0.04045 {
+			table[i] = v / 12.92
+		} else {
+			table[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+// maxInputPixels caps the width × height an uploaded image may declare. Decoding needs
+// four bytes per pixel at once, so a small file claiming a huge canvas could otherwise
+// exhaust the WebAssembly heap before downsampling ever runs.
+const maxInputPixels = 50 * 1000 * 1000
+
+// decodeImage decodes an uploaded PNG, JPEG or GIF, first reading just its header to
+// reject images over maxInputPixels without buffering their pixels. Whatever the file's
+// color model (YCbCr JPEG, paletted GIF, 16-bit or gray PNG), the image comes back as
+// *image.RGBA so the pipeline reads one fast, uniform model; CMYK JPEGs are converted to
+// RGB on the way.
+func decodeImage(data []byte) (image.Image, string, error) {
+	return decodeImageWithAlpha(data, 0)
+}
+
+// decodeImageWithAlpha is decodeImage that, with a nonzero alphaThreshold, also flattens
+// transparency onto white (see flattenAlpha). That happens before the RGBA conversion,
+// whose premultiplied channels would round the colors of faint edge pixels.
+func decodeImageWithAlpha(data []byte, alphaThreshold uint8) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", decodeError(err)
+	}
+	if pixels := int64(config.Width) * int64(config.Height); pixels > maxInputPixels {
+		return nil, "", conversionError(ErrTooLarge, "Image is %dx%d (%.1f megapixels), more than the %d megapixel limit; resize it first",
+			config.Width, config.Height, float64(pixels)/1e6, maxInputPixels/1000000)
+	}
+
+	// Print-workflow CMYK JPEGs need their ink values converted (and sometimes un-inverted)
+	if format == "jpeg" && config.ColorModel == color.CMYKModel {
+		img, err := convertCMYKJPEG(data)
+		if err != nil {
+			return nil, "", decodeError(err)
+		}
+		return img, format, nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", decodeError(err)
+	}
+	if alphaThreshold > 0 {
+		return flattenAlpha(img, alphaThreshold, color.White), format, nil
+	}
+	return toRGBA(img), format, nil
+}
+
+// toRGBA returns img as *image.RGBA with the same bounds, copying it only when it uses
+// another color model
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
 	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// downsampleImage resizes an image to at most maxDimension² pixels while preserving aspect ratio.
+// The long side of an image wider than square exceeds maxDimension by √(aspect ratio).
+func downsampleImage(img image.Image, maxDimension int) image.Image {
+	return downsampleImageWithGamma(img, maxDimension, false)
+}
+
+// downsampleImageWithGamma resizes like downsampleImage, optionally blending in linear light
+func downsampleImageWithGamma(img image.Image, maxDimension int, gammaCorrect bool) image.Image {
+	size := downsampledSize(img.Bounds(), maxDimension)
+	if size == img.Bounds().Size() {
+		return img
+	}
+
+	// Use bilinear interpolation for downsampling
+	return resizeBilinearWithGamma(img, size.X, size.Y, gammaCorrect)
+}
+
+// downsampledSize returns the size downsampleImage gives an image of bounds: unchanged when
+// it already fits maxDimension² pixels, else scaled to fit them with its aspect ratio kept
+func downsampledSize(bounds image.Rectangle, maxDimension int) image.Point {
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	// Check if downsampling is needed
-	if width <= maxDimension && height <= maxDimension {
-		return img
+	// Check if downsampling is needed. The budget is the pixel count of a
+	// maxDimension square, so panoramas keep usable short-side resolution
+	// instead of being squeezed until their longest side fits.
+	budget := maxDimension * maxDimension
+	if width*height <= budget {
+		return bounds.Size()
 	}
 
 	// Calculate new dimensions
-	var newWidth, newHeight int
-	if width > height {
-		newWidth = maxDimension
-		newHeight = (height * maxDimension) / width
-	} else {
-		newHeight = maxDimension
-		newWidth = (width * maxDimension) / height
+	scale := math.Sqrt(float64(budget) / float64(width*height))
+	newWidth := int(float64(width) * scale)
+	newHeight := int(float64(height) * scale)
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
 	}
+	return image.Pt(newWidth, newHeight)
+}
+
+// lowResolutionFactor is how many times smaller (per side) than the maxDimension budget an
+// image may be before the result warns that the sheet will come out small
+const lowResolutionFactor = 2
+
+// lowResolutionWarning returns a warning when an image is much smaller than the requested
+// maxDimension, since images are never upscaled, or "" if it is big enough
+func lowResolutionWarning(bounds image.Rectangle, maxDimension int) string {
+	budget := maxDimension * maxDimension
+	if bounds.Dx()*bounds.Dy()*lowResolutionFactor*lowResolutionFactor >= budget {
+		return ""
+	}
+	return fmt.Sprintf("Source image is only %dx%d, much smaller than the requested size of %d; the sheet is not upscaled, so regions and numbers will be small. Use a larger image for a better result.",
+		bounds.Dx(), bounds.Dy(), maxDimension)
+}
+
+// lineColorWarning returns a warning when the border and number colors are too close in
+// luminance to tell apart where numbers touch borders, or "" if they contrast enough.
+// nil stands for the default black.
+func lineColorWarning(border, number color.Color) string {
+	if border == nil {
+		border = color.Black
+	}
+	if number == nil {
+		number = color.Black
+	}
+	ratio := contrastRatio(border, number)
+	if ratio >= minLineContrast {
+		return ""
+	}
+	return fmt.Sprintf("Border color %s and number color %s have a contrast of only %.1f:1; numbers may be hard to read where they touch borders. Pick colors with a contrast of at least %.0f:1.",
+		colorToHex(border), colorToHex(number), ratio, minLineContrast)
+}
+
+// joinWarnings combines the non-empty warnings into one message
+func joinWarnings(warnings ...string) string {
+	var parts []string
+	for _, w := range warnings {
+		if w != "" {
+			parts = append(parts, w)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// thumbnailSize is the longest side of the preview returned alongside the full result
+const thumbnailSize = 256
+
+// thumbnailImage scales img down so its longest side is maxSide, keeping the aspect ratio
+func thumbnailImage(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+
+	scale := float64(maxSide) / float64(width)
+	if height > width {
+		scale = float64(maxSide) / float64(height)
+	}
+	newWidth := int(math.Max(1, math.Round(float64(width)*scale)))
+	newHeight := int(math.Max(1, math.Round(float64(height)*scale)))
 
-	// Use bilinear interpolation for downsampling
 	return resizeBilinear(img, newWidth, newHeight)
 }
 
+// Margin is the padding around the finished sheet, either in pixels or as a percentage
+// of the sheet's shorter side
+type Margin struct {
+	Pixels  int
+	Percent float64
+}
+
+// resolve converts the margin to pixels for a sheet of the given bounds
+func (m Margin) resolve(bounds image.Rectangle) int {
+	if m.Percent > 0 {
+		short := bounds.Dx()
+		if bounds.Dy() < short {
+			short = bounds.Dy()
+		}
+		return int(math.Round(float64(short) * m.Percent / 100))
+	}
+	return m.Pixels
+}
+
+// padImage surrounds img with a margin of bg color, returning a canvas anchored at (0, 0)
+// that is 2*margin wider and taller
+func padImage(img image.Image, margin int, bg color.Color) image.Image {
+	if margin <= 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx()+margin*2, bounds.Dy()+margin*2))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+	draw.Draw(canvas, image.Rect(margin, margin, margin+bounds.Dx(), margin+bounds.Dy()), img, bounds.Min, draw.Src)
+
+	return canvas
+}
+
+// cropImage copies the part of img inside rect into a new image whose bounds start at (0, 0)
+func cropImage(img image.Image, rect image.Rectangle) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(result, result.Bounds(), img, rect.Min, draw.Src)
+	return result
+}
+
+// flattenAlpha makes img fully opaque for logo-style inputs: pixels with alpha below
+// threshold become bg, the rest keep their unpremultiplied color at full opacity. Feathered
+// edges then snap to either the shape or the background instead of clustering as faint colors.
+func flattenAlpha(img image.Image, threshold uint8, bg color.Color) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	background := color.RGBAModel.Convert(bg).(color.RGBA)
+	background.A = 255
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < threshold {
+				result.SetRGBA(x, y, background)
+			} else {
+				result.SetRGBA(x, y, color.RGBA{R: c.R, G: c.G, B: c.B, A: 255})
+			}
+		}
+	}
+
+	return result
+}
+
+// aspectRatio returns the long side divided by the short side
+func aspectRatio(bounds image.Rectangle) float64 {
+	long, short := bounds.Dx(), bounds.Dy()
+	if short > long {
+		long, short = short, long
+	}
+	if short == 0 {
+		return 0
+	}
+	return float64(long) / float64(short)
+}
+
 // resizeBilinear performs bilinear interpolation resizing
 func resizeBilinear(img image.Image, newWidth, newHeight int) image.Image {
+	return resizeBilinearWithGamma(img, newWidth, newHeight, false)
+}
+
+// resizeBilinearWithGamma performs bilinear resizing, blending in linear light when gammaCorrect is set.
+// This avoids the dark halos of naive sRGB blending on high-contrast edges but is slower.
+func resizeBilinearWithGamma(img image.Image, newWidth, newHeight int, gammaCorrect bool) image.Image {
 	bounds := img.Bounds()
 	oldWidth := bounds.Dx()
 	oldHeight := bounds.Dy()
@@ -72,7 +315,12 @@ func resizeBilinear(img image.Image, newWidth, newHeight int) image.Image {
 			yWeight := srcY - float64(y1)
 
 			// Interpolate
-			interpolated := bilinearInterpolate(c11, c12, c21, c22, xWeight, yWeight)
+			var interpolated color.Color
+			if gammaCorrect {
+				interpolated = bilinearInterpolateLinear(c11, c12, c21, c22, xWeight, yWeight)
+			} else {
+				interpolated = bilinearInterpolate(c11, c12, c21, c22, xWeight, yWeight)
+			}
 			result.Set(x, y, interpolated)
 		}
 	}
@@ -116,3 +364,304 @@ func bilinearInterpolate(c11, c12, c21, c22 color.Color, xWeight, yWeight float6
 func interpolate(v1, v2, weight float64) float64 {
 	return v1*(1-weight) + v2*weight
 }
+
+// bilinearInterpolateLinear interpolates between four colors in linear light and re-applies sRGB gamma.
+// RGBA() values are premultiplied, so each corner is un-premultiplied before linearizing and
+// weighted by its alpha in the blend; the result is re-premultiplied by the blended alpha.
+// Semi-transparent edges then keep their color instead of darkening toward black.
+func bilinearInterpolateLinear(c11, c12, c21, c22 color.Color, xWeight, yWeight float64) color.Color {
+	corners := [4]color.Color{c11, c21, c12, c22}
+	weights := [4]float64{
+		(1 - xWeight) * (1 - yWeight),
+		xWeight * (1 - yWeight),
+		(1 - xWeight) * yWeight,
+		xWeight * yWeight,
+	}
+
+	var r, g, b, a float64
+	for i, c := range corners {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		w := weights[i] * float64(n.A) / 255
+		r += w * srgbToLinearTable[n.R]
+		g += w * srgbToLinearTable[n.G]
+		b += w * srgbToLinearTable[n.B]
+		a += w // Alpha is not gamma encoded
+	}
+	if a == 0 {
+		return color.RGBA{}
+	}
+
+	return color.NRGBA{
+		R: linearToSRGB(r / a),
+		G: linearToSRGB(g / a),
+		B: linearToSRGB(b / a),
+		A: uint8(math.Round(a * 255)),
+	}
+}
+
+// linearToSRGB converts a linear light value in [0, 1] to an 8-bit sRGB channel
+func linearToSRGB(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+
+	var s float64
+	if v <= 0.0031308 {
+		s = v * 12.92
+	} else {
+		s = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(s * 255))
+}
+
+// autoWhiteBalance removes a global color cast using the gray-world assumption:
+// each channel is scaled so the channel averages become equal
+func autoWhiteBalance(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+
+	// Measure channel averages
+	var sumR, sumG, sumB float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			sumR += float64(c.R)
+			sumG += float64(c.G)
+			sumB += float64(c.B)
+		}
+	}
+
+	n := float64(bounds.Dx() * bounds.Dy())
+	if n == 0 || sumR == 0 || sumG == 0 || sumB == 0 {
+		// Nothing to balance against; return an unmodified copy
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				result.Set(x, y, img.At(x, y))
+			}
+		}
+		return result
+	}
+
+	avgR, avgG, avgB := sumR/n, sumG/n, sumB/n
+	gray := (avgR + avgG + avgB) / 3
+	scaleR, scaleG, scaleB := gray/avgR, gray/avgG, gray/avgB
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			result.Set(x, y, color.NRGBA{
+				R: clampChannel(float64(c.R) * scaleR),
+				G: clampChannel(float64(c.G) * scaleG),
+				B: clampChannel(float64(c.B) * scaleB),
+				A: c.A,
+			})
+		}
+	}
+
+	return result
+}
+
+// clampChannel rounds a channel value into the 0-255 range
+func clampChannel(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(math.Round(v))
+}
+
+// sharpenRadius is the blur radius used by the sharpen option, in pixels
+const sharpenRadius = 1.0
+
+// unsharpMask sharpens img by adding back amount times the difference between each pixel
+// and a Gaussian blur of the given radius (standard deviation in pixels)
+func unsharpMask(img image.Image, amount, radius float64) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	result := image.NewRGBA(bounds)
+
+	// Unpack to float channels once; blurring works on these planes
+	size := width * height
+	planes := [3][]float64{make([]float64, size), make([]float64, size), make([]float64, size)}
+	alpha := make([]uint8, size)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(x+bounds.Min.X, y+bounds.Min.Y)).(color.NRGBA)
+			i := y*width + x
+			planes[0][i] = float64(c.R)
+			planes[1][i] = float64(c.G)
+			planes[2][i] = float64(c.B)
+			alpha[i] = c.A
+		}
+	}
+
+	kernel := gaussianKernel(radius)
+	var blurred [3][]float64
+	for ch := range planes {
+		blurred[ch] = gaussianBlurPlane(planes[ch], width, height, kernel)
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var out [3]uint8
+			for ch := range planes {
+				v := planes[ch][i]
+				out[ch] = clampChannel(v + amount*(v-blurred[ch][i]))
+			}
+			result.Set(x+bounds.Min.X, y+bounds.Min.Y, color.NRGBA{R: out[0], G: out[1], B: out[2], A: alpha[i]})
+		}
+	}
+
+	return result
+}
+
+// gaussianKernel returns normalized 1D Gaussian weights covering three standard deviations
+func gaussianKernel(sigma float64) []float64 {
+	half := int(math.Ceil(sigma * 3))
+	if half < 1 {
+		half = 1
+	}
+	kernel := make([]float64, 2*half+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - half)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// gaussianBlurPlane blurs one channel with a separable kernel, clamping at the edges
+func gaussianBlurPlane(plane []float64, width, height int, kernel []float64) []float64 {
+	half := len(kernel) / 2
+	clamp := func(v, limit int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= limit {
+			return limit - 1
+		}
+		return v
+	}
+
+	horizontal := make([]float64, len(plane))
+	for y := 0; y < height; y++ {
+		row := y * width
+		for x := 0; x < width; x++ {
+			sum := 0.0
+			for k, w := range kernel {
+				sum += w * plane[row+clamp(x+k-half, width)]
+			}
+			horizontal[row+x] = sum
+		}
+	}
+
+	blurred := make([]float64, len(plane))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sum := 0.0
+			for k, w := range kernel {
+				sum += w * horizontal[clamp(y+k-half, height)*width+x]
+			}
+			blurred[y*width+x] = sum
+		}
+	}
+
+	return blurred
+}
+
+// maxBilateralRadius bounds the bilateral kernel; each output pixel costs (2r+1)² samples
+const maxBilateralRadius = 5
+
+// defaultDenoiseRange is the bilateral range sigma used when only the spatial sigma is given
+const defaultDenoiseRange = 30.0
+
+// bilateralFilter smooths noise while keeping edges: each neighbor is weighted by its
+// distance (spatialSigma, in pixels) and by how different its color is (rangeSigma, in
+// 0-255 channel units), so pixels across a strong edge barely contribute
+func bilateralFilter(img image.Image, spatialSigma, rangeSigma float64) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	result := image.NewRGBA(bounds)
+
+	radius := int(math.Ceil(2 * spatialSigma))
+	if radius < 1 {
+		radius = 1
+	}
+	if radius > maxBilateralRadius {
+		radius = maxBilateralRadius
+	}
+
+	// Spatial weights for the whole window, range weights per channel difference.
+	// The range term factors across channels, so three lookups replace an exp per sample.
+	side := 2*radius + 1
+	spatial := make([]float64, side*side)
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			spatial[(dy+radius)*side+dx+radius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * spatialSigma * spatialSigma))
+		}
+	}
+	var rangeWeight [256]float64
+	for d := range rangeWeight {
+		rangeWeight[d] = math.Exp(-float64(d*d) / (2 * rangeSigma * rangeSigma))
+	}
+
+	pixels := make([]color.NRGBA, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] = color.NRGBAModel.Convert(img.At(x+bounds.Min.X, y+bounds.Min.Y)).(color.NRGBA)
+		}
+	}
+
+	absDiff := func(a, b uint8) int {
+		if a > b {
+			return int(a - b)
+		}
+		return int(b - a)
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			center := pixels[y*width+x]
+			var sumR, sumG, sumB, sumW float64
+			for dy := -radius; dy <= radius; dy++ {
+				ny := y + dy
+				if ny < 0 || ny >= height {
+					continue
+				}
+				for dx := -radius; dx <= radius; dx++ {
+					nx := x + dx
+					if nx < 0 || nx >= width {
+						continue
+					}
+					n := pixels[ny*width+nx]
+					w := spatial[(dy+radius)*side+dx+radius] *
+						rangeWeight[absDiff(n.R, center.R)] *
+						rangeWeight[absDiff(n.G, center.G)] *
+						rangeWeight[absDiff(n.B, center.B)]
+					sumR += w * float64(n.R)
+					sumG += w * float64(n.G)
+					sumB += w * float64(n.B)
+					sumW += w
+				}
+			}
+			result.Set(x+bounds.Min.X, y+bounds.Min.Y, color.NRGBA{
+				R: clampChannel(sumR / sumW),
+				G: clampChannel(sumG / sumW),
+				B: clampChannel(sumB / sumW),
+				A: center.A,
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"runtime"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+// checkerboard returns a width×height image of alternating black and white pixels
+func checkerboard(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func TestGammaCorrectResizeBlendsCheckerboard(t *testing.T) {
+	// 3 → 2 pixels per side puts output pixel 1 halfway between two source pixels
+	src := checkerboard(3, 3)
+
+	for _, tc := range []struct {
+		gammaCorrect bool
+		want         uint8
+	}{
+		{false, 127}, // Naive sRGB average
+		{true, 188},  // sRGB of 0.5 linear
+	} {
+		resized := resizeBilinearWithGamma(src, 2, 2, tc.gammaCorrect)
+		for _, p := range []image.Point{{1, 0}, {0, 1}, {1, 1}} {
+			c := color.RGBAModel.Convert(resized.At(p.X, p.Y)).(color.RGBA)
+			if diff := int(c.R) - int(tc.want); diff < -2 || diff > 2 {
+				t.Errorf("gammaCorrect=%v: pixel %v = %d, want about %d", tc.gammaCorrect, p, c.R, tc.want)
+			}
+			if c.R != c.G || c.G != c.B || c.A != 255 {
+				t.Errorf("gammaCorrect=%v: pixel %v = %v, want opaque gray", tc.gammaCorrect, p, c)
+			}
+		}
+	}
+}
+
+func TestGammaCorrectBlendKeepsSemiTransparentColor(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	halfWhite := color.RGBA{128, 128, 128, 128} // Premultiplied white at half opacity
+	clear := color.RGBA{}
+
+	c := color.NRGBAModel.Convert(bilinearInterpolateLinear(white, white, halfWhite, halfWhite, 0.5, 0)).(color.NRGBA)
+	if c.R < 254 || c.G < 254 || c.B < 254 {
+		t.Errorf("blend of white and half-transparent white = %v, want white", c)
+	}
+	if c.A < 190 || c.A > 193 {
+		t.Errorf("blend alpha = %d, want about 191", c.A)
+	}
+
+	// A fully transparent corner contributes no color, only transparency
+	c = color.NRGBAModel.Convert(bilinearInterpolateLinear(white, white, clear, clear, 0.5, 0)).(color.NRGBA)
+	if c.R < 254 || c.A < 126 || c.A > 129 {
+		t.Errorf("blend of white and transparent = %v, want half-transparent white", c)
+	}
+}
+
+// channelAverages returns the mean 8-bit red, green and blue of img
+func channelAverages(img image.Image) (r, g, b float64) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			r += float64(c.R)
+			g += float64(c.G)
+			b += float64(c.B)
+		}
+	}
+	n := float64(bounds.Dx() * bounds.Dy())
+	return r / n, g / n, b / n
+}
+
+func TestAutoWhiteBalanceNeutralizesOrangeCast(t *testing.T) {
+	// A gray ramp seen under warm light: red kept, green and blue dimmed
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := float64(40 + 3*x)
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v * 0.8), uint8(v * 0.55), 255})
+		}
+	}
+
+	spread := func(r, g, b float64) float64 {
+		return math.Max(r, math.Max(g, b)) - math.Min(r, math.Min(g, b))
+	}
+	before := spread(channelAverages(img))
+	after := spread(channelAverages(autoWhiteBalance(img)))
+	if after >= before/4 {
+		t.Errorf("channel average spread went from %.1f to %.1f, want it mostly removed", before, after)
+	}
+}
+
+func TestDownsampleKeepsPanoramaShortSide(t *testing.T) {
+	// 10:1 panorama; fitting the long side to 512 would leave 51 rows
+	img := image.NewRGBA(image.Rect(0, 0, 4000, 400))
+	resized := downsampleImage(img, 512)
+
+	b := resized.Bounds()
+	if b.Dy() < 100 {
+		t.Errorf("short side downsampled to %d pixels, want at least 100", b.Dy())
+	}
+	if b.Dx()*b.Dy() > 512*512 {
+		t.Errorf("downsampled to %dx%d, over the %d pixel budget", b.Dx(), b.Dy(), 512*512)
+	}
+	if ratio := aspectRatio(b); math.Abs(ratio-10) > 0.2 {
+		t.Errorf("aspect ratio became %.2f, want 10", ratio)
+	}
+}
+
+func TestMaxAspectRatioRejectsPanorama(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 100))
+	result := callProcessImage(t, img, testSheetArgs, map[string]interface{}{"maxAspectRatio": 4})
+	if result.ErrorCode != "too_large" || !strings.Contains(result.Error, "aspect ratio") {
+		t.Errorf("processImage = %q (%s), want an aspect ratio error", result.Error, result.ErrorCode)
+	}
+
+	result = callProcessImage(t, img, testSheetArgs, map[string]interface{}{"maxAspectRatio": 12})
+	if result.Error != "" {
+		t.Errorf("processImage under the ratio limit failed: %s", result.Error)
+	}
+}
+
+func TestPanoramaLongSideOvershootIsBoundedByAspectRatio(t *testing.T) {
+	// maxDimension is a pixel budget, so a 10:1 panorama comes out wider than maxDimension,
+	// by √10, and within the maxDimension×√ratio bound MaxAspectRatio documents
+	img := image.NewRGBA(image.Rect(0, 0, 2000, 200))
+	result := mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"maxAspectRatio": 12})
+	if result.Width <= testSheetArgs.maxDimension {
+		t.Errorf("panorama long side %d, want past maxDimension %d", result.Width, testSheetArgs.maxDimension)
+	}
+	if limit := float64(testSheetArgs.maxDimension) * math.Sqrt(12); float64(result.Width) > limit {
+		t.Errorf("panorama long side %d, over the %.0f maxAspectRatio bound", result.Width, limit)
+	}
+	if result.Width*result.Height > testSheetArgs.maxDimension*testSheetArgs.maxDimension {
+		t.Errorf("panorama processed at %dx%d, over the %d pixel budget", result.Width, result.Height, testSheetArgs.maxDimension*testSheetArgs.maxDimension)
+	}
+}
+
+// edgeEnergy sums the edge map of img
+func edgeEnergy(img image.Image) float64 {
+	sum := 0.0
+	for _, v := range computeEdgeMap(img) {
+		sum += v
+	}
+	return sum
+}
+
+func TestSharpenIncreasesEdgeEnergy(t *testing.T) {
+	photo := downsampleImage(syntheticImage(512), 128)
+
+	before := edgeEnergy(photo)
+	after := edgeEnergy(unsharpMask(photo, 1.5, sharpenRadius))
+	if after <= before*1.1 {
+		t.Errorf("edge energy went from %.0f to %.0f after sharpening, want a clear increase", before, after)
+	}
+
+	opts, err := parseProcessOptions(js.ValueOf(map[string]interface{}{}))
+	if err != nil || opts.Sharpen != 0 {
+		t.Errorf("default sharpen = %v (%v), want off", opts.Sharpen, err)
+	}
+}
+
+func TestPadImageAddsBackgroundBorder(t *testing.T) {
+	src := checkerboard(30, 20)
+	bg := color.RGBA{240, 230, 200, 255}
+	const margin = 7
+
+	padded := padImage(src, margin, bg)
+	if b := padded.Bounds(); b.Dx() != 30+2*margin || b.Dy() != 20+2*margin {
+		t.Fatalf("padded to %dx%d, want %dx%d", b.Dx(), b.Dy(), 30+2*margin, 20+2*margin)
+	}
+
+	b := padded.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			inner := image.Pt(x-margin, y-margin)
+			got := color.RGBAModel.Convert(padded.At(x, y)).(color.RGBA)
+			if !inner.In(src.Bounds()) {
+				if got != bg {
+					t.Fatalf("border pixel %d,%d = %v, want %v", x, y, got, bg)
+				}
+			} else if want := src.RGBAAt(inner.X, inner.Y); got != want {
+				t.Fatalf("pixel %d,%d = %v, want the source's %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestSmallSourceWarnsAndReportsSize(t *testing.T) {
+	args := testSheetArgs
+	args.maxDimension = 2048
+	result := mustProcessImage(t, syntheticImage(100), args, nil)
+	if !strings.Contains(result.Warning, "much smaller than the requested size") {
+		t.Errorf("warning = %q, want a low resolution warning", result.Warning)
+	}
+	if result.Width != 100 || result.Height != 100 {
+		t.Errorf("processed size %dx%d, want 100x100", result.Width, result.Height)
+	}
+
+	args.maxDimension = 256
+	if result := mustProcessImage(t, syntheticImage(256), args, nil); strings.Contains(result.Warning, "much smaller") {
+		t.Errorf("full-size source warned: %q", result.Warning)
+	}
+}
+
+func TestBilateralFilterDenoisesFlatAreasAndKeepsEdges(t *testing.T) {
+	// Noisy dark and light halves meeting at x = 32
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			v := 60
+			if x >= 32 {
+				v = 200
+			}
+			v += rng.Intn(31) - 15
+			img.SetRGBA(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+		}
+	}
+
+	// variance returns the red-channel variance over rect
+	variance := func(src image.Image, rect image.Rectangle) float64 {
+		var sum, sumSq float64
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				v := float64(color.RGBAModel.Convert(src.At(x, y)).(color.RGBA).R)
+				sum += v
+				sumSq += v * v
+			}
+		}
+		n := float64(rect.Dx() * rect.Dy())
+		return sumSq/n - (sum/n)*(sum/n)
+	}
+	// step returns the mean jump in red across the edge
+	step := func(src image.Image) float64 {
+		total := 0.0
+		for y := 0; y < 32; y++ {
+			a := color.RGBAModel.Convert(src.At(31, y)).(color.RGBA).R
+			b := color.RGBAModel.Convert(src.At(32, y)).(color.RGBA).R
+			total += float64(b) - float64(a)
+		}
+		return total / 32
+	}
+
+	filtered := bilateralFilter(img, 3, 30)
+	for _, flat := range []image.Rectangle{image.Rect(4, 4, 28, 28), image.Rect(36, 4, 60, 28)} {
+		if before, after := variance(img, flat), variance(filtered, flat); after > before/3 {
+			t.Errorf("noise variance in %v went from %.0f to %.0f, want it mostly removed", flat, before, after)
+		}
+	}
+	if jump := step(filtered); jump < 120 {
+		t.Errorf("edge step after filtering = %.0f, want close to the original 140", jump)
+	}
+}
+
+func TestAlphaThresholdKeepsFeatherOutOfPalette(t *testing.T) {
+	// A red disc whose alpha fades out over 12 pixels, on transparency
+	red := color.NRGBA{220, 40, 40, 255}
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			d := math.Hypot(float64(x-100), float64(y-100))
+			alpha := math.Max(0, math.Min(1, (70-d)/12))
+			img.SetNRGBA(x, y, color.NRGBA{red.R, red.G, red.B, uint8(alpha * 255)})
+		}
+	}
+
+	result := mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"alphaThreshold": 128})
+	for _, c := range result.Palette {
+		if c.Hex != "#dc2828" && c.Hex != "#ffffff" {
+			t.Errorf("palette has %s from the feathered edge; want only the red and white background", c.Hex)
+		}
+	}
+	if len(result.Palette) != 2 {
+		t.Errorf("palette has %d colors, want red and white", len(result.Palette))
+	}
+}
+
+func TestHugeDeclaredSizeIsRejectedFromHeader(t *testing.T) {
+	// A tiny PNG whose IHDR claims 50000×50000 pixels, with its CRC fixed up
+	data := encodeTestPNG(t, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	ihdr := data[len(pngSignature)+4:]
+	binary.BigEndian.PutUint32(ihdr[4:], 50000)
+	binary.BigEndian.PutUint32(ihdr[8:], 50000)
+	binary.BigEndian.PutUint32(ihdr[17:], crc32.ChecksumIEEE(ihdr[:17]))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := decodeImage(data)
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("decodeImage = %v, want a too large error", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("rejecting the image allocated %d bytes; the pixels were decoded", allocated)
+	}
+
+	if result := callProcessImageBytes(t, data, testSheetArgs, nil); result.ErrorCode != "too_large" {
+		t.Errorf("processImage: errorCode %q (%s), want too_large", result.ErrorCode, result.Error)
+	}
+}
+
+func TestDecodedImagesAreAlwaysRGBA(t *testing.T) {
+	src := syntheticImage(48)
+	encode := map[string]func(*bytes.Buffer) error{
+		"jpeg": func(buf *bytes.Buffer) error { return jpeg.Encode(buf, src, nil) },
+		"gif":  func(buf *bytes.Buffer) error { return gif.Encode(buf, src, nil) },
+		"png":  func(buf *bytes.Buffer) error { buf.Write(encodeTestPNG(t, image.NewGray(src.Bounds()))); return nil },
+	}
+	for format, enc := range encode {
+		var buf bytes.Buffer
+		if err := enc(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, threshold := range []uint8{0, 128} {
+			img, got, err := decodeImageWithAlpha(buf.Bytes(), threshold)
+			if err != nil || got != format {
+				t.Fatalf("%s: decoded as %q, %v", format, got, err)
+			}
+			if _, ok := img.(*image.RGBA); !ok {
+				t.Errorf("%s, alpha threshold %d: decoded to %T, want *image.RGBA", format, threshold, img)
+			}
+		}
+	}
+}
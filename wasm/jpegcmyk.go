@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// jpegLayout is what scanJPEG learns from the markers before the first scan
+type jpegLayout struct {
+	components int  // Components in the frame header, 4 for CMYK or YCCK
+	adobe      bool // Whether an Adobe APP14 segment is present
+	transform  byte // The APP14 color transform: 0 CMYK, 2 YCCK
+}
+
+// scanJPEG walks a JPEG's marker segments up to the first scan
+func scanJPEG(data []byte) (jpegLayout, error) {
+	var layout jpegLayout
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return layout, errors.New("missing SOI marker")
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return layout, errors.New("malformed marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return layout, errors.New("truncated marker segment")
+		}
+		segment := data[i+4 : i+2+length]
+
+		switch {
+		case marker == 0xEE && len(segment) >= 12 && string(segment[:5]) == "Adobe":
+			layout.adobe = true
+			layout.transform = segment[11]
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			// Start of frame: precision, height, width, then the component count
+			if len(segment) >= 6 {
+				layout.components = int(segment[5])
+			}
+		}
+		i += 2 + length
+	}
+	return layout, nil
+}
+
+// convertCMYKJPEG decodes a four-component (CMYK or YCCK) JPEG to RGB. Photoshop and other
+// print tools write such files with inverted ink values and an Adobe APP14 marker saying
+// so, which image/jpeg assumes; files without the marker are stored uninverted and
+// image/jpeg rejects them outright. Those get a marker spliced in so they decode, and are
+// inverted back, rather than coming out as a color negative.
+func convertCMYKJPEG(data []byte) (image.Image, error) {
+	layout, err := scanJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+	if layout.components != 4 {
+		return nil, errors.New("not a four-component JPEG")
+	}
+
+	source := data
+	if !layout.adobe {
+		// APP14 "Adobe", version 100, no flags, transform 0 (CMYK)
+		app14 := []byte{0xFF, 0xEE, 0x00, 0x0E, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0, 0, 0, 0, 0}
+		source = make([]byte, 0, len(data)+len(app14))
+		source = append(source, data[:2]...)
+		source = append(source, app14...)
+		source = append(source, data[2:]...)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.Bounds()
+	rgba := image.NewRGBA(bounds)
+	cmyk, isCMYK := decoded.(*image.CMYK)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isCMYK {
+				rgba.Set(x, y, decoded.At(x, y))
+				continue
+			}
+			c := cmyk.CMYKAt(x, y)
+			if !layout.adobe {
+				c = color.CMYK{C: 255 - c.C, M: 255 - c.M, Y: 255 - c.Y, K: 255 - c.K}
+			}
+			r, g, b := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+			rgba.SetRGBA(x, y, color.RGBA{R: r, G: g, B: b, A: 255})
+		}
+	}
+	return rgba, nil
+}
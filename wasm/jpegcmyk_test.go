@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"math/bits"
+	"testing"
+)
+
+// cmykJPEG encodes a baseline JPEG 8 pixels tall with one flat 8×8 block per entry of
+// blocks, stored as the four given component values. Every block holds only its DC
+// coefficient and the quantization table is all ones, so decoding is exact. With adobe set
+// an APP14 marker declaring plain CMYK is written, as Photoshop does; its files store
+// inverted ink values, so the caller passes 255 - ink.
+func cmykJPEG(blocks [][4]uint8, adobe bool) []byte {
+	var out bytes.Buffer
+	segment := func(marker byte, data ...byte) {
+		out.Write([]byte{0xFF, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+		out.Write(data)
+	}
+
+	out.Write([]byte{0xFF, 0xD8})
+	if adobe {
+		segment(0xEE, 'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0)
+	}
+	segment(0xDB, append([]byte{0}, bytes.Repeat([]byte{1}, 64)...)...)
+	width := 8 * len(blocks)
+	segment(0xC0, 8, 0, 8, byte(width>>8), byte(width), 4, 1, 0x11, 0, 2, 0x11, 0, 3, 0x11, 0, 4, 0x11, 0)
+
+	// DC table: categories 0-11 as 4-bit codes equal to the category. AC table: only EOB, as "0".
+	dc := make([]byte, 17, 29)
+	dc[4] = 12
+	for category := byte(0); category < 12; category++ {
+		dc = append(dc, category)
+	}
+	segment(0xC4, dc...)
+	ac := make([]byte, 17, 18)
+	ac[0], ac[1] = 0x10, 1
+	segment(0xC4, append(ac, 0)...)
+	segment(0xDA, 4, 1, 0, 2, 0, 3, 0, 4, 0, 0, 63, 0)
+
+	// Entropy-coded data, one block per component per MCU, with 0xFF bytes stuffed
+	var acc uint32
+	var n int
+	put := func(value uint32, length int) {
+		for i := length - 1; i >= 0; i-- {
+			acc = acc<<1 | (value>>i)&1
+			n++
+			if n == 8 {
+				out.WriteByte(byte(acc))
+				if byte(acc) == 0xFF {
+					out.WriteByte(0)
+				}
+				acc, n = 0, 0
+			}
+		}
+	}
+	var previous [4]int
+	for _, block := range blocks {
+		for c, v := range block {
+			dcValue := (int(v) - 128) * 8
+			diff := dcValue - previous[c]
+			previous[c] = dcValue
+
+			magnitude := diff
+			if magnitude < 0 {
+				magnitude = -magnitude
+			}
+			category := bits.Len(uint(magnitude))
+			put(uint32(category), 4)
+			if diff < 0 {
+				diff--
+			}
+			put(uint32(diff)&(1<<category-1), category)
+			put(0, 1) // EOB
+		}
+	}
+	for n != 0 {
+		put(1, 1)
+	}
+
+	out.Write([]byte{0xFF, 0xD9})
+	return out.Bytes()
+}
+
+func TestCMYKJPEGDecodesToTrueColors(t *testing.T) {
+	// Ink amounts for red and cyan
+	inks := [][4]uint8{{0, 255, 255, 0}, {255, 0, 0, 0}}
+	want := []color.RGBA{{255, 0, 0, 255}, {0, 255, 255, 255}}
+
+	inverted := make([][4]uint8, len(inks))
+	for i, ink := range inks {
+		for c := range ink {
+			inverted[i][c] = 255 - ink[c]
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"adobe", cmykJPEG(inverted, true)},
+		{"plain", cmykJPEG(inks, false)},
+	} {
+		img, format, err := decodeImage(tc.data)
+		if err != nil {
+			t.Fatalf("%s: decodeImage: %v", tc.name, err)
+		}
+		if format != "jpeg" {
+			t.Errorf("%s: format %q, want jpeg", tc.name, format)
+		}
+		for i, w := range want {
+			got := color.RGBAModel.Convert(img.At(8*i+4, 4)).(color.RGBA)
+			if d := colorDistance(got, w) / 257; d > 3 {
+				t.Errorf("%s: block %d decoded to %v, want %v", tc.name, i, got, w)
+			}
+		}
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 // KDTree implements a 2D k-d tree for fast nearest neighbor search
 type KDTree struct {
-	root *kdNode
+	root    *kdNode
+	nodes   []kdNode // Node storage, reused by Rebuild
+	scratch []Point  // Working copy of the points, reused by Rebuild
+	metric  CellMetric
 }
 
 type kdNode struct {
@@ -14,88 +17,124 @@ type kdNode struct {
 
 // NewKDTree builds a k-d tree from a slice of points
 func NewKDTree(points []Point) *KDTree {
+	return NewKDTreeWithMetric(points, CellEuclidean)
+}
+
+// NewKDTreeWithMetric builds a k-d tree whose nearest neighbor searches use the given metric
+func NewKDTreeWithMetric(points []Point, metric CellMetric) *KDTree {
+	tree := &KDTree{metric: metric}
+	tree.Rebuild(points)
+	return tree
+}
+
+// Rebuild replaces the tree contents with the given points, reusing the node and
+// scratch storage from the previous build. Iterative point relaxation can call this
+// every iteration without reallocating the tree.
+func (tree *KDTree) Rebuild(points []Point) {
+	tree.root = nil
 	if len(points) == 0 {
-		return &KDTree{}
+		return
 	}
 
 	// Make a copy to avoid modifying the original
-	pointsCopy := make([]Point, len(points))
-	copy(pointsCopy, points)
+	tree.scratch = append(tree.scratch[:0], points...)
 
-	return &KDTree{
-		root: buildKDTree(pointsCopy, 0),
+	// Every point becomes exactly one node, so this never grows during the build
+	// and the node pointers stay valid
+	if cap(tree.nodes) < len(points) {
+		tree.nodes = make([]kdNode, 0, len(points))
 	}
+	tree.nodes = tree.nodes[:0]
+
+	tree.root = tree.buildKDTree(tree.scratch, 0)
 }
 
 // buildKDTree recursively builds the k-d tree
-func buildKDTree(points []Point, depth int) *kdNode {
+func (tree *KDTree) buildKDTree(points []Point, depth int) *kdNode {
 	if len(points) == 0 {
 		return nil
 	}
 
-	if len(points) == 1 {
-		return &kdNode{
-			point:     points[0],
-			splitAxis: depth % 2,
-		}
-	}
-
 	axis := depth % 2
 
-	// Sort points by the current axis
-	sortPointsByAxis(points, axis)
-
-	// Find median
+	// Partition around the median on the current axis
 	median := len(points) / 2
+	selectByAxis(points, median, axis)
 
-	return &kdNode{
+	tree.nodes = append(tree.nodes, kdNode{
 		point:     points[median],
 		splitAxis: axis,
-		left:      buildKDTree(points[:median], depth+1),
-		right:     buildKDTree(points[median+1:], depth+1),
+	})
+	node := &tree.nodes[len(tree.nodes)-1]
+	node.left = tree.buildKDTree(points[:median], depth+1)
+	node.right = tree.buildKDTree(points[median+1:], depth+1)
+
+	return node
+}
+
+// axisValue returns the X (axis=0) or Y (axis=1) coordinate of a point
+func axisValue(p Point, axis int) int {
+	if axis == 0 {
+		return p.X
 	}
+	return p.Y
 }
 
-// sortPointsByAxis sorts points by X (axis=0) or Y (axis=1)
-func sortPointsByAxis(points []Point, axis int) {
-	// Simple insertion sort (efficient for small arrays)
-	for i := 1; i < len(points); i++ {
-		key := points[i]
-		j := i - 1
+// selectByAxis reorders points so the k-th smallest by axis is at index k, with
+// no larger values before it and no smaller values after it (quickselect)
+func selectByAxis(points []Point, k, axis int) {
+	left, right := 0, len(points)-1
 
-		var keyVal, pointVal int
-		if axis == 0 {
-			keyVal = key.X
-		} else {
-			keyVal = key.Y
+	for left < right {
+		// Median-of-three pivot keeps sorted input from degrading to quadratic time
+		mid := left + (right-left)/2
+		if axisValue(points[mid], axis) < axisValue(points[left], axis) {
+			points[mid], points[left] = points[left], points[mid]
+		}
+		if axisValue(points[right], axis) < axisValue(points[left], axis) {
+			points[right], points[left] = points[left], points[right]
 		}
+		if axisValue(points[right], axis) < axisValue(points[mid], axis) {
+			points[right], points[mid] = points[mid], points[right]
+		}
+		pivot := axisValue(points[mid], axis)
 
-		for j >= 0 {
-			if axis == 0 {
-				pointVal = points[j].X
-			} else {
-				pointVal = points[j].Y
+		// Hoare partition
+		i, j := left, right
+		for i <= j {
+			for axisValue(points[i], axis) < pivot {
+				i++
 			}
-
-			if pointVal <= keyVal {
-				break
+			for axisValue(points[j], axis) > pivot {
+				j--
+			}
+			if i <= j {
+				points[i], points[j] = points[j], points[i]
+				i++
+				j--
 			}
+		}
 
-			points[j+1] = points[j]
-			j--
+		if k <= j {
+			right = j
+		} else if k >= i {
+			left = i
+		} else {
+			return
 		}
-		points[j+1] = key
 	}
 }
 
-// FindNearest returns the index of the nearest point to (x, y)
+// FindNearest returns the index of the nearest point to (x, y). Ties go to the lowest
+// index, matching a linear scan, which matters for the Manhattan and Chebyshev metrics
+// where equidistant points are common.
 func (tree *KDTree) FindNearest(x, y int) int {
 	if tree.root == nil {
 		return 0
 	}
 
 	bestNode := tree.root
-	bestDist := distanceSquared(x, y, tree.root.point.X, tree.root.point.Y)
+	bestDist := tree.metric.distance(x, y, tree.root.point.X, tree.root.point.Y)
 
 	tree.findNearestHelper(tree.root, x, y, &bestNode, &bestDist)
 
@@ -109,8 +148,8 @@ func (tree *KDTree) findNearestHelper(node *kdNode, x, y int, bestNode **kdNode,
 	}
 
 	// Check if current node is closer
-	dist := distanceSquared(x, y, node.point.X, node.point.Y)
-	if dist < *bestDist {
+	dist := tree.metric.distance(x, y, node.point.X, node.point.Y)
+	if dist < *bestDist || (dist == *bestDist && node.point.Index < (*bestNode).point.Index) {
 		*bestDist = dist
 		*bestNode = node
 	}
@@ -137,8 +176,8 @@ func (tree *KDTree) findNearestHelper(node *kdNode, x, y int, bestNode **kdNode,
 	tree.findNearestHelper(nearChild, x, y, bestNode, bestDist)
 
 	// Check if we need to search far side
-	// Only search if the splitting plane is closer than current best
-	if float64(diff*diff) < *bestDist {
+	// Only search if the splitting plane is no farther than current best (ties may win on index)
+	if tree.metric.planeDistance(diff) <= *bestDist {
 		tree.findNearestHelper(farChild, x, y, bestNode, bestDist)
 	}
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomPoints returns n indexed points spread uniformly over a size×size square
+func randomPoints(rng *rand.Rand, n, size int) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = Point{X: rng.Intn(size), Y: rng.Intn(size), Index: i}
+	}
+	return points
+}
+
+// relax nudges every point a little, standing in for one Lloyd relaxation step
+func relax(rng *rand.Rand, points []Point, size int) {
+	nudge := func(v int) int {
+		v += rng.Intn(5) - 2
+		if v < 0 {
+			return 0
+		}
+		return min(v, size-1)
+	}
+	for i := range points {
+		points[i].X = nudge(points[i].X)
+		points[i].Y = nudge(points[i].Y)
+	}
+}
+
+func TestKDTreeRebuildMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const size = 400
+	points := randomPoints(rng, 500, size)
+	tree := NewKDTree(points)
+
+	for iteration := 0; iteration < 5; iteration++ {
+		relax(rng, points, size)
+		tree.Rebuild(points)
+
+		for q := 0; q < 500; q++ {
+			x, y := rng.Intn(size), rng.Intn(size)
+			if got, want := tree.FindNearest(x, y), findNearestPoint(x, y, points); got != want {
+				t.Fatalf("iteration %d: FindNearest(%d, %d) = %d, brute force %d", iteration, x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestKDTreeRebuildReusesNodes(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	points := randomPoints(rng, 1000, 500)
+	tree := NewKDTree(points)
+	nodes := &tree.nodes[:1][0]
+
+	relax(rng, points, 500)
+	if allocs := testing.AllocsPerRun(5, func() { tree.Rebuild(points) }); allocs != 0 {
+		t.Errorf("Rebuild allocated %.0f times per call, want none", allocs)
+	}
+	if &tree.nodes[:1][0] != nodes {
+		t.Error("Rebuild replaced the node storage")
+	}
+}
+
+// BenchmarkKDTreeRelaxation measures ten relaxation iterations on 5000 points, rebuilding
+// the tree in place or building a new one each time
+func BenchmarkKDTreeRelaxation(b *testing.B) {
+	const size = 1024
+	for _, bc := range []struct {
+		name    string
+		rebuild bool
+	}{
+		{"rebuild", true},
+		{"new", false},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			points := randomPoints(rng, 5000, size)
+			tree := NewKDTree(points)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for iteration := 0; iteration < 10; iteration++ {
+					relax(rng, points, size)
+					if bc.rebuild {
+						tree.Rebuild(points)
+					} else {
+						tree = NewKDTree(points)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKDTreeMatchesBruteForceForEachCellMetric(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	points := randomPoints(rng, 300, 200)
+	for _, metric := range []CellMetric{CellEuclidean, CellManhattan, CellChebyshev} {
+		tree := NewKDTreeWithMetric(points, metric)
+		for q := 0; q < 2000; q++ {
+			x, y := rng.Intn(200), rng.Intn(200)
+			got, want := tree.FindNearest(x, y), findNearestPointWithMetric(x, y, points, metric)
+			// Equidistant seeds are common under Manhattan and Chebyshev; either is right
+			if got != want && metric.distance(x, y, points[got].X, points[got].Y) != metric.distance(x, y, points[want].X, points[want].Y) {
+				t.Fatalf("metric %d: FindNearest(%d, %d) = %d, brute force %d", metric, x, y, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// maxLabelInset is the largest labelInset processImage accepts, in pixels
+const maxLabelInset = 50
+
+// insetField records, for every pixel of a sheet, which region owns it and its squared
+// distance to the nearest border pixel, so labels can be kept clear of the border lines
+type insetField struct {
+	bounds image.Rectangle
+	owner  []int     // Index into regions, -1 for pixels no region kept
+	dist   []float64 // Squared Euclidean distance to the nearest border pixel
+}
+
+// newInsetField builds the border mask of regions over bounds (every pixel with a
+// 4-neighbor owned by another region, by none or off the sheet) and its distance transform
+func newInsetField(regions []Region, bounds image.Rectangle) *insetField {
+	width, height := bounds.Dx(), bounds.Dy()
+	owner := make([]int, width*height)
+	for i := range owner {
+		owner[i] = -1
+	}
+	for ri, region := range regions {
+		for _, p := range region.Pixels {
+			if p.In(bounds) {
+				owner[(p.Y-bounds.Min.Y)*width+(p.X-bounds.Min.X)] = ri
+			}
+		}
+	}
+
+	border := make([]bool, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			border[i] = owner[i] < 0 || x == 0 || y == 0 || x == width-1 || y == height-1 ||
+				owner[i-1] != owner[i] || owner[i+1] != owner[i] ||
+				owner[i-width] != owner[i] || owner[i+width] != owner[i]
+		}
+	}
+
+	return &insetField{bounds: bounds, owner: owner, dist: squaredDistanceTransform(border, width, height)}
+}
+
+// clear reports whether every pixel of glyph lies in region ri at least inset pixels from
+// the nearest border pixel
+func (f *insetField) clear(glyph []image.Point, ri int, inset float64) bool {
+	width := f.bounds.Dx()
+	for _, p := range glyph {
+		if !p.In(f.bounds) {
+			return false
+		}
+		i := (p.Y-f.bounds.Min.Y)*width + (p.X - f.bounds.Min.X)
+		if f.owner[i] != ri || f.dist[i] < inset*inset {
+			return false
+		}
+	}
+	return true
+}
+
+// insetPosition returns the point of region ri nearest pos where glyphAt draws a label
+// whose every pixel is at least inset pixels from a border. A region too thin for any
+// such point gets its label at its pixel farthest from the border instead.
+func (f *insetField) insetPosition(region Region, ri int, pos image.Point, glyphAt func(image.Point) []image.Point, inset int) image.Point {
+	need := float64(inset)
+	if f.clear(glyphAt(pos), ri, need) {
+		return pos
+	}
+
+	width := f.bounds.Dx()
+	depth := func(p image.Point) float64 {
+		return f.dist[(p.Y-f.bounds.Min.Y)*width+(p.X-f.bounds.Min.X)]
+	}
+
+	// Only pixels deep enough themselves can carry a clear glyph; try the closest first
+	deepest, found := pos, false
+	var candidates []image.Point
+	for _, p := range region.Pixels {
+		if !p.In(f.bounds) {
+			continue
+		}
+		if !found || depth(p) > depth(deepest) {
+			deepest, found = p, true
+		}
+		if depth(p) >= need*need {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		da, db := candidates[a].Sub(pos), candidates[b].Sub(pos)
+		return da.X*da.X+da.Y*da.Y < db.X*db.X+db.Y*db.Y
+	})
+	for _, c := range candidates {
+		if f.clear(glyphAt(c), ri, need) {
+			return c
+		}
+	}
+	return deepest
+}
+
+// squaredDistanceTransform returns, for each pixel of a width × height mask, the squared
+// Euclidean distance to the nearest set pixel, exactly, using Felzenszwalb and
+// Huttenlocher's separable lower-envelope passes over columns and then rows. With no set
+// pixel at all every distance is +Inf.
+func squaredDistanceTransform(mask []bool, width, height int) []float64 {
+	dist := make([]float64, width*height)
+	for i, set := range mask {
+		if !set {
+			dist[i] = math.Inf(1)
+		}
+	}
+
+	n := max(float64(width), float64(height))
+	f := make([]float64, int(n))
+	d := make([]float64, int(n))
+	v := make([]int, int(n))
+	z := make([]float64, int(n)+1)
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			f[y] = dist[y*width+x]
+		}
+		lowerEnvelope(f[:height], d, v, z)
+		for y := 0; y < height; y++ {
+			dist[y*width+x] = d[y]
+		}
+	}
+	for y := 0; y < height; y++ {
+		row := dist[y*width : (y+1)*width]
+		copy(f, row)
+		lowerEnvelope(f[:width], d, v, z)
+		copy(row, d[:width])
+	}
+	return dist
+}
+
+// lowerEnvelope writes to d the 1D squared distance transform of f, d[q] = min over p of
+// (q-p)² + f[p], by sweeping the lower envelope of the parabolas rooted at each finite f[p].
+// v and z are scratch space of at least len(f) and len(f)+1.
+func lowerEnvelope(f, d []float64, v []int, z []float64) {
+	k := -1
+	for q := range f {
+		if math.IsInf(f[q], 1) {
+			continue
+		}
+		for k >= 0 {
+			p := v[k]
+			s := ((f[q] + float64(q*q)) - (f[p] + float64(p*p))) / float64(2*q-2*p)
+			if s > z[k] {
+				k++
+				v[k], z[k] = q, s
+				break
+			}
+			k--
+		}
+		if k < 0 {
+			k = 0
+			v[0], z[0] = q, math.Inf(-1)
+		}
+		z[k+1] = math.Inf(1)
+	}
+
+	if k < 0 {
+		for q := range f {
+			d[q] = math.Inf(1)
+		}
+		return
+	}
+	j := 0
+	for q := range f {
+		for z[j+1] < float64(q) {
+			j++
+		}
+		diff := float64(q - v[j])
+		d[q] = diff*diff + f[v[j]]
+	}
+}
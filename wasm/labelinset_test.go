@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestLabelInsetKeepsDigitsClearOfBorders(t *testing.T) {
+	// An L-shaped region wrapped around a rectangle, whose centroid falls near the corner
+	// where the two meet, plus a band along the top just tall enough for an inset label
+	bounds := image.Rect(0, 0, 120, 90)
+	assignment := make([]int, bounds.Dx()*bounds.Dy())
+	paintRect(assignment, bounds.Dx(), image.Rect(24, 20, 120, 70), 1)
+	paintRect(assignment, bounds.Dx(), image.Rect(0, 0, 120, 20), 2)
+	regions := buildLabeledRegions(assignment, bounds, testPalette, 20, LabelUnionFind, false)
+
+	// Border pixels, found by brute force: those with a 4-neighbor in another region or off the sheet
+	owner := make(map[image.Point]int)
+	for ri, r := range regions {
+		for _, p := range r.Pixels {
+			owner[p] = ri
+		}
+	}
+	var border []image.Point
+	for p, ri := range owner {
+		for _, d := range []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			if n, ok := owner[p.Add(d)]; !ok || n != ri {
+				border = append(border, p)
+				break
+			}
+		}
+	}
+
+	for _, inset := range []int{2, 5} {
+		labels := placeRegionLabels(regions, testPalette, bounds, numberStyle{inset: inset})
+		if len(labels) != len(regions) {
+			t.Fatalf("inset %d: %d labels for %d regions", inset, len(labels), len(regions))
+		}
+		for _, l := range labels {
+			for _, p := range l.glyph {
+				nearest := math.Inf(1)
+				for _, b := range border {
+					d := p.Sub(b)
+					nearest = math.Min(nearest, math.Hypot(float64(d.X), float64(d.Y)))
+				}
+				if nearest < float64(inset) {
+					t.Errorf("inset %d: label %s has pixel %v %.1f from a border", inset, l.text, p, nearest)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+// Legend layout in pixels
+const (
+	legendMargin      = 8
+	legendRowHeight   = 24
+	legendNumberWidth = 18
+	legendSwatchWidth = 32
+	legendHexWidth    = 7 * 6 // "#rrggbb" at 6px per character
+	legendEntryWidth  = legendNumberWidth + legendSwatchWidth + 6 + legendHexWidth + legendMargin*2
+)
+
+// legendAspect is the width/height ratio renderLegend aims for, that of a portrait letter
+// page, so large palettes print as a compact grid instead of a long strip
+const legendAspect = 8.5 / 11
+
+// legendGrid picks how many columns and rows of entries to use for count colors, choosing
+// the column count whose legend comes closest to legendAspect
+func legendGrid(count int) (cols, rows int) {
+	if count <= 0 {
+		return 1, 0
+	}
+
+	cols, rows = 1, count
+	best := math.Inf(1)
+	for c := 1; c <= count; c++ {
+		r := (count + c - 1) / c
+		width := float64(legendMargin + c*legendEntryWidth)
+		height := float64(legendMargin*2 + r*legendRowHeight)
+		if miss := math.Abs(math.Log(width / height / legendAspect)); miss < best {
+			best, cols, rows = miss, c, r
+		}
+		if r == 1 {
+			break
+		}
+	}
+	return cols, rows
+}
+
+// renderLegend draws an entry per palette color (its number, a swatch and the hex code),
+// numbered down each column of a grid sized by legendGrid
+func renderLegend(palette []color.Color) *image.RGBA {
+	cols, rows := legendGrid(len(palette))
+	width := legendMargin + cols*legendEntryWidth
+	height := legendMargin*2 + rows*legendRowHeight
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for i, c := range palette {
+		col, row := i/rows, i%rows
+		drawLegendEntry(img, legendMargin+col*legendEntryWidth, legendMargin+row*legendRowHeight, i+1, c)
+	}
+
+	return img
+}
+
+// drawLegendEntry draws a number, swatch and hex code with the top-left corner at (x, y)
+func drawLegendEntry(img *image.RGBA, x, y, number int, c color.Color) {
+	swatchHeight := legendRowHeight - 6
+	textY := y + (swatchHeight-7)/2 // Glyphs are 7px tall
+	black := color.RGBA{0, 0, 0, 255}
+
+	drawText(img, strconv.Itoa(number), x, textY, black)
+
+	// Swatch with a thin black outline
+	swatch := image.Rect(0, 0, legendSwatchWidth, swatchHeight).Add(image.Point{X: x + legendNumberWidth, Y: y})
+	draw.Draw(img, swatch, &image.Uniform{black}, image.Point{}, draw.Src)
+	draw.Draw(img, swatch.Inset(1), &image.Uniform{c}, image.Point{}, draw.Src)
+
+	drawText(img, colorToHex(c), swatch.Max.X+6, textY, black)
+}
+
+// appendLegendStrip extends the canvas with a legend along the bottom or right edge so the
+// sheet and its key print as a single page. Position "none" returns the image unchanged.
+func appendLegendStrip(img image.Image, palette []color.Color, position string) image.Image {
+	if len(palette) == 0 || (position != "bottom" && position != "right") {
+		return img
+	}
+
+	bounds := img.Bounds()
+	var cols, rows int
+	var canvas image.Rectangle
+
+	if position == "bottom" {
+		// Wrap entries into as many columns as fit across the image
+		cols = (bounds.Dx() - legendMargin) / legendEntryWidth
+		if cols < 1 {
+			cols = 1
+		}
+		rows = (len(palette) + cols - 1) / cols
+		stripHeight := legendMargin*2 + rows*legendRowHeight
+		width := bounds.Dx()
+		if minWidth := legendMargin + cols*legendEntryWidth; width < minWidth {
+			width = minWidth
+		}
+		canvas = image.Rect(0, 0, width, bounds.Dy()+stripHeight)
+	} else {
+		// Stack entries down the side, adding columns when they run past the bottom
+		rows = (bounds.Dy() - legendMargin*2) / legendRowHeight
+		if rows < 1 {
+			rows = 1
+		}
+		cols = (len(palette) + rows - 1) / rows
+		if cols == 1 {
+			rows = len(palette)
+		}
+		stripWidth := legendMargin + cols*legendEntryWidth
+		height := bounds.Dy()
+		if minHeight := legendMargin*2 + rows*legendRowHeight; height < minHeight {
+			height = minHeight
+		}
+		canvas = image.Rect(0, 0, bounds.Dx()+stripWidth, height)
+	}
+
+	result := image.NewRGBA(canvas)
+	draw.Draw(result, canvas, &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(result, bounds.Sub(bounds.Min), img, bounds.Min, draw.Src)
+
+	// Top-left of the legend area
+	originX, originY := legendMargin, bounds.Dy()+legendMargin
+	if position == "right" {
+		originX, originY = bounds.Dx()+legendMargin, legendMargin
+	}
+
+	for i, c := range palette {
+		col, row := i%cols, i/cols
+		if position == "right" {
+			col, row = i/rows, i%rows
+		}
+		drawLegendEntry(result, originX+col*legendEntryWidth, originY+row*legendRowHeight, i+1, c)
+	}
+
+	return result
+}
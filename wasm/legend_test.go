@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAppendLegendStripBottom(t *testing.T) {
+	sheet := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	palette := []color.Color{
+		color.RGBA{220, 40, 40, 255},
+		color.RGBA{40, 160, 60, 255},
+		color.RGBA{30, 60, 200, 255},
+	}
+
+	out := appendLegendStrip(sheet, palette, "bottom")
+	b := out.Bounds()
+	if b.Dx() != 300 || b.Dy() <= 200 {
+		t.Fatalf("bottom legend gave %dx%d, want 300 wide and taller than 200", b.Dx(), b.Dy())
+	}
+
+	// Every swatch color appears in the added strip
+	found := make(map[color.RGBA]bool)
+	for y := 200; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			found[color.RGBAModel.Convert(out.At(x, y)).(color.RGBA)] = true
+		}
+	}
+	for _, c := range palette {
+		if !found[c.(color.RGBA)] {
+			t.Errorf("legend strip has no %v swatch", c)
+		}
+	}
+
+	if out := appendLegendStrip(sheet, palette, "none"); out.Bounds() != sheet.Bounds() {
+		t.Errorf("position none changed bounds to %v", out.Bounds())
+	}
+}
+
+func TestLargeLegendIsPageShaped(t *testing.T) {
+	palette := make([]color.Color, 64)
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(i * 4), uint8(255 - i*4), uint8(i * 37), 255}
+	}
+
+	cols, rows := legendGrid(len(palette))
+	if cols < 2 || cols*rows < len(palette) || (cols-1)*rows >= len(palette) {
+		t.Errorf("legendGrid(64) = %d columns of %d rows", cols, rows)
+	}
+
+	b := renderLegend(palette).Bounds()
+	if aspect := float64(b.Dx()) / float64(b.Dy()); aspect < 0.5 || aspect > 1.1 {
+		t.Errorf("64-color legend is %dx%d (aspect %.2f), want about a portrait page", b.Dx(), b.Dy(), aspect)
+	}
+
+	if cols, rows := legendGrid(3); cols != 1 || rows != 3 {
+		t.Errorf("legendGrid(3) = %d columns of %d rows, want a single column", cols, rows)
+	}
+}
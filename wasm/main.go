@@ -2,38 +2,221 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"image"
+	"image/color"
+	"image/draw"
 	_ "image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"syscall/js"
+	"time"
 )
 
 // ProcessResult contains the result of image processing
 type ProcessResult struct {
-	Image   string      `json:"image"`
-	Palette []ColorInfo `json:"palette"`
-	Error   string      `json:"error,omitempty"`
+	Image           string         `json:"image"`
+	Palette         []ColorInfo    `json:"palette"`
+	DistinctNumbers int            `json:"distinctNumbers"`
+	Seed            int64          `json:"seed"`
+	Width           int            `json:"width"`
+	Height          int            `json:"height"`
+	Warning         string         `json:"warning,omitempty"`
+	Thumbnail       string         `json:"thumbnail,omitempty"`
+	LabelLayer      string         `json:"labelLayer,omitempty"`
+	ColorMasks      []ColorMask    `json:"colorMasks,omitempty"`
+	RegionsByColor  []ColorRegions `json:"regionsByColor,omitempty"`
+	Polygons        *RegionGeoJSON `json:"polygons,omitempty"`
+	Degraded        bool           `json:"degraded,omitempty"`
+	Diagnostics     *Diagnostics   `json:"diagnostics,omitempty"`
+	Recipe          string         `json:"recipe,omitempty"`
+	Zip             string         `json:"zip,omitempty"`
+	CSV             string         `json:"csv,omitempty"`
+	GPL             string         `json:"gpl,omitempty"`
+	ASE             string         `json:"ase,omitempty"`
+	SVG             string         `json:"svg,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	ErrorCode       string         `json:"errorCode,omitempty"`
 }
 
 // ColorInfo contains color information
 type ColorInfo struct {
-	Number int    `json:"number"`
-	Hex    string `json:"hex"`
-	C      int    `json:"c"`
-	M      int    `json:"m"`
-	Y      int    `json:"y"`
-	K      int    `json:"k"`
+	Number   int     `json:"number"`
+	Hex      string  `json:"hex"`
+	R        int     `json:"r"`
+	G        int     `json:"g"`
+	B        int     `json:"b"`
+	C        int     `json:"c"`
+	M        int     `json:"m"`
+	Y        int     `json:"y"`
+	K        int     `json:"k"`
+	Coverage float64 `json:"coverage"` // Fraction of the image painted in this color
+}
+
+// VerboseColorInfo is ColorInfo with spelled-out field names, for API consumers that ask for them
+type VerboseColorInfo struct {
+	Number   int     `json:"number"`
+	Hex      string  `json:"hex"`
+	Red      int     `json:"red"`
+	Green    int     `json:"green"`
+	Blue     int     `json:"blue"`
+	Cyan     int     `json:"cyan"`
+	Magenta  int     `json:"magenta"`
+	Yellow   int     `json:"yellow"`
+	Black    int     `json:"black"`
+	Coverage float64 `json:"coverage"`
+}
+
+// verboseProcessResult is a ProcessResult whose palette uses VerboseColorInfo; the outer
+// Palette field shadows the embedded one when marshaling
+type verboseProcessResult struct {
+	ProcessResult
+	Palette []VerboseColorInfo `json:"palette"`
+}
+
+// verbosePalette converts palette info to the spelled-out field names
+func verbosePalette(palette []ColorInfo) []VerboseColorInfo {
+	verbose := make([]VerboseColorInfo, len(palette))
+	for i, c := range palette {
+		verbose[i] = VerboseColorInfo{
+			Number:   c.Number,
+			Hex:      c.Hex,
+			Red:      c.R,
+			Green:    c.G,
+			Blue:     c.B,
+			Cyan:     c.C,
+			Magenta:  c.M,
+			Yellow:   c.Y,
+			Black:    c.K,
+			Coverage: c.Coverage,
+		}
+	}
+	return verbose
+}
+
+// ColorRegions lists the paintable areas of one palette color
+type ColorRegions struct {
+	Number  int         `json:"number"`
+	Regions []RegionBox `json:"regions"`
+}
+
+// RegionBox is the bounding box, centroid and area of one region, in output image pixels
+type RegionBox struct {
+	X         int `json:"x"`
+	Y         int `json:"y"`
+	W         int `json:"w"`
+	H         int `json:"h"`
+	CentroidX int `json:"cx"`
+	CentroidY int `json:"cy"`
+	Area      int `json:"area"`
+}
+
+// ProcessOptions contains optional settings passed as an object after the positional arguments.
+// maxDimension, a positional argument, is a pixel budget of maxDimension² rather than a cap on
+// the long side: a panorama keeps its short side usable and its long side grows past
+// maxDimension by √(aspect ratio). Set MaxAspectRatio to bound that overshoot.
+type ProcessOptions struct {
+	GammaCorrect   bool           // Blend in linear light when downsampling
+	ColorMetric    ColorMetric    // Distance used for palette clustering and quantization
+	CellMetric     CellMetric     // Distance that shapes Voronoi cells (Euclidean, Manhattan or Chebyshev)
+	PointIndex     PointIndex     // Nearest-seed lookup: k-d tree, spatial hash, or auto (hash for the uniform distribution)
+	Connectivity   Connectivity   // Neighbors compared to find borders: disk (centered, default), 4, 8 or forward (thinnest, one-sided)
+	Supersample    int            // Antialias colored Voronoi cells by rendering at 2x or 4x (0 or 1 = off)
+	Style          string         // "reference", "sheet", "mosaic" or "lineart" to fix fills and numbers ("" = from showColors and line width)
+	TraceOpacity   float64        // Show the original through blank fills at this opacity, 0-1, as a tracing guide (0 = plain white)
+	Texture        float64        // Multiply a canvas texture onto colored fills at this intensity, 0-1 (0 = flat color)
+	ColorBorders   bool           // Draw Voronoi borders only between different palette colors, not between same-color cells
+	LocalContrast  bool           // Recolor regions too alike to a neighbor with the next-nearest distinct palette color
+	StippleRadius  int            // Draw a dot of this radius at each Voronoi seed instead of filling cells (0 = off)
+	Background     color.Color    // Background behind stipple dots
+	FixedPalette   string         // Quantize to a built-in catalog ("websafe" or "paint24") instead of k-means ("" = off)
+	Palette        []color.Color  // Lock the sheet to exactly these colors, numbered in this order, instead of generating a palette (nil = off)
+	MaxAspectRatio float64        // Reject images whose long side exceeds this multiple of the short side, which also caps the downsampled long side at maxDimension×√ratio (0 = no limit)
+	WhiteBalance   bool           // Apply gray-world white balance before palette generation
+	Temperature    int            // Shift the clustered palette toward cool (-100) or warm (100) tones (0 = off)
+	AlphaThreshold int            // Pixels below this alpha (1-255) become white background, the rest opaque (0 = off)
+	Denoise        float64        // Bilateral filter spatial sigma applied after downsampling (0 = off)
+	DenoiseRange   float64        // Bilateral filter range sigma in 0-255 units (0 = defaultDenoiseRange)
+	Despeckle      int            // Grid mode: recolor pixels matching fewer than this many of their 8 neighbors, 0-3 (0 = off)
+	MergeDistance  float64        // Merge clustered palette colors closer than this CIEDE2000 difference (0 = off)
+	Simplify       int            // One 0-100 knob raising color merging, minimum numbered area and despeckling together
+	Sharpen        float64        // Unsharp-mask amount applied after downsampling (0 = off)
+	SpatialWeight  float64        // Weight of pixel position when clustering the palette (0 = color only)
+	PaletteSamples int            // Cap on pixels sampled for palette clustering (0 = defaultMaxPaletteSamples)
+	PaletteSize    int            // Cluster the palette on a copy downsampled to about this size, rendering stays full detail (0 = off)
+	PaletteShards  int            // Split palette samples into this many k-means shards merged at the end, 2-16 (0 = one k-means)
+	MiniBatch      bool           // Cluster the palette with mini-batch k-means, whose cost does not grow with PaletteSamples
+	WeightedKMeans bool           // Cluster a histogram of all pixels weighted by frequency instead of a sample grid
+	MinRegionArea  AreaThreshold  // Smallest numbered region, in pixels or percent of the image
+	Labeling       LabelAlgorithm // Connected-component labeling used to find regions
+	KeepAllColors  bool           // Number at least one region of every color instead of dropping colors with only tiny regions
+	NumberSpacing  int            // Repeat numbers across large regions on a grid of this many pixels (0 = once per region)
+	NumberOutline  int            // Thickness of the white halo around numbers, 0-3 pixels (0 = flat numbers)
+	NumberScale    int            // Whole-pixel magnification of the number glyphs, 1-3 (0 = 1)
+	GridLabels     int            // Snap numbers to a grid with this pitch in pixels, for aligned sheets (0 = off)
+	LabelGap       int            // Skip numbers closer than this many pixels to a larger region's number (0 = off)
+	LabelInset     int            // Move numbers until their digits sit at least this many pixels inside region borders (0 = off)
+	RegionLabel    RegionLabel    // Print each region's number, hex code or color name
+	BorderColor    color.Color    // Color of region borders (nil = black)
+	NumberColor    color.Color    // Color of region numbers (nil = black)
+	LegendPosition string         // Draw the palette key along the "bottom" or "right" of the output, or "none"
+	Seed           int64          // Random seed; identical inputs and seed give identical output (0 = random)
+	Margin         Margin         // Padding around the finished sheet, in pixels or percent of the shorter side
+	MarginColor    color.Color    // Color of the margin
+	Thumbnail      bool           // Also return a small PNG preview of the result
+	LabelLayer     bool           // Also return the numbers alone as a transparent PNG aligned with the result
+	ColorMasks     bool           // Also return a black-and-white mask PNG per color, aligned with the result
+	ExportRecipe   bool           // Also return a recipe JSON that reproduces this result
+	Regions        bool           // Also return per-color region bounding boxes for guided painting
+	Polygons       bool           // Also return region outlines as GeoJSON-like polygon features
+	Verbose        bool           // Spell out palette field names (red, cyan, ...) instead of r, c, ...
+	Diagnostics    bool           // Also return per-stage timings and image sizes
+	TargetRegions  int            // Voronoi mode: tune the point count until the sheet has about this many numbered regions (0 = off)
+	Stage          PipelineStage  // Stop rendering after "raw" seed colors, "quantized" fills or "borders" (StageFinal = finished sheet)
+	Deterministic  bool           // Same inputs give byte-identical output: a zero Seed is fixed and TimeBudget ignored
+	TimeBudget     int            // Milliseconds processImage may take; a sheet projected to run over is made smaller, one that still runs over fails with ErrTimeout (0 = no limit)
+	Format         string         // "png", "zip" to also bundle every artifact, "csv" to add a palette CSV, "gpl" a GIMP palette, "ase" an Adobe swatch file or "svg" a vector sheet
+	ColorProfile   string         // "srgb" to tag the result PNG with sRGB, gAMA and cHRM chunks, or "none"
+	Compression    string         // zlib effort for every returned PNG: "default", "none", "fast" or "best"
+	FeaturePoints  bool           // Seed Voronoi points at detected corners, topped up from Distribution
+
+	Crop         image.Rectangle   // Process only this rectangle of the decoded image, in its pixel coordinates (empty = all of it)
+	Distribution PointDistribution // Where Voronoi seed points concentrate: edges (default), uniform, center or a custom map
+	DensityMap   image.Image       // Grayscale map for DistributionCustom, brighter = more points; stretched to the image
+
+	Mask           image.Image // Process only where this map is at least half white; stretched to the image (nil = everywhere)
+	MaskBackground color.Color // Plain color painted outside Mask
+
+	Fill     FillConfig       // Worker count and chunk size of the Voronoi fill (from PBN_FILL_WORKERS and PBN_FILL_ROWS, not from JS)
+	Progress ProgressCallback // Receives stage reports during layout and rendering (set by Go callers, not from JS)
+	Context  context.Context  // Once done, palette clustering stops refining and keeps what it has (nil = never)
 }
 
 func main() {
 	fmt.Println("🎨 Paint by Numbers WASM initialized!")
 
+	// Optional startup check (set PBN_SELFTEST in go.env); a broken build stops here
+	if os.Getenv("PBN_SELFTEST") != "" {
+		if err := runSelfTest(); err != nil {
+			panic("self-test failed: " + err.Error())
+		}
+		fmt.Println("✓ Self-test passed")
+	}
+
 	// Register the main processing function
-	js.Global().Set("processImage", js.FuncOf(processImage))
+	js.Global().Set("processImage", js.FuncOf(recovering("processImage", processImage)))
+	js.Global().Set("edgeMapImage", js.FuncOf(recovering("edgeMapImage", edgeMapImage)))
+	js.Global().Set("selfTest", js.FuncOf(recovering("selfTest", selfTest)))
+	js.Global().Set("comparePalettes", js.FuncOf(recovering("comparePalettes", comparePalettesJS)))
 
 	// Keep the program running
 	<-make(chan bool)
@@ -42,84 +225,402 @@ func main() {
 // processImage is called from JavaScript with image data and parameters
 func processImage(this js.Value, args []js.Value) interface{} {
 	if len(args) < 7 {
-		return createErrorResult("Invalid arguments: expected (imageData, points, colors, lineWidth, maxDimension, showColors, useVoronoi)")
+		return createErrorResult(invalidParam("Invalid arguments: expected (imageData, points, colors, lineWidth, maxDimension, showColors, useVoronoi)"))
 	}
 
-	// Get arguments
+	// Get arguments; a missing or non-numeric one is reported rather than panicking
 	imageData := args[0]
-	numPoints := args[1].Int()
-	numColors := args[2].Int()
-	lineWidth := args[3].Int()
-	maxDimension := args[4].Int()
+	numPoints, err := intArg(args, 1, "Points")
+	if err != nil {
+		return createErrorResult(err)
+	}
+	numColors, err := intArg(args, 2, "Colors")
+	if err != nil {
+		return createErrorResult(err)
+	}
+	lineWidth, err := intArg(args, 3, "Line width")
+	if err != nil {
+		return createErrorResult(err)
+	}
+	maxDimension, err := intArg(args, 4, "Max dimension")
+	if err != nil {
+		return createErrorResult(err)
+	}
 	showColors := args[5].Bool()
 	useVoronoi := args[6].Bool()
 
-	// Validate parameters
-	if numPoints < 50 || numPoints > 50000 {
-		return createErrorResult("Points must be between 50 and 50000")
+	// Optional settings object
+	optsValue := js.Undefined()
+	if len(args) > 7 {
+		optsValue = args[7]
 	}
-	if numColors < 2 || numColors > 64 {
-		return createErrorResult("Colors must be between 2 and 64")
+	exportRecipe := optionBool(optsValue, "exportRecipe", false)
+
+	// A recipe replaces the arguments and options with the ones it recorded
+	var recipe *Recipe
+	if text := optionString(optsValue, "recipe", ""); text != "" {
+		r, err := parseRecipe(text)
+		if err != nil {
+			return createErrorResult(err)
+		}
+		recipe = &r
+		numPoints, numColors, lineWidth, maxDimension = r.Points, r.Colors, r.LineWidth, r.MaxDimension
+		showColors, useVoronoi = r.ShowColors, r.UseVoronoi
+		optsValue = r.options()
 	}
-	if lineWidth < 0 || lineWidth > 5 {
-		return createErrorResult("Line width must be between 0 and 5")
+
+	opts, err := parseProcessOptions(optsValue)
+	if err != nil {
+		return createErrorResult(err)
 	}
-	if maxDimension < 256 || maxDimension > 4096 {
-		return createErrorResult("Max dimension must be between 256 and 4096")
+	opts.ExportRecipe = exportRecipe
+	if opts.ExportRecipe && opts.Mask != nil {
+		return createErrorResult(invalidParam("Recipes cannot record a mask; export the recipe without one"))
+	}
+	if recipe != nil {
+		opts.Seed = recipe.Seed
+	}
+	opts = opts.reproducible()
+
+	// Validate parameters, whether passed in or replayed from a recipe
+	if err := validateSheetArgs(numPoints, numColors, lineWidth, maxDimension); err != nil {
+		return createErrorResult(err)
+	}
+
+	// Operators tune the parallel fill for their host through the environment
+	opts.Fill = fillConfigFromEnv()
+
+	// Time each stage when diagnostics are requested; a nil timer records nothing
+	var timer *stageTimer
+	if opts.Diagnostics {
+		timer = &stageTimer{}
+		opts.Progress = timer.progress
+	}
+	started := time.Now()
+
+	// Past the time budget, palette clustering settles for the centroids it has and the
+	// call fails with a timeout at the next stage boundary
+	if opts.TimeBudget > 0 {
+		ctx, cancel := context.WithDeadline(context.Background(), started.Add(time.Duration(opts.TimeBudget)*time.Millisecond))
+		defer cancel()
+		opts.Context = ctx
 	}
 
 	// Convert JavaScript Uint8Array to Go byte slice
-	length := imageData.Get("length").Int()
-	imageBytes := make([]byte, length)
-	js.CopyBytesToGo(imageBytes, imageData)
+	timer.begin("Decoding")
+	imageBytes := copyBytesFromJS(imageData)
+	length := len(imageBytes)
 
 	fmt.Printf("Processing: %d bytes, points=%d, colors=%d, lineWidth=%d, maxDim=%d, showColors=%v, voronoi=%v\n",
 		length, numPoints, numColors, lineWidth, maxDimension, showColors, useVoronoi)
 
 	// Decode image
-	img, format, err := image.Decode(bytes.NewReader(imageBytes))
+	img, format, err := decodeImageWithAlpha(imageBytes, uint8(opts.AlphaThreshold))
 	if err != nil {
-		return createErrorResult(fmt.Sprintf("Failed to decode image: %v", err))
+		return createErrorResult(err)
 	}
 
 	fmt.Printf("Decoded %s image: %dx%d\n", format, img.Bounds().Dx(), img.Bounds().Dy())
+	inputBounds := img.Bounds()
+
+	// Cut out the requested subject before anything else looks at the pixels
+	if !opts.Crop.Empty() {
+		crop := opts.Crop.Add(img.Bounds().Min)
+		if !crop.In(img.Bounds()) {
+			return createErrorResult(invalidParam("Crop rectangle %dx%d at (%d, %d) does not fit inside the %dx%d image",
+				opts.Crop.Dx(), opts.Crop.Dy(), opts.Crop.Min.X, opts.Crop.Min.Y, img.Bounds().Dx(), img.Bounds().Dy()))
+		}
+		if opts.Mask != nil {
+			opts.Mask = cropMask(opts.Mask, img.Bounds(), crop)
+		}
+		img = cropImage(img, crop)
+	}
+
+	if opts.MaxAspectRatio > 0 {
+		if ratio := aspectRatio(img.Bounds()); ratio > opts.MaxAspectRatio {
+			return createErrorResult(conversionError(ErrTooLarge, "Image aspect ratio %.1f:1 exceeds the maximum of %.1f:1; crop the image first", ratio, opts.MaxAspectRatio))
+		}
+	}
 
 	// Downsample if needed
-	img = downsampleImage(img, maxDimension)
+	timer.begin("Preprocessing")
+	source := img
+	img = preprocessImage(source, maxDimension, opts)
+
+	if opts.Mask != nil && countInside(maskInside(opts.Mask, img.Bounds())) == 0 {
+		return createErrorResult(invalidParam("Mask has no white area left to process"))
+	}
 
 	// Process image
-	result, palette := convertToPaintByNumbersWithMode(img, numPoints, numColors, lineWidth, showColors, useVoronoi)
+	// Pick a seed up front so the result can be reproduced
+	if opts.Seed == 0 {
+		opts.Seed = rand.Int63n(1<<53-1) + 1 // Stay within JavaScript's safe integer range
+		fmt.Printf("Picked seed %d\n", opts.Seed)
+	}
+
+	// Asked for a region count rather than points, search for the point count giving it; the
+	// recipe then records the points found, so replaying it skips the search
+	var tuned *sheetLayout
+	var regionWarning string
+	tunePoints := func() {
+		timer.begin("Tuning points")
+		var regions int
+		numPoints, regions, tuned = tunePointsForRegions(img, opts.TargetRegions, numColors, opts)
+		regionWarning = regionTargetWarning(opts.TargetRegions, regions, numPoints)
+	}
+	if opts.TargetRegions > 0 && recipe == nil {
+		if !useVoronoi {
+			return createErrorResult(invalidParam("Target regions needs Voronoi mode"))
+		}
+		tunePoints()
+	}
+
+	// Rather than run past the time budget, make the sheet at the resolution that fits it.
+	// Recipes must replay at their recorded size, so they never shrink.
+	var budgetWarning string
+	degraded := false
+	if opts.TimeBudget > 0 && recipe == nil {
+		timer.begin("Estimating cost")
+		projected := projectCost(img, numPoints, numColors, lineWidth, showColors, useVoronoi, opts)
+		remaining := float64(opts.TimeBudget) - float64(time.Since(started).Microseconds())/1000
+		if dimension, ok := budgetDimension(img.Bounds(), projected, remaining); ok {
+			timer.begin("Preprocessing")
+			requested := img.Bounds()
+			img = preprocessImage(source, dimension, opts)
+			maxDimension, degraded = dimension, true
+			budgetWarning = degradedWarning(requested, img.Bounds(), projected, float64(opts.TimeBudget))
+
+			// The point count tuned at full size gives a different region count on the smaller sheet
+			if tuned != nil {
+				tunePoints()
+			}
+		}
+	}
+
+	if opts.TimeBudget > 0 {
+		if err := budgetExceeded(opts.Context, opts.TimeBudget); err != nil {
+			return createErrorResult(err)
+		}
+	}
+
+	if beforeLayoutHook != nil {
+		beforeLayoutHook()
+	}
+	var layout *sheetLayout
+	if recipe != nil {
+		layout, err = recipe.layout(img, opts)
+		if err != nil {
+			return createErrorResult(err)
+		}
+	} else if tuned != nil {
+		layout = tuned
+	} else {
+		layout = prepareLayout(img, numPoints, numColors, useVoronoi, opts)
+	}
+	result, palette := layout.render(lineWidth, showColors)
+	if opts.TimeBudget > 0 {
+		if err := budgetExceeded(opts.Context, opts.TimeBudget); err != nil {
+			return createErrorResult(err)
+		}
+	}
+
+	// Attach the palette key to the sheet itself when requested
+	output := appendLegendStrip(result, palette, opts.LegendPosition)
+
+	// Frame the finished sheet; processing never sees the margin
+	margin := opts.Margin.resolve(output.Bounds())
+	output = padImage(output, margin, opts.MarginColor)
 
 	// Encode to PNG
+	timer.begin("Encoding")
 	var buf bytes.Buffer
-	if err := png.Encode(&buf, result); err != nil {
-		return createErrorResult(fmt.Sprintf("Failed to encode result: %v", err))
+	if err := encodePNG(&buf, output, opts.Compression); err != nil {
+		return createErrorResult(conversionError(ErrInternal, "Failed to encode result: %v", err))
+	}
+	pngBytes := buf.Bytes()
+	if opts.ColorProfile == "srgb" {
+		if pngBytes, err = insertPNGChunks(pngBytes, srgbChunks); err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to tag color profile: %v", err))
+		}
 	}
 
 	// Build palette info
+	coverage := layout.paletteCoverage(palette)
 	paletteInfo := make([]ColorInfo, len(palette))
 	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
 		cyan, magenta, yellow, black := rgbToCMYK(c)
 		paletteInfo[i] = ColorInfo{
-			Number: i + 1,
-			Hex:    colorToHex(c),
-			C:      cyan,
-			M:      magenta,
-			Y:      yellow,
-			K:      black,
+			Number:   i + 1,
+			Hex:      colorToHex(c),
+			R:        int(r >> 8),
+			G:        int(g >> 8),
+			B:        int(b >> 8),
+			C:        cyan,
+			M:        magenta,
+			Y:        yellow,
+			K:        black,
+			Coverage: coverage[i],
 		}
 	}
 
 	// Create response
 	response := ProcessResult{
-		Image:   base64.StdEncoding.EncodeToString(buf.Bytes()),
-		Palette: paletteInfo,
+		Image:           base64.StdEncoding.EncodeToString(pngBytes),
+		Palette:         paletteInfo,
+		DistinctNumbers: len(paletteInfo),
+		Seed:            opts.Seed,
+		Width:           img.Bounds().Dx(),
+		Height:          img.Bounds().Dy(),
+		Warning:         joinWarnings(lowResolutionWarning(img.Bounds(), maxDimension), budgetWarning, regionWarning),
+		Degraded:        degraded,
+	}
+	if opts.BorderColor != nil || opts.NumberColor != nil {
+		response.Warning = joinWarnings(response.Warning, lineColorWarning(opts.BorderColor, opts.NumberColor))
+	}
+
+	// List where each color goes so guided painting apps can highlight one number at a time
+	if opts.Regions {
+		response.RegionsByColor = layout.regionsByColor(palette)
+		for _, colorRegions := range response.RegionsByColor {
+			for i := range colorRegions.Regions {
+				colorRegions.Regions[i].X += margin
+				colorRegions.Regions[i].Y += margin
+				colorRegions.Regions[i].CentroidX += margin
+				colorRegions.Regions[i].CentroidY += margin
+			}
+		}
+	}
+
+	// Region outlines for plotting and GIS tools, in the same framed coordinates
+	if opts.Polygons {
+		response.Polygons = layout.regionPolygons(palette, image.Pt(margin, margin))
+	}
+
+	// Record how this sheet was made so it can be reproduced later
+	if opts.ExportRecipe {
+		recorded, err := recordedOptions(optsValue)
+		if err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to build recipe: %v", err))
+		}
+		recipeBytes, err := buildRecipe(Recipe{
+			Points:       numPoints,
+			Colors:       numColors,
+			LineWidth:    lineWidth,
+			MaxDimension: maxDimension,
+			ShowColors:   showColors,
+			UseVoronoi:   useVoronoi,
+			Options:      recorded,
+		}, layout, opts.Seed)
+		if err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to build recipe: %v", err))
+		}
+		response.Recipe = string(recipeBytes)
+	}
+
+	// Add a small inline preview so callers don't need a second request
+	if opts.Thumbnail {
+		var thumbBuf bytes.Buffer
+		if err := encodePNG(&thumbBuf, thumbnailImage(output, thumbnailSize), opts.Compression); err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to encode thumbnail: %v", err))
+		}
+		response.Thumbnail = base64.StdEncoding.EncodeToString(thumbBuf.Bytes())
+	}
+
+	// Numbers on their own, placed where they sit on the framed sheet, so apps can toggle them
+	if opts.LabelLayer {
+		labels := layout.renderLabels(lineWidth)
+		layer := image.NewRGBA(output.Bounds())
+		origin := output.Bounds().Min.Add(image.Pt(margin, margin))
+		draw.Draw(layer, labels.Bounds().Sub(labels.Bounds().Min).Add(origin), labels, labels.Bounds().Min, draw.Src)
+
+		var layerBuf bytes.Buffer
+		if err := encodePNG(&layerBuf, layer, opts.Compression); err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to encode label layer: %v", err))
+		}
+		response.LabelLayer = base64.StdEncoding.EncodeToString(layerBuf.Bytes())
+	}
+
+	// One mask per color for screen printing, framed like the sheet and numbered like the palette
+	if opts.ColorMasks {
+		origin := output.Bounds().Min.Add(image.Pt(margin, margin))
+		for i, mask := range renderColorMasks(layout.assignment(), layout.bounds, len(layout.palette)) {
+			framed := image.NewGray(output.Bounds())
+			draw.Draw(framed, mask.Bounds().Sub(mask.Bounds().Min).Add(origin), mask, mask.Bounds().Min, draw.Src)
+
+			var maskBuf bytes.Buffer
+			if err := encodePNG(&maskBuf, framed, opts.Compression); err != nil {
+				return createErrorResult(conversionError(ErrInternal, "Failed to encode color mask: %v", err))
+			}
+			number := 0
+			for j, c := range palette {
+				if colorsEqual(c, layout.palette[i]) {
+					number = j + 1
+					break
+				}
+			}
+			response.ColorMasks = append(response.ColorMasks, ColorMask{
+				Number: number,
+				Hex:    colorToHex(layout.palette[i]),
+				Image:  base64.StdEncoding.EncodeToString(maskBuf.Bytes()),
+			})
+		}
+	}
+
+	// Bundle both sheet variants, the legend and the palette when requested
+	if opts.Format == "zip" {
+		other, _ := layout.render(lineWidth, !showColors)
+		sheet, reference := result, other
+		if showColors {
+			sheet, reference = other, result
+		}
+
+		zipBytes, err := buildResultZip(sheet, reference, renderLegend(palette), paletteInfo, opts.Compression)
+		if err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to build ZIP: %v", err))
+		}
+		response.Zip = base64.StdEncoding.EncodeToString(zipBytes)
+	}
+
+	if opts.Format == "csv" {
+		response.CSV = paletteToCSV(paletteInfo)
+	}
+	if opts.Format == "gpl" {
+		response.GPL = paletteToGPL(paletteInfo)
+	}
+	if opts.Format == "ase" {
+		response.ASE = base64.StdEncoding.EncodeToString(paletteToASE(paletteInfo))
+	}
+	if opts.Format == "svg" {
+		svg, err := layout.renderSVG(lineWidth, showColors)
+		if err != nil {
+			return createErrorResult(conversionError(ErrInternal, "Failed to encode SVG: %v", err))
+		}
+		response.SVG = base64.StdEncoding.EncodeToString(svg)
+	}
+
+	if timer != nil {
+		finished := time.Now()
+		timer.finish(finished)
+		response.Diagnostics = &Diagnostics{
+			InputWidth:   inputBounds.Dx(),
+			InputHeight:  inputBounds.Dy(),
+			OutputWidth:  output.Bounds().Dx(),
+			OutputHeight: output.Bounds().Dy(),
+			TotalMillis:  float64(finished.Sub(started).Microseconds()) / 1000,
+			Stages:       timer.timings,
+		}
 	}
 
 	// Convert to JSON
-	jsonBytes, err := json.Marshal(response)
+	var jsonBytes []byte
+	if opts.Verbose {
+		jsonBytes, err = json.Marshal(verboseProcessResult{ProcessResult: response, Palette: verbosePalette(paletteInfo)})
+	} else {
+		jsonBytes, err = json.Marshal(response)
+	}
 	if err != nil {
-		return createErrorResult(fmt.Sprintf("Failed to marshal JSON: %v", err))
+		return createErrorResult(conversionError(ErrInternal, "Failed to marshal JSON: %v", err))
 	}
 
 	fmt.Println("✓ Processing complete!")
@@ -127,8 +628,637 @@ func processImage(this js.Value, args []js.Value) interface{} {
 	return string(jsonBytes)
 }
 
-func createErrorResult(errMsg string) interface{} {
-	result := ProcessResult{Error: errMsg}
+// preprocessImage downsamples img to maxDimension and applies the cleanup passes opts asks
+// for, in the order the palette and layout expect
+func preprocessImage(img image.Image, maxDimension int, opts ProcessOptions) image.Image {
+	img = downsampleImageWithGamma(img, maxDimension, opts.GammaCorrect)
+
+	// Neutralize color casts before the palette is built
+	if opts.WhiteBalance {
+		img = autoWhiteBalance(img)
+	}
+
+	// Smooth photo noise without blurring region boundaries, before palette and edge map
+	if opts.Denoise > 0 {
+		rangeSigma := opts.DenoiseRange
+		if rangeSigma == 0 {
+			rangeSigma = defaultDenoiseRange
+		}
+		img = bilateralFilter(img, opts.Denoise, rangeSigma)
+	}
+
+	// Restore edges softened by downsampling so they survive quantization
+	if opts.Sharpen > 0 {
+		img = unsharpMask(img, opts.Sharpen, sharpenRadius)
+	}
+	return img
+}
+
+// intArg reads positional argument i as a whole number. syscall/js panics on Int() of
+// anything but a number, so undefined, null or a string is reported as name instead.
+func intArg(args []js.Value, i int, name string) (int, error) {
+	if args[i].Type() != js.TypeNumber {
+		return 0, invalidParam("%s must be a number, got %s", name, args[i].Type())
+	}
+	return args[i].Int(), nil
+}
+
+const (
+	// minSheetPoints and maxSheetPoints bound the Voronoi seed point count processImage takes
+	minSheetPoints = 50
+	maxSheetPoints = 50000
+)
+
+// validateSheetArgs checks the positional sheet settings of processImage. Zero and
+// negative counts fail here with the rest, before they can reach clustering or sampling.
+func validateSheetArgs(numPoints, numColors, lineWidth, maxDimension int) error {
+	if numPoints < minSheetPoints || numPoints > maxSheetPoints {
+		return invalidParam("Points must be between %d and %d", minSheetPoints, maxSheetPoints)
+	}
+	if numColors < 2 || numColors > 64 {
+		return invalidParam("Colors must be between 2 and 64")
+	}
+	if lineWidth < 0 || lineWidth > 5 {
+		return invalidParam("Line width must be between 0 and 5")
+	}
+	if maxDimension < 256 || maxDimension > 4096 {
+		return invalidParam("Max dimension must be between 256 and 4096")
+	}
+	return nil
+}
+
+// edgeMapImage is called from JavaScript with (imageData, maxDimension) and returns the
+// edge map that drives point placement as a grayscale PNG
+func edgeMapImage(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return createErrorResult(invalidParam("Invalid arguments: expected (imageData, maxDimension)"))
+	}
+
+	maxDimension, err := intArg(args, 1, "Max dimension")
+	if err != nil {
+		return createErrorResult(err)
+	}
+	if maxDimension < 256 || maxDimension > 4096 {
+		return createErrorResult(invalidParam("Max dimension must be between 256 and 4096"))
+	}
+
+	img, _, err := decodeImage(copyBytesFromJS(args[0]))
+	if err != nil {
+		return createErrorResult(err)
+	}
+	img = downsampleImage(img, maxDimension)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderEdgeMap(computeEdgeMap(img), img.Bounds())); err != nil {
+		return createErrorResult(conversionError(ErrInternal, "Failed to encode result: %v", err))
+	}
+
+	jsonBytes, err := json.Marshal(ProcessResult{Image: base64.StdEncoding.EncodeToString(buf.Bytes())})
+	if err != nil {
+		return createErrorResult(conversionError(ErrInternal, "Failed to marshal JSON: %v", err))
+	}
+	return string(jsonBytes)
+}
+
+// copyBytesFromJS copies a JavaScript Uint8Array into a Go byte slice
+func copyBytesFromJS(v js.Value) []byte {
+	data := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(data, v)
+	return data
+}
+
+func createErrorResult(err error) interface{} {
+	result := ProcessResult{Error: err.Error(), ErrorCode: errorCode(err)}
 	jsonBytes, _ := json.Marshal(result)
 	return string(jsonBytes)
 }
+
+// beforeLayoutHook, when set, runs in processImage just before the layout is built. Tests
+// use it to make the pipeline panic partway through (nil = none).
+var beforeLayoutHook func()
+
+// recovering wraps a function exported to JavaScript so that a panic inside it, such as an
+// index bug on a degenerate image, fails only that call with an internal error result.
+// Left alone it would end the Go program, and every later call from the page would fail.
+// The log line and error name the call by callToken, so the failure can be reproduced.
+func recovering(name string, fn func(js.Value, []js.Value) interface{}) func(js.Value, []js.Value) interface{} {
+	return func(this js.Value, args []js.Value) (result interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				token := callToken(args)
+				fmt.Printf("%s (%s) panicked: %v\n%s", name, token, r, debug.Stack())
+				result = createErrorResult(conversionError(ErrInternal, "%s (%s) failed unexpectedly: %v", name, token, r))
+			}
+		}()
+		return fn(this, args)
+	}
+}
+
+// callToken identifies a call by what reproduces it: the seed or recipe (by its CRC-32) in
+// its options object, the last argument. Without either the seed is picked inside the
+// call, which logs it.
+func callToken(args []js.Value) string {
+	if len(args) == 0 {
+		return "no options"
+	}
+	opts := args[len(args)-1]
+	if text := optionString(opts, "recipe", ""); text != "" {
+		return fmt.Sprintf("recipe %08x", crc32.ChecksumIEEE([]byte(text)))
+	}
+	if seed := int64(optionFloat(opts, "seed", 0)); seed != 0 {
+		return fmt.Sprintf("seed %d", seed)
+	}
+	return "random seed"
+}
+
+// context returns the context that bounds palette clustering, never nil
+func (o ProcessOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// numberStyle collects the options that control how region numbers are drawn
+func (o ProcessOptions) numberStyle() numberStyle {
+	return numberStyle{spacing: o.NumberSpacing, outline: o.NumberOutline, scale: o.NumberScale, grid: o.GridLabels, minGap: o.LabelGap, inset: o.LabelInset, color: o.NumberColor, label: o.RegionLabel, locked: o.Palette != nil}
+}
+
+// borderColor returns the border color to draw with: the chosen one, else faint gray for
+// styles that ask for it, else nil for black
+func (o ProcessOptions) borderColor() color.Color {
+	if o.BorderColor != nil {
+		return o.BorderColor
+	}
+	if style := sheetStyles[o.Style]; style != nil && style.faint {
+		return faintBorderColor
+	}
+	return nil
+}
+
+// parseProcessOptions reads the optional settings object, falling back to defaults for missing fields
+func parseProcessOptions(v js.Value) (ProcessOptions, error) {
+	opts := ProcessOptions{
+		GammaCorrect: optionBool(v, "gammaCorrect", false),
+		WhiteBalance: optionBool(v, "whiteBalance", false),
+		ColorBorders: optionBool(v, "colorBorders", false),
+	}
+	opts.LocalContrast = optionBool(v, "localContrast", false)
+	opts.WeightedKMeans = optionBool(v, "weightedKMeans", false)
+
+	// Pruning unused colors is the default, so the option is stored inverted
+	opts.KeepAllColors = !optionBool(v, "pruneUnusedColors", true)
+
+	opts.Temperature = int(optionFloat(v, "temperature", 0))
+	if opts.Temperature < -100 || opts.Temperature > 100 {
+		return opts, invalidParam("Temperature must be between -100 and 100")
+	}
+
+	opts.AlphaThreshold = int(optionFloat(v, "alphaThreshold", 0))
+	if opts.AlphaThreshold < 0 || opts.AlphaThreshold > 255 {
+		return opts, invalidParam("Alpha threshold must be between 0 and 255")
+	}
+
+	opts.Denoise = optionFloat(v, "denoise", 0)
+	if opts.Denoise < 0 || opts.Denoise > 3 {
+		return opts, invalidParam("Denoise strength must be between 0 and 3")
+	}
+	opts.DenoiseRange = optionFloat(v, "denoiseRange", 0)
+	if opts.DenoiseRange != 0 && (opts.DenoiseRange < 1 || opts.DenoiseRange > 100) {
+		return opts, invalidParam("Denoise range must be between 1 and 100")
+	}
+
+	opts.Despeckle = int(optionFloat(v, "despeckle", 0))
+	if opts.Despeckle < 0 || opts.Despeckle > maxDespeckle {
+		return opts, invalidParam("Despeckle must be between 0 and %d", maxDespeckle)
+	}
+
+	opts.MergeDistance = optionFloat(v, "mergeDistance", 0)
+	if opts.MergeDistance < 0 || opts.MergeDistance > 50 {
+		return opts, invalidParam("Merge distance must be between 0 and 50")
+	}
+
+	opts.Simplify = int(optionFloat(v, "simplify", 0))
+	if opts.Simplify < 0 || opts.Simplify > 100 {
+		return opts, invalidParam("Simplify must be between 0 and 100")
+	}
+
+	opts.Sharpen = optionFloat(v, "sharpen", 0)
+	if opts.Sharpen < 0 || opts.Sharpen > 5 {
+		return opts, invalidParam("Sharpen amount must be between 0 and 5")
+	}
+
+	opts.SpatialWeight = optionFloat(v, "spatialWeight", 0)
+	if opts.SpatialWeight < 0 || opts.SpatialWeight > 10 {
+		return opts, invalidParam("Spatial weight must be between 0 and 10")
+	}
+
+	opts.PaletteSamples = int(optionFloat(v, "paletteSamples", 0))
+	if opts.PaletteSamples != 0 && (opts.PaletteSamples < 100 || opts.PaletteSamples > 1000000) {
+		return opts, invalidParam("Palette samples must be 0 or between 100 and 1000000")
+	}
+
+	opts.PaletteSize = int(optionFloat(v, "paletteResolution", 0))
+	if opts.PaletteSize != 0 && (opts.PaletteSize < 32 || opts.PaletteSize > 4096) {
+		return opts, invalidParam("Palette resolution must be 0 or between 32 and 4096")
+	}
+
+	opts.PaletteShards = int(optionFloat(v, "paletteShards", 0))
+	if opts.PaletteShards != 0 && (opts.PaletteShards < 2 || opts.PaletteShards > maxPaletteShards) {
+		return opts, invalidParam("Palette shards must be 0 or between 2 and %d", maxPaletteShards)
+	}
+	opts.MiniBatch = optionBool(v, "miniBatch", false)
+
+	minArea, err := parseAreaThreshold(v, "minRegionArea")
+	if err != nil {
+		return opts, err
+	}
+	opts.MinRegionArea = minArea
+
+	labeling, ok := parseLabelAlgorithm(optionString(v, "labeling", "unionfind"))
+	if !ok {
+		return opts, invalidParam("Labeling must be one of unionfind, floodfill")
+	}
+	opts.Labeling = labeling
+
+	opts.NumberSpacing = int(optionFloat(v, "numberSpacing", 0))
+	if opts.NumberSpacing != 0 && (opts.NumberSpacing < 20 || opts.NumberSpacing > 2000) {
+		return opts, invalidParam("Number spacing must be 0 or between 20 and 2000")
+	}
+
+	opts.NumberOutline = int(optionFloat(v, "outlineWidth", 0))
+	if opts.NumberOutline < 0 || opts.NumberOutline > maxNumberOutline {
+		return opts, invalidParam("Outline width must be between 0 and 3")
+	}
+
+	opts.NumberScale = int(optionFloat(v, "numberScale", 1))
+	if opts.NumberScale < 1 || opts.NumberScale > maxNumberScale {
+		return opts, invalidParam("Number scale must be between 1 and 3")
+	}
+
+	opts.GridLabels = int(optionFloat(v, "gridLabels", 0))
+	if opts.GridLabels != 0 && (opts.GridLabels < 5 || opts.GridLabels > 500) {
+		return opts, invalidParam("Label grid pitch must be 0 or between 5 and 500")
+	}
+
+	opts.LabelGap = int(optionFloat(v, "labelMinSpacing", 0))
+	if opts.LabelGap < 0 || opts.LabelGap > 200 {
+		return opts, invalidParam("Label min spacing must be between 0 and 200")
+	}
+
+	opts.LabelInset = int(optionFloat(v, "labelInset", 0))
+	if opts.LabelInset < 0 || opts.LabelInset > maxLabelInset {
+		return opts, invalidParam("Label inset must be between 0 and %d", maxLabelInset)
+	}
+
+	regionLabel, ok := parseRegionLabel(optionString(v, "regionLabel", "number"))
+	if !ok {
+		return opts, invalidParam("Region label must be one of number, hex, name")
+	}
+	opts.RegionLabel = regionLabel
+
+	// Line colors stay nil unless given, so only explicit choices are checked for contrast
+	if s := optionString(v, "borderColor", ""); s != "" {
+		borderColor, ok := parseHexColor(s)
+		if !ok {
+			return opts, invalidParam("Border color must be a hex color like #000000")
+		}
+		opts.BorderColor = borderColor
+	}
+	if s := optionString(v, "numberColor", ""); s != "" {
+		numberColor, ok := parseHexColor(s)
+		if !ok {
+			return opts, invalidParam("Number color must be a hex color like #000000")
+		}
+		opts.NumberColor = numberColor
+	}
+
+	opts.Style = optionString(v, "style", "")
+	if _, ok := sheetStyles[opts.Style]; opts.Style != "" && !ok {
+		return opts, invalidParam("Style must be one of reference, sheet, mosaic, lineart")
+	}
+
+	opts.TraceOpacity = optionFloat(v, "traceOpacity", 0)
+	if opts.TraceOpacity < 0 || opts.TraceOpacity > 1 {
+		return opts, invalidParam("Trace opacity must be between 0 and 1")
+	}
+
+	opts.Texture = optionFloat(v, "texture", 0)
+	if opts.Texture < 0 || opts.Texture > 1 {
+		return opts, invalidParam("Texture must be between 0 and 1")
+	}
+
+	opts.LegendPosition = optionString(v, "legendPosition", "none")
+	switch opts.LegendPosition {
+	case "none", "bottom", "right":
+	default:
+		return opts, invalidParam("Legend position must be one of none, bottom, right")
+	}
+
+	opts.Seed = int64(optionFloat(v, "seed", 0))
+	if opts.Seed < 0 {
+		return opts, invalidParam("Seed must be a positive integer")
+	}
+
+	opts.TargetRegions = int(optionFloat(v, "targetRegions", 0))
+	if opts.TargetRegions != 0 && (opts.TargetRegions < 10 || opts.TargetRegions > 20000) {
+		return opts, invalidParam("Target regions must be between 10 and 20000")
+	}
+
+	cropX, cropY := int(optionFloat(v, "cropX", 0)), int(optionFloat(v, "cropY", 0))
+	cropW, cropH := int(optionFloat(v, "cropW", 0)), int(optionFloat(v, "cropH", 0))
+	if cropX != 0 || cropY != 0 || cropW != 0 || cropH != 0 {
+		if cropX < 0 || cropY < 0 || cropW <= 0 || cropH <= 0 {
+			return opts, invalidParam("Crop needs a non-negative cropX and cropY and a positive cropW and cropH")
+		}
+		opts.Crop = image.Rect(cropX, cropY, cropX+cropW, cropY+cropH)
+	}
+
+	opts.MaxAspectRatio = optionFloat(v, "maxAspectRatio", 0)
+	if opts.MaxAspectRatio != 0 && opts.MaxAspectRatio < 1 {
+		return opts, invalidParam("Max aspect ratio must be at least 1")
+	}
+
+	metric, ok := parseColorMetric(optionString(v, "colorMetric", "euclidean"))
+	if !ok {
+		return opts, invalidParam("Color metric must be one of euclidean, redmean, ciede2000, cmyk")
+	}
+	opts.ColorMetric = metric
+
+	// clusterPalette runs one clustering variant, so asking for two would silently drop one
+	if chosen := opts.clusteringChoices(); len(chosen) > 1 {
+		return opts, invalidParam("Options %s cannot be used together: each picks a different palette clustering", strings.Join(chosen, ", "))
+	}
+
+	opts.Supersample = int(optionFloat(v, "supersample", 1))
+	switch opts.Supersample {
+	case 1, 2, 4:
+	default:
+		return opts, invalidParam("Supersample must be one of 1, 2, 4")
+	}
+
+	distribution, ok := parsePointDistribution(optionString(v, "distribution", "edge"))
+	if !ok {
+		return opts, invalidParam("Distribution must be one of edge, uniform, center, custom")
+	}
+	if optionBool(v, "multiScale", false) {
+		if distribution != DistributionEdge {
+			return opts, invalidParam("Multi-scale edges only apply to the edge distribution")
+		}
+		distribution = DistributionMultiScale
+	}
+	opts.Distribution = distribution
+	opts.FeaturePoints = optionBool(v, "featurePoints", false)
+	if distribution == DistributionCustom {
+		field := v.Get("densityMap")
+		if field.Type() != js.TypeObject || !field.InstanceOf(js.Global().Get("Uint8Array")) {
+			return opts, invalidParam("Custom distribution needs a densityMap image as a Uint8Array")
+		}
+		density, _, err := decodeImage(copyBytesFromJS(field))
+		if err != nil {
+			return opts, invalidParam("Density map could not be used: %v", err)
+		}
+		opts.DensityMap = density
+	}
+
+	cellMetric, ok := parseCellMetric(optionString(v, "cellMetric", "euclidean"))
+	if !ok {
+		return opts, invalidParam("Cell metric must be one of euclidean, manhattan, chebyshev")
+	}
+	opts.CellMetric = cellMetric
+
+	pointIndex, ok := parsePointIndex(optionString(v, "pointIndex", "auto"))
+	if !ok {
+		return opts, invalidParam("Point index must be one of auto, kdtree, hash")
+	}
+	opts.PointIndex = pointIndex
+
+	connectivity, ok := parseConnectivity(optionString(v, "borderConnectivity", "disk"))
+	if !ok {
+		return opts, invalidParam("Border connectivity must be one of disk, 4, 8, forward")
+	}
+	opts.Connectivity = connectivity
+
+	opts.StippleRadius = int(optionFloat(v, "stippleRadius", 0))
+	if opts.StippleRadius < 0 || opts.StippleRadius > 50 {
+		return opts, invalidParam("Stipple radius must be between 0 and 50")
+	}
+	background, ok := parseHexColor(optionString(v, "stippleBackground", "#ffffff"))
+	if !ok {
+		return opts, invalidParam("Stipple background must be a hex color like #ffffff")
+	}
+	opts.Background = background
+
+	opts.FixedPalette = optionString(v, "fixedPalette", "")
+	if _, ok := fixedPalettes[opts.FixedPalette]; opts.FixedPalette != "" && !ok {
+		return opts, invalidParam("Fixed palette must be one of websafe, paint24")
+	}
+
+	// A palette locked by the caller, e.g. one returned for an earlier image, so a series of
+	// sheets numbers every color the same way
+	if v.Type() == js.TypeObject && v.Get("palette").Type() != js.TypeUndefined {
+		field := v.Get("palette")
+		if !js.Global().Get("Array").Call("isArray", field).Bool() || field.Length() < 2 || field.Length() > 64 {
+			return opts, invalidParam("Palette must be a list of 2 to 64 hex colors")
+		}
+		if opts.FixedPalette != "" {
+			return opts, invalidParam("Palette and fixed palette cannot be used together")
+		}
+		hexes := make([]string, field.Length())
+		for i := range hexes {
+			if item := field.Index(i); item.Type() == js.TypeString {
+				hexes[i] = item.String()
+			}
+		}
+		palette, err := parsePaletteHex(hexes, "Palette")
+		if err != nil {
+			return opts, err
+		}
+		// Regions are matched to numbers by color, so a repeated color would make two numbers one
+		for i := range palette {
+			for j := 0; j < i; j++ {
+				if colorsEqual(palette[i], palette[j]) {
+					return opts, invalidParam("Palette colors %d and %d are both %s", j+1, i+1, colorToHex(palette[i]))
+				}
+			}
+		}
+		opts.Palette = palette
+	}
+
+	margin, err := parseMargin(v, "margin")
+	if err != nil {
+		return opts, err
+	}
+	opts.Margin = margin
+	marginColor, ok := parseHexColor(optionString(v, "marginColor", "#ffffff"))
+	if !ok {
+		return opts, invalidParam("Margin color must be a hex color like #ffffff")
+	}
+	opts.MarginColor = marginColor
+
+	if v.Type() == js.TypeObject && v.Get("mask").Type() != js.TypeUndefined {
+		field := v.Get("mask")
+		if field.Type() != js.TypeObject || !field.InstanceOf(js.Global().Get("Uint8Array")) {
+			return opts, invalidParam("Mask must be an image as a Uint8Array")
+		}
+		mask, _, err := decodeImage(copyBytesFromJS(field))
+		if err != nil {
+			return opts, invalidParam("Mask could not be used: %v", err)
+		}
+		opts.Mask = mask
+	}
+	maskBackground, ok := parseHexColor(optionString(v, "maskBackground", "#ffffff"))
+	if !ok {
+		return opts, invalidParam("Mask background must be a hex color like #ffffff")
+	}
+	opts.MaskBackground = maskBackground
+
+	opts.Thumbnail = optionBool(v, "thumbnails", false)
+	opts.LabelLayer = optionBool(v, "labelLayer", false)
+	opts.ColorMasks = optionBool(v, "colorMasks", false)
+	opts.Regions = optionBool(v, "regionsByColor", false)
+	opts.Polygons = optionBool(v, "polygons", false)
+	opts.Verbose = optionBool(v, "verbose", false)
+	opts.Diagnostics = optionBool(v, "diagnostics", false)
+	opts.Deterministic = optionBool(v, "deterministic", false)
+
+	stage, ok := parsePipelineStage(optionString(v, "stage", "final"))
+	if !ok {
+		return opts, invalidParam("Stage must be one of raw, quantized, borders, final")
+	}
+	opts.Stage = stage
+
+	opts.TimeBudget = int(optionFloat(v, "timeBudget", 0))
+	if opts.TimeBudget < 0 || opts.TimeBudget > 600000 {
+		return opts, invalidParam("Time budget must be between 0 and 600000 milliseconds")
+	}
+
+	opts.Format = optionString(v, "format", "png")
+	switch opts.Format {
+	case "png", "zip", "csv", "gpl", "ase", "svg":
+	default:
+		return opts, invalidParam("Format must be one of png, zip, csv, gpl, ase, svg")
+	}
+
+	opts.ColorProfile = optionString(v, "colorProfile", "none")
+	switch opts.ColorProfile {
+	case "none", "srgb":
+	default:
+		return opts, invalidParam("Color profile must be one of none, srgb")
+	}
+
+	opts.Compression = optionString(v, "compression", "default")
+	switch opts.Compression {
+	case "default", "none", "fast", "best":
+	default:
+		return opts, invalidParam("Compression must be one of default, none, fast, best")
+	}
+
+	return opts, nil
+}
+
+// parseAreaThreshold reads a minimum region area field given as a pixel count (e.g. 100)
+// or a percentage of the image (e.g. "0.05%")
+func parseAreaThreshold(v js.Value, name string) (AreaThreshold, error) {
+	pixels, percent, ok, err := optionPixelsOrPercent(v, name)
+	if err != nil {
+		return AreaThreshold{}, invalidParam("Min region area must be a pixel count or a percentage like \"0.05%%\"")
+	}
+	if !ok {
+		return AreaThreshold{}, nil
+	}
+
+	if percent != 0 {
+		if percent <= 0 || percent > 50 {
+			return AreaThreshold{}, invalidParam("Min region area percentage must be between 0 and 50%%")
+		}
+		return AreaThreshold{Percent: percent}, nil
+	}
+	if pixels < 1 || pixels > 1000000 {
+		return AreaThreshold{}, invalidParam("Min region area must be between 1 and 1000000 pixels")
+	}
+	return AreaThreshold{Pixels: pixels}, nil
+}
+
+// parseMargin reads a margin field given in pixels (e.g. 40) or as a percentage of the
+// sheet's shorter side (e.g. "5%")
+func parseMargin(v js.Value, name string) (Margin, error) {
+	pixels, percent, ok, err := optionPixelsOrPercent(v, name)
+	if err != nil {
+		return Margin{}, invalidParam("Margin must be a pixel count or a percentage like \"5%%\"")
+	}
+	if !ok {
+		return Margin{}, nil
+	}
+
+	if percent != 0 {
+		if percent < 0 || percent > 25 {
+			return Margin{}, invalidParam("Margin percentage must be between 0 and 25%%")
+		}
+		return Margin{Percent: percent}, nil
+	}
+	if pixels < 0 || pixels > 1000 {
+		return Margin{}, invalidParam("Margin must be between 0 and 1000 pixels")
+	}
+	return Margin{Pixels: pixels}, nil
+}
+
+// optionPixelsOrPercent reads a field given either as a number of pixels or as a string
+// ending in "%". ok is false when the field is missing; err is set when a string does
+// not parse.
+func optionPixelsOrPercent(v js.Value, name string) (pixels int, percent float64, ok bool, err error) {
+	if v.Type() != js.TypeObject {
+		return 0, 0, false, nil
+	}
+
+	field := v.Get(name)
+	switch field.Type() {
+	case js.TypeNumber:
+		return field.Int(), 0, true, nil
+	case js.TypeString:
+		s := strings.TrimSpace(field.String())
+		if p, isPercent := strings.CutSuffix(s, "%"); isPercent {
+			percent, err = strconv.ParseFloat(strings.TrimSpace(p), 64)
+			return 0, percent, true, err
+		}
+		pixels, err = strconv.Atoi(s)
+		return pixels, 0, true, err
+	}
+	return 0, 0, false, nil
+}
+
+// optionBool reads a boolean field from an options object
+func optionBool(v js.Value, name string, fallback bool) bool {
+	if v.Type() != js.TypeObject {
+		return fallback
+	}
+	field := v.Get(name)
+	if field.Type() != js.TypeBoolean {
+		return fallback
+	}
+	return field.Bool()
+}
+
+// optionString reads a string field from an options object
+func optionString(v js.Value, name string, fallback string) string {
+	if v.Type() != js.TypeObject {
+		return fallback
+	}
+	field := v.Get(name)
+	if field.Type() != js.TypeString {
+		return fallback
+	}
+	return field.String()
+}
+
+// optionFloat reads a numeric field from an options object
+func optionFloat(v js.Value, name string, fallback float64) float64 {
+	if v.Type() != js.TypeObject {
+		return fallback
+	}
+	field := v.Get(name)
+	if field.Type() != js.TypeNumber {
+		return fallback
+	}
+	return field.Float()
+}
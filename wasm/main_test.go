@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"syscall/js"
+	"testing"
+)
+
+// sheetArgs are the positional arguments of processImage after the image
+type sheetArgs struct {
+	points, colors, lineWidth, maxDimension int
+	showColors, useVoronoi                  bool
+}
+
+// testSheetArgs is a small, quick Voronoi sheet
+var testSheetArgs = sheetArgs{points: 200, colors: 6, lineWidth: 1, maxDimension: 256, useVoronoi: true}
+
+// jsBytes copies data into a new JavaScript Uint8Array
+func jsBytes(data []byte) js.Value {
+	array := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(array, data)
+	return array
+}
+
+// encodeTestPNG encodes img as PNG bytes
+func encodeTestPNG(t testing.TB, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// callProcessImage runs processImage on img as JavaScript would, with opts as the settings
+// object (nil = none), and decodes the JSON it returns
+func callProcessImage(t testing.TB, img image.Image, args sheetArgs, opts map[string]interface{}) ProcessResult {
+	t.Helper()
+	return callProcessImageBytes(t, encodeTestPNG(t, img), args, opts)
+}
+
+// callProcessImageBytes is callProcessImage for an already encoded file
+func callProcessImageBytes(t testing.TB, data []byte, args sheetArgs, opts map[string]interface{}) ProcessResult {
+	t.Helper()
+	return callProcessImageArray(t, jsBytes(data), args, opts)
+}
+
+// callProcessImageArray is callProcessImage for file bytes already in a JavaScript Uint8Array
+func callProcessImageArray(t testing.TB, array js.Value, args sheetArgs, opts map[string]interface{}) ProcessResult {
+	t.Helper()
+	jsArgs := []js.Value{
+		array,
+		js.ValueOf(args.points),
+		js.ValueOf(args.colors),
+		js.ValueOf(args.lineWidth),
+		js.ValueOf(args.maxDimension),
+		js.ValueOf(args.showColors),
+		js.ValueOf(args.useVoronoi),
+	}
+	if opts != nil {
+		jsArgs = append(jsArgs, js.ValueOf(opts))
+	}
+
+	var result ProcessResult
+	if err := json.Unmarshal([]byte(processImage(js.Undefined(), jsArgs).(string)), &result); err != nil {
+		t.Fatalf("processImage returned invalid JSON: %v", err)
+	}
+	return result
+}
+
+// mustProcessImage is callProcessImage for calls expected to succeed
+func mustProcessImage(t testing.TB, img image.Image, args sheetArgs, opts map[string]interface{}) ProcessResult {
+	t.Helper()
+	result := callProcessImage(t, img, args, opts)
+	if result.Error != "" {
+		t.Fatalf("processImage failed: %s (%s)", result.Error, result.ErrorCode)
+	}
+	return result
+}
+
+// decodeBase64 decodes a base64 result field
+func decodeBase64(t testing.TB, field string) []byte {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		t.Fatalf("result field is not base64: %v", err)
+	}
+	return data
+}
+
+// decodeBase64PNG decodes a base64 PNG result field
+func decodeBase64PNG(t testing.TB, field string) image.Image {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(decodeBase64(t, field)))
+	if err != nil {
+		t.Fatalf("result field is not a PNG: %v", err)
+	}
+	return img
+}
+
+// countPixels returns how many pixels of img satisfy match
+func countPixels(img image.Image, match func(c color.RGBA) bool) int {
+	n := 0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if match(color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func isDark(c color.RGBA) bool { return c.R < 64 && c.G < 64 && c.B < 64 }
+
+// isTinted reports whether c is clearly neither white, black nor gray
+func isTinted(c color.RGBA) bool {
+	hi := max(float64(c.R), max(float64(c.G), float64(c.B)))
+	lo := min(float64(c.R), min(float64(c.G), float64(c.B)))
+	return hi-lo > 40
+}
+
+func TestSheetArgumentsChangeOutput(t *testing.T) {
+	img := syntheticImage(192)
+	opts := map[string]interface{}{"seed": 7}
+	sheet := func(args sheetArgs) image.Image {
+		return decodeBase64PNG(t, mustProcessImage(t, img, args, opts).Image)
+	}
+	base := sheet(testSheetArgs)
+	total := base.Bounds().Dx() * base.Bounds().Dy()
+
+	t.Run("lineWidth", func(t *testing.T) {
+		args := testSheetArgs
+		args.lineWidth = 3
+		thin, thick := countPixels(base, isDark), countPixels(sheet(args), isDark)
+		if thick <= thin {
+			t.Errorf("dark pixels: %d at line width 1, %d at 3, want more at 3", thin, thick)
+		}
+	})
+
+	t.Run("showColors", func(t *testing.T) {
+		args := testSheetArgs
+		args.showColors = true
+		outline, filled := countPixels(base, isTinted), countPixels(sheet(args), isTinted)
+		if outline > total/100 || filled < total/2 {
+			t.Errorf("tinted pixels: %d of %d in outline, %d filled", outline, total, filled)
+		}
+	})
+
+	t.Run("useVoronoi", func(t *testing.T) {
+		args := testSheetArgs
+		args.useVoronoi = false
+		grid := sheet(args)
+		if grid.Bounds() != base.Bounds() {
+			t.Fatalf("grid sheet is %v, Voronoi %v", grid.Bounds(), base.Bounds())
+		}
+		differ := 0
+		bounds := grid.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if grid.At(x, y) != base.At(x, y) {
+					differ++
+				}
+			}
+		}
+		if differ < total/50 {
+			t.Errorf("grid and Voronoi sheets differ in %d of %d pixels", differ, total)
+		}
+	})
+}
+
+func TestThumbnailsReturnsSmallerPreview(t *testing.T) {
+	args := testSheetArgs
+	args.maxDimension = 512
+	result := mustProcessImage(t, syntheticImage(512), args, map[string]interface{}{"thumbnails": true})
+	if result.Image == "" || result.Thumbnail == "" {
+		t.Fatalf("image present %v, thumbnail present %v, want both", result.Image != "", result.Thumbnail != "")
+	}
+
+	full := decodeBase64PNG(t, result.Image).Bounds()
+	thumb := decodeBase64PNG(t, result.Thumbnail).Bounds()
+	if thumb.Dx() >= full.Dx() || thumb.Dy() >= full.Dy() {
+		t.Errorf("thumbnail is %dx%d, not smaller than the %dx%d image", thumb.Dx(), thumb.Dy(), full.Dx(), full.Dy())
+	}
+
+	if result := mustProcessImage(t, syntheticImage(128), testSheetArgs, nil); result.Thumbnail != "" {
+		t.Error("thumbnail returned without thumbnails=true")
+	}
+}
+
+func TestChunkedUploadDecodesOnceComplete(t *testing.T) {
+	data := encodeTestPNG(t, syntheticImage(64))
+
+	// The page's buffer fills a chunk at a time as a slow upload arrives. Converting it early
+	// sees a file cut off part way, which is a decode error rather than a crash or a partial
+	// image; once the last chunk is in, the same buffer converts.
+	array := js.Global().Get("Uint8Array").New(len(data))
+	chunk := len(data)/6 + 1
+	for off := 0; off < len(data); off += chunk {
+		end := min(off+chunk, len(data))
+		array.Call("set", jsBytes(data[off:end]), off)
+
+		result := callProcessImageArray(t, array, testSheetArgs, nil)
+		if end < len(data) && (result.ErrorCode != "decode" || result.Image != "") {
+			t.Errorf("upload at %d of %d bytes: errorCode %q (%s), want decode and no image", end, len(data), result.ErrorCode, result.Error)
+		}
+		if end == len(data) && result.Error != "" {
+			t.Errorf("complete upload failed: %s (%s)", result.Error, result.ErrorCode)
+		}
+	}
+}
+
+func TestBorderAndNumberColors(t *testing.T) {
+	border, number := color.RGBA{0, 0, 200, 255}, color.RGBA{200, 200, 0, 255}
+	result := mustProcessImage(t, syntheticImage(192), testSheetArgs, map[string]interface{}{"borderColor": "#0000c8", "numberColor": "#c8c800"})
+	if result.Warning != "" {
+		t.Errorf("contrasting colors warned: %q", result.Warning)
+	}
+	sheet := decodeBase64PNG(t, result.Image)
+	for _, want := range []color.RGBA{border, number} {
+		if countPixels(sheet, func(c color.RGBA) bool { return c == want }) == 0 {
+			t.Errorf("sheet has no %v pixels", want)
+		}
+	}
+	if n := countPixels(sheet, isDark); n != 0 {
+		t.Errorf("sheet still has %d black pixels", n)
+	}
+
+	result = mustProcessImage(t, syntheticImage(192), testSheetArgs, map[string]interface{}{"borderColor": "#202020", "numberColor": "#000000"})
+	if !strings.Contains(result.Warning, "contrast") {
+		t.Errorf("near-black border and black numbers: warning %q, want a contrast warning", result.Warning)
+	}
+}
+
+func TestLabelLayerMatchesSheetNumbers(t *testing.T) {
+	result := mustProcessImage(t, syntheticImage(192), testSheetArgs, map[string]interface{}{"labelLayer": true, "seed": 3})
+	sheet := decodeBase64PNG(t, result.Image)
+	layer := decodeBase64PNG(t, result.LabelLayer)
+	if layer.Bounds() != sheet.Bounds() {
+		t.Fatalf("label layer is %v, the sheet %v", layer.Bounds(), sheet.Bounds())
+	}
+
+	clear, glyph := 0, 0
+	b := layer.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			l := color.NRGBAModel.Convert(layer.At(x, y)).(color.NRGBA)
+			switch l.A {
+			case 0:
+				clear++
+			case 255:
+				glyph++
+				if s := color.NRGBAModel.Convert(sheet.At(x, y)).(color.NRGBA); s != l {
+					t.Fatalf("label pixel %d,%d = %v, the sheet has %v", x, y, l, s)
+				}
+			default:
+				t.Fatalf("label pixel %d,%d has partial alpha %d", x, y, l.A)
+			}
+		}
+	}
+	if glyph == 0 || clear < b.Dx()*b.Dy()*9/10 {
+		t.Errorf("label layer has %d glyph and %d transparent pixels of %d", glyph, clear, b.Dx()*b.Dy())
+	}
+}
+
+func TestCropProcessesOnlyTheRectangle(t *testing.T) {
+	// Red on the left, blue on the right
+	img := image.NewRGBA(image.Rect(0, 0, 240, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 240; x++ {
+			if x < 120 {
+				img.Set(x, y, color.RGBA{220, 40, 40, 255})
+			} else {
+				img.Set(x, y, color.RGBA{40, 70, 200, 255})
+			}
+		}
+	}
+
+	result := mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"cropX": 140, "cropY": 10, "cropW": 80, "cropH": 100})
+	if result.Width != 80 || result.Height != 100 {
+		t.Errorf("cropped output is %dx%d, want 80x100", result.Width, result.Height)
+	}
+	if len(result.Palette) != 1 || result.Palette[0].Hex != "#2846c8" {
+		t.Errorf("cropped palette = %+v, want only the blue", result.Palette)
+	}
+
+	result = callProcessImage(t, img, testSheetArgs, map[string]interface{}{"cropX": 200, "cropY": 0, "cropW": 80, "cropH": 100})
+	if result.ErrorCode != "invalid_param" {
+		t.Errorf("crop past the right edge: errorCode %q (%s), want invalid_param", result.ErrorCode, result.Error)
+	}
+}
+
+func TestStylesChooseFillsNumbersAndBorders(t *testing.T) {
+	isFaint := func(c color.RGBA) bool { return c == faintBorderColor }
+	for _, tc := range []struct {
+		style                string
+		fill, numbers, faint bool
+	}{
+		{"reference", true, true, true},
+		{"sheet", false, true, false},
+		{"mosaic", true, false, false},
+		{"lineart", false, false, false},
+	} {
+		result := mustProcessImage(t, syntheticImage(192), testSheetArgs, map[string]interface{}{"style": tc.style, "labelLayer": true, "seed": 2})
+		sheet := decodeBase64PNG(t, result.Image)
+		total := sheet.Bounds().Dx() * sheet.Bounds().Dy()
+
+		if filled := countPixels(sheet, isTinted) > total/2; filled != tc.fill {
+			t.Errorf("%s: filled = %v, want %v", tc.style, filled, tc.fill)
+		}
+		layer := decodeBase64PNG(t, result.LabelLayer)
+		if numbered := countPixels(layer, func(c color.RGBA) bool { return c.A != 0 }) > 0; numbered != tc.numbers {
+			t.Errorf("%s: numbered = %v, want %v", tc.style, numbered, tc.numbers)
+		}
+
+		// Every style has borders, black or, for the reference, faint gray
+		faint := countPixels(sheet, isFaint)
+		if (faint > total/50) != tc.faint {
+			t.Errorf("%s: %d faint border pixels of %d", tc.style, faint, total)
+		}
+		if borders := faint + countPixels(sheet, isDark); borders < total/20 {
+			t.Errorf("%s: only %d border pixels of %d", tc.style, borders, total)
+		}
+	}
+}
+
+func TestOptionsDecodedFromJSONRunThePipeline(t *testing.T) {
+	// A programmatic caller builds the options object straight from a JSON body
+	decode := func(body string) map[string]interface{} {
+		var opts map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &opts); err != nil {
+			t.Fatal(err)
+		}
+		return opts
+	}
+
+	result := mustProcessImage(t, syntheticImage(64), testSheetArgs, decode(`{"despeckle": 2, "whiteBalance": true, "numberColor": "#333333"}`))
+	if decodeBase64PNG(t, result.Image).Bounds().Empty() || len(result.Palette) == 0 {
+		t.Errorf("JSON options gave an empty result: %d palette colors", len(result.Palette))
+	}
+
+	// Out of range values are rejected the same way whatever built the object
+	if result := callProcessImage(t, syntheticImage(64), testSheetArgs, decode(`{"temperature": 500}`)); result.ErrorCode != "invalid_param" {
+		t.Errorf("temperature 500: errorCode %q (%s), want invalid_param", result.ErrorCode, result.Error)
+	}
+}
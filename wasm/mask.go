@@ -0,0 +1,127 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// maskedOut is the palette index a layout's assignment gives pixels outside its mask. They
+// belong to no region, take no number and are painted the mask background.
+const maskedOut = -1
+
+// maskInside stretches mask to bounds and reports, row-major, which pixels it selects for
+// processing: those at least half white. A nil mask gives nil, which selects every pixel.
+func maskInside(mask image.Image, bounds image.Rectangle) []bool {
+	if mask == nil {
+		return nil
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	if mb := mask.Bounds(); mb.Dx() != width || mb.Dy() != height {
+		mask = resizeBilinear(mask, width, height)
+	}
+
+	mb := mask.Bounds()
+	inside := make([]bool, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			inside[y*width+x] = luminance(mask.At(x+mb.Min.X, y+mb.Min.Y)) >= 0.5
+		}
+	}
+	return inside
+}
+
+// cropMask cuts rect, in the coordinates of an image with bounds source, out of mask
+// stretched to that image, so a mask drawn over the whole upload still lines up after a crop
+func cropMask(mask image.Image, source, rect image.Rectangle) image.Image {
+	stretched := resizeBilinear(mask, source.Dx(), source.Dy())
+	return cropImage(stretched, rect.Sub(source.Min).Add(stretched.Bounds().Min))
+}
+
+// countInside returns how many pixels of a maskInside result are selected
+func countInside(inside []bool) int {
+	n := 0
+	for _, in := range inside {
+		if in {
+			n++
+		}
+	}
+	return n
+}
+
+// maskedSamples packs the pixels of img that inside selects into a rectangle as wide as
+// img, keeping their order, so palette clustering only ever sees the subject. The last row
+// is topped up with selected pixels from the start. A nil inside returns img itself.
+func maskedSamples(img image.Image, inside []bool) image.Image {
+	if inside == nil {
+		return img
+	}
+	n := countInside(inside)
+	if n == 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	packed := image.NewRGBA(image.Rect(0, 0, width, (n+width-1)/width))
+	next := 0
+	for i, in := range inside {
+		if in {
+			packed.Set(next%width, next/width, img.At(i%width+bounds.Min.X, i/width+bounds.Min.Y))
+			next++
+		}
+	}
+	for i := 0; next < len(packed.Pix)/4; i++ {
+		packed.Set(next%width, next/width, packed.At(i%width, i/width))
+		next++
+	}
+	return packed
+}
+
+// clipAssignment marks every pixel of assignment that inside does not select as maskedOut
+func clipAssignment(assignment []int, inside []bool) {
+	if inside == nil {
+		return
+	}
+	for i, in := range inside {
+		if !in {
+			assignment[i] = maskedOut
+		}
+	}
+}
+
+// applyMask paints the pixels outside the layout's mask its background, covering any cell
+// detail or borders drawn there, and outlines the edge of the mask inside it with borders
+// lineWidth thick. Layouts without a mask are left alone.
+func (l *sheetLayout) applyMask(img *image.RGBA, lineWidth int) {
+	if l.mask == nil {
+		return
+	}
+	bounds := l.bounds
+	width := bounds.Dx()
+
+	edges := make([]int, len(l.mask))
+	for i, in := range l.mask {
+		if !in {
+			edges[i] = 1
+		}
+	}
+	border := l.borderColor
+	if border == nil {
+		border = color.RGBA{0, 0, 0, 255}
+	}
+	background := l.maskBackground
+	if background == nil {
+		background = color.White
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := (y-bounds.Min.Y)*width + (x - bounds.Min.X)
+			if !l.mask[i] {
+				img.Set(x, y, background)
+			} else if lineWidth > 0 && isGridBorder(x, y, bounds, edges, lineWidth, l.connectivity) {
+				img.Set(x, y, border)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCircularMaskConfinesRegionsAndNumbers(t *testing.T) {
+	const size, radius = 192, 60
+	// inCircle reports whether (x, y) lies within r of the center
+	inCircle := func(x, y, r int) bool {
+		dx, dy := x-size/2, y-size/2
+		return dx*dx+dy*dy <= r*r
+	}
+	mask := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if inCircle(x, y, radius) {
+				mask.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	args := testSheetArgs
+	args.showColors = true
+	result := mustProcessImage(t, syntheticImage(size), args, map[string]interface{}{
+		"mask": jsBytes(encodeTestPNG(t, mask)), "maskBackground": "#ff00ff", "regionsByColor": true,
+	})
+
+	background := color.RGBA{255, 0, 255, 255}
+	sheet := decodeBase64PNG(t, result.Image)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			got := color.RGBAModel.Convert(sheet.At(x, y)).(color.RGBA)
+			if !inCircle(x, y, radius+2) && got != background {
+				t.Fatalf("pixel (%d, %d) outside the mask is %v, want the plain background", x, y, got)
+			}
+			if inCircle(x, y, radius-2) && got == background {
+				t.Fatalf("pixel (%d, %d) inside the mask is the background", x, y)
+			}
+		}
+	}
+
+	regions := 0
+	for _, c := range result.RegionsByColor {
+		for _, r := range c.Regions {
+			regions++
+			if !inCircle(r.CentroidX, r.CentroidY, radius) {
+				t.Errorf("region of color %d centered at (%d, %d), outside the mask", c.Number, r.CentroidX, r.CentroidY)
+			}
+		}
+	}
+	if regions < 2 {
+		t.Errorf("%d regions inside the mask, want several", regions)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+const (
+	// miniBatchSize is how many samples each mini-batch k-means step looks at
+	miniBatchSize = 256
+	// miniBatchIterations is how many batches mini-batch k-means runs
+	miniBatchIterations = 60
+)
+
+// generateMiniBatchPalette samples img like generatePaletteWithMetric and clusters the
+// samples with miniBatchKMeans
+func generateMiniBatchPalette(ctx context.Context, img image.Image, numColors int, metric ColorMetric, step, maxSamples int, rng *rand.Rand) []color.Color {
+	var colors []color.Color
+	for _, p := range paletteSamplePoints(img.Bounds(), step, maxSamples, rng) {
+		colors = append(colors, img.At(p.X, p.Y))
+	}
+	return miniBatchKMeans(ctx, colors, numColors, metric, rng)
+}
+
+// miniBatchKMeans clusters colors into k centroids from small random batches instead of
+// reassigning every sample each iteration (Sculley's web-scale k-means). Each centroid
+// moves toward the batch samples assigned to it with a learning rate of one over the
+// number of samples it has absorbed so far, so it settles as evidence accumulates. The
+// cost is fixed by miniBatchSize and miniBatchIterations however many samples there are.
+// Like kMeansClusteringWithMetric it stops early once ctx is done.
+func miniBatchKMeans(ctx context.Context, colors []color.Color, k int, metric ColorMetric, rng *rand.Rand) []color.Color {
+	if len(colors) <= miniBatchSize {
+		return kMeansClusteringWithMetric(ctx, colors, k, metric, rng)
+	}
+	if distinct := distinctColors(colors, k); distinct != nil {
+		return distinct
+	}
+
+	// Seed with k-means++ on one batch, which already spreads the centroids well
+	seedBatch := make([]color.Color, miniBatchSize)
+	for i := range seedBatch {
+		seedBatch[i] = colors[rng.Intn(len(colors))]
+	}
+	if distinct := distinctColors(seedBatch, k); distinct != nil {
+		return kMeansClusteringWithMetric(ctx, colors, k, metric, rng)
+	}
+	seeds := kMeansPlusPlus(ctx, seedBatch, k, metric, rng)
+
+	centroids := make([][3]float64, k)
+	for i, c := range seeds {
+		r, g, b, _ := c.RGBA()
+		centroids[i] = [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+	}
+	counts := make([]int, k)
+	current := make([]color.Color, k)
+	batch := make([]color.Color, miniBatchSize)
+	nearest := make([]int, miniBatchSize)
+
+	for iter := 0; iter < miniBatchIterations; iter++ {
+		if clusteringDone(ctx) {
+			break
+		}
+
+		for i := range current {
+			current[i] = color.RGBA{R: clampChannel(centroids[i][0]), G: clampChannel(centroids[i][1]), B: clampChannel(centroids[i][2]), A: 255}
+		}
+
+		// Assign the whole batch against the same centroids before moving any
+		for i := range batch {
+			batch[i] = colors[rng.Intn(len(colors))]
+			nearest[i] = findNearestColorWithMetric(batch[i], current, metric)
+		}
+
+		for i, c := range batch {
+			j := nearest[i]
+			counts[j]++
+			rate := 1 / float64(counts[j])
+			r, g, b, _ := c.RGBA()
+			centroids[j][0] += rate * (float64(r>>8) - centroids[j][0])
+			centroids[j][1] += rate * (float64(g>>8) - centroids[j][1])
+			centroids[j][2] += rate * (float64(b>>8) - centroids[j][2])
+		}
+	}
+
+	palette := make([]color.Color, k)
+	for i, c := range centroids {
+		palette[i] = color.RGBA{R: clampChannel(c[0]), G: clampChannel(c[1]), B: clampChannel(c[2]), A: 255}
+	}
+	return palette
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMiniBatchKMeansIsCloseToFullBatch(t *testing.T) {
+	samples := patchSamples(rand.New(rand.NewSource(1)), 40000)
+	full := kMeansClusteringWithMetric(context.Background(), samples, 6, MetricEuclidean, rand.New(rand.NewSource(1)))
+	mini := miniBatchKMeans(context.Background(), samples, 6, MetricEuclidean, rand.New(rand.NewSource(1)))
+	if len(mini) != len(full) {
+		t.Fatalf("mini-batch gave %d colors, full batch %d", len(mini), len(full))
+	}
+	for _, c := range full {
+		nearest := math.Inf(1)
+		for _, m := range mini {
+			nearest = math.Min(nearest, colorDistance(c, m)/257)
+		}
+		if nearest > 6 {
+			t.Errorf("full-batch color %v is %.1f from the nearest mini-batch color", c, nearest)
+		}
+	}
+}
+
+func BenchmarkMiniBatchKMeans(b *testing.B) {
+	samples := patchSamples(rand.New(rand.NewSource(1)), 100000)
+	for _, bc := range []struct {
+		name string
+		run  func(rng *rand.Rand)
+	}{
+		{"full", func(rng *rand.Rand) {
+			kMeansClusteringWithMetric(context.Background(), samples, 12, MetricEuclidean, rng)
+		}},
+		{"mini", func(rng *rand.Rand) { miniBatchKMeans(context.Background(), samples, 12, MetricEuclidean, rng) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bc.run(rand.New(rand.NewSource(1)))
+			}
+		})
+	}
+}
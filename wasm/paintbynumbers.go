@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"image/draw"
 	"math"
 	"math/rand"
 	"sort"
+	"time"
 )
 
 // convertToPaintByNumbers converts an image to paint-by-numbers style using Voronoi diagrams
@@ -29,10 +31,11 @@ func convertToPaintByNumbersWithProgress(img image.Image, numPoints, numColors i
 	}
 
 	// Step 1: Quantize colors - reduce to a palette (do this first to avoid redundant work)
-	palette := generatePalette(img, numColors)
+	rng := newRequestRand(0)
+	palette := generatePaletteWithMetric(context.Background(), img, numColors, MetricEuclidean, paletteSampleStep, 0, rng)
 
 	// Step 2: Generate Voronoi points with adaptive distribution
-	points := generateAdaptiveVoronoiPoints(img, numPoints, progress)
+	points := generateAdaptiveVoronoiPoints(img, numPoints, progress, rng)
 
 	if progress != nil {
 		progress("Quantizing points", 20)
@@ -42,21 +45,13 @@ func convertToPaintByNumbersWithProgress(img image.Image, numPoints, numColors i
 	quantizedPoints := quantizePoints(points, palette)
 
 	// Step 4: Create Voronoi diagram with quantized colors
-	voronoi, kdtree := createVoronoiDiagramWithProgress(bounds, quantizedPoints, progress)
-
-	if progress != nil {
-		progress("Drawing borders", 70)
-	}
+	voronoi, kdtree := createVoronoiDiagramWithProgress(bounds, quantizedPoints, FillConfig{}, progress)
 
 	// Step 5: Add borders between regions
-	result := addVoronoiBorders(voronoi, quantizedPoints)
-
-	if progress != nil {
-		progress("Adding numbers", 85)
-	}
+	result := addVoronoiBordersWithProgress(voronoi, quantizedPoints, progress)
 
 	// Step 6: Add color numbers to regions
-	result = addRegionNumbers(result, quantizedPoints, kdtree)
+	result, palette = addRegionNumbers(result, quantizedPoints, kdtree, palette, defaultMinRegionArea, LabelUnionFind, false, numberStyle{}, progress)
 
 	if progress != nil {
 		progress("Complete", 100)
@@ -65,48 +60,207 @@ func convertToPaintByNumbersWithProgress(img image.Image, numPoints, numColors i
 	return result, palette
 }
 
-// generatePalette generates a color palette from the image using k-means clustering
-func generatePalette(img image.Image, numColors int) []color.Color {
-	bounds := img.Bounds()
+// generatePalette generates a color palette from the image using k-means clustering. The
+// sampling and k-means++ seeding draw only from rng, so a rand seeded the same way gives
+// the same palette.
+func generatePalette(img image.Image, numColors int, rng *rand.Rand) []color.Color {
+	return generatePaletteWithMetric(context.Background(), img, numColors, MetricEuclidean, paletteSampleStep, 0, rng)
+}
+
+// defaultMaxPaletteSamples caps how many pixels palette clustering looks at, so its cost
+// stays bounded however large the image is
+const defaultMaxPaletteSamples = 10000
+
+// paletteSampleStep is the spacing in pixels of the sample grid on a full-resolution image
+const paletteSampleStep = 10
 
+// paletteSamplePoints returns the pixels palette clustering samples: every step-th pixel in
+// each direction, randomly thinned to at most maxSamples (0 = defaultMaxPaletteSamples).
+// The kept pixels stay in raster order.
+func paletteSamplePoints(bounds image.Rectangle, step, maxSamples int, rng *rand.Rand) []image.Point {
+	if maxSamples <= 0 {
+		maxSamples = defaultMaxPaletteSamples
+	}
+
+	var points []image.Point
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			points = append(points, image.Point{X: x, Y: y})
+		}
+	}
+	if len(points) <= maxSamples {
+		return points
+	}
+
+	// Partial Fisher-Yates over the indices, then restore raster order
+	indices := rng.Perm(len(points))[:maxSamples]
+	sort.Ints(indices)
+	kept := make([]image.Point, maxSamples)
+	for i, idx := range indices {
+		kept[i] = points[idx]
+	}
+	return kept
+}
+
+// generatePaletteWithMetric generates a palette clustering colors with the given metric,
+// from at most maxSamples pixels on a grid with the given step. All randomness comes from
+// rng, so the same image, color count and seed give the same palette.
+func generatePaletteWithMetric(ctx context.Context, img image.Image, numColors int, metric ColorMetric, step, maxSamples int, rng *rand.Rand) []color.Color {
 	// Sample colors from the image
 	var colors []color.Color
-	sampleStep := 10
-	for y := bounds.Min.Y; y < bounds.Max.Y; y += sampleStep {
-		for x := bounds.Min.X; x < bounds.Max.X; x += sampleStep {
-			colors = append(colors, img.At(x, y))
-		}
+	for _, p := range paletteSamplePoints(img.Bounds(), step, maxSamples, rng) {
+		colors = append(colors, img.At(p.X, p.Y))
 	}
 
 	// Simple k-means clustering to find representative colors
-	return kMeansClustering(colors, numColors)
+	return kMeansClusteringWithMetric(ctx, colors, numColors, metric, rng)
+}
+
+// countDistinctColors counts the distinct colors in img, stopping early at limit+1
+func countDistinctColors(img image.Image, limit int) int {
+	bounds := img.Bounds()
+	seen := make(map[color.RGBA]bool)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			seen[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)] = true
+			if len(seen) > limit {
+				return len(seen)
+			}
+		}
+	}
+	return len(seen)
+}
+
+// flatImagePalette returns the exact colors of an already flat image (clip-art, cartoons)
+// ordered from most to least used, or nil if it has more than numColors colors
+func flatImagePalette(img image.Image, numColors int) []color.Color {
+	if countDistinctColors(img, numColors) > numColors {
+		return nil
+	}
+
+	bounds := img.Bounds()
+	counts := make(map[color.RGBA]int)
+	var order []color.RGBA
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if counts[c] == 0 {
+				order = append(order, c)
+			}
+			counts[c]++
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return counts[order[i]] > counts[order[j]]
+	})
+
+	palette := make([]color.Color, len(order))
+	for i, c := range order {
+		palette[i] = c
+	}
+	return palette
 }
 
 // kMeansClustering performs k-means clustering on colors with k-means++ initialization
-func kMeansClustering(colors []color.Color, k int) []color.Color {
+// seeded from rng
+func kMeansClustering(ctx context.Context, colors []color.Color, k int, rng *rand.Rand) []color.Color {
+	return kMeansClusteringWithMetric(ctx, colors, k, MetricEuclidean, rng)
+}
+
+// newRequestRand returns a random source for one request; a zero seed picks a random one
+func newRequestRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// kMeansClusteringWithMetric performs k-means clustering using the given metric for
+// assignments. Once ctx is done it stops between iterations and returns the centroids as
+// they stand, which still make a usable if less refined palette.
+func kMeansClusteringWithMetric(ctx context.Context, colors []color.Color, k int, metric ColorMetric, rng *rand.Rand) []color.Color {
 	if len(colors) == 0 {
 		return []color.Color{color.RGBA{128, 128, 128, 255}}
 	}
 
-	if k >= len(colors) {
-		return colors
+	// With no more distinct colors than clusters, every color is its own cluster
+	if distinct := distinctColors(colors, k); distinct != nil {
+		return distinct
 	}
 
 	// K-means++ initialization for better centroids
+	centroids := kMeansPlusPlus(ctx, colors, k, metric, rng)
+
+	// Run k-means iterations
+	for iter := 0; iter < 15; iter++ {
+		// A cancelled request keeps the centroids refined so far
+		if clusteringDone(ctx) {
+			break
+		}
+
+		// Assign each color to nearest centroid
+		clusters := make([][]color.Color, k)
+		for _, c := range colors {
+			nearest := findNearestColorWithMetric(c, centroids, metric)
+			clusters[nearest] = append(clusters[nearest], c)
+		}
+
+		// Update centroids
+		changed := false
+		for i, cluster := range clusters {
+			if len(cluster) > 0 {
+				newCentroid := averageColor(cluster)
+				if !colorsEqual(centroids[i], newCentroid) {
+					centroids[i] = newCentroid
+					changed = true
+				}
+			}
+		}
+
+		// Early stopping if converged
+		if !changed {
+			break
+		}
+	}
+
+	return centroids
+}
+
+// clusteringDone reports whether ctx is cancelled or past its deadline. The deadline is
+// compared directly because under js/wasm the timer that cancels ctx cannot fire while
+// clustering holds the page's only thread.
+func clusteringDone(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && time.Now().After(deadline)
+}
+
+// kMeansPlusPlus picks k initial centroids from colors: the first at random, each next one
+// with probability proportional to its distance from the nearest centroid chosen so far.
+// Once ctx is done the rest are picked uniformly at random, which costs nothing.
+func kMeansPlusPlus(ctx context.Context, colors []color.Color, k int, metric ColorMetric, rng *rand.Rand) []color.Color {
 	centroids := make([]color.Color, 0, k)
 
 	// Choose first centroid randomly
-	centroids = append(centroids, colors[rand.Intn(len(colors))])
+	centroids = append(centroids, colors[rng.Intn(len(colors))])
 
 	// Choose remaining centroids with probability proportional to distance squared
 	for len(centroids) < k {
+		if clusteringDone(ctx) {
+			centroids = append(centroids, colors[rng.Intn(len(colors))])
+			continue
+		}
+
 		distances := make([]float64, len(colors))
 		totalDist := 0.0
 
 		for i, c := range colors {
 			minDist := math.MaxFloat64
 			for _, centroid := range centroids {
-				dist := colorDistanceSquared(c, centroid)
+				dist := metric.distance(c, centroid)
 				if dist < minDist {
 					minDist = dist
 				}
@@ -116,7 +270,7 @@ func kMeansClustering(colors []color.Color, k int) []color.Color {
 		}
 
 		// Select next centroid with weighted probability
-		target := rand.Float64() * totalDist
+		target := rng.Float64() * totalDist
 		cumulative := 0.0
 		for i, dist := range distances {
 			cumulative += dist
@@ -127,33 +281,6 @@ func kMeansClustering(colors []color.Color, k int) []color.Color {
 		}
 	}
 
-	// Run k-means iterations
-	for iter := 0; iter < 15; iter++ {
-		// Assign each color to nearest centroid
-		clusters := make([][]color.Color, k)
-		for _, c := range colors {
-			nearest := findNearestColor(c, centroids)
-			clusters[nearest] = append(clusters[nearest], c)
-		}
-
-		// Update centroids
-		changed := false
-		for i, cluster := range clusters {
-			if len(cluster) > 0 {
-				newCentroid := averageColor(cluster)
-				if !colorsEqual(centroids[i], newCentroid) {
-					centroids[i] = newCentroid
-					changed = true
-				}
-			}
-		}
-
-		// Early stopping if converged
-		if !changed {
-			break
-		}
-	}
-
 	return centroids
 }
 
@@ -169,6 +296,25 @@ func colorDistanceSquared(c1, c2 color.Color) float64 {
 	return dr*dr + dg*dg + db*db
 }
 
+// distinctColors returns the distinct colors in first-seen order, or nil if there are more
+// than limit of them
+func distinctColors(colors []color.Color, limit int) []color.Color {
+	seen := make(map[color.RGBA]bool)
+	var distinct []color.Color
+	for _, c := range colors {
+		key := color.RGBAModel.Convert(c).(color.RGBA)
+		if seen[key] {
+			continue
+		}
+		if len(distinct) == limit {
+			return nil
+		}
+		seen[key] = true
+		distinct = append(distinct, c)
+	}
+	return distinct
+}
+
 // colorsEqual checks if two colors are equal
 func colorsEqual(c1, c2 color.Color) bool {
 	r1, g1, b1, a1 := c1.RGBA()
@@ -198,8 +344,33 @@ func findNearestColor(c color.Color, palette []color.Color) int {
 	return nearest
 }
 
-// averageColor computes the average of a set of colors
+// findNearestColorWithMetric finds the nearest palette color under the given metric
+func findNearestColorWithMetric(c color.Color, palette []color.Color, metric ColorMetric) int {
+	if metric == MetricEuclidean {
+		return findNearestColor(c, palette)
+	}
+
+	minDist := math.MaxFloat64
+	nearest := 0
+	for i, p := range palette {
+		dist := metric.distance(c, p)
+		if dist < minDist {
+			minDist = dist
+			nearest = i
+		}
+	}
+
+	return nearest
+}
+
+// averageColor computes the average of a set of colors. An empty set averages to opaque
+// mid-gray, the same fallback kMeansClustering uses for an empty image, rather than
+// dividing by zero.
 func averageColor(colors []color.Color) color.Color {
+	if len(colors) == 0 {
+		return color.RGBA{128, 128, 128, 255}
+	}
+
 	var r, g, b, a uint64
 	for _, c := range colors {
 		cr, cg, cb, ca := c.RGBA()
@@ -220,14 +391,20 @@ func averageColor(colors []color.Color) color.Color {
 
 // quantizePoints maps each point's color to the nearest palette color
 func quantizePoints(points []Point, palette []color.Color) []Point {
+	return quantizePointsWithMetric(points, palette, MetricEuclidean)
+}
+
+// quantizePointsWithMetric maps each point's color to the nearest palette color under the given metric
+func quantizePointsWithMetric(points []Point, palette []color.Color, metric ColorMetric) []Point {
 	quantized := make([]Point, len(points))
 	for i, p := range points {
-		nearest := findNearestColor(p.Color, palette)
+		nearest := findNearestColorWithMetric(p.Color, palette, metric)
 		quantized[i] = Point{
-			X:     p.X,
-			Y:     p.Y,
-			Color: palette[nearest],
-			Index: p.Index,
+			X:          p.X,
+			Y:          p.Y,
+			Color:      palette[nearest],
+			Index:      p.Index,
+			ColorIndex: nearest,
 		}
 	}
 	return quantized
@@ -235,9 +412,15 @@ func quantizePoints(points []Point, palette []color.Color) []Point {
 
 // addVoronoiBorders adds black borders between Voronoi regions
 func addVoronoiBorders(img *image.RGBA, points []Point) *image.RGBA {
+	return addVoronoiBordersWithProgress(img, points, nil)
+}
+
+// addVoronoiBordersWithProgress adds borders, reporting 70-85% as rows are processed
+func addVoronoiBordersWithProgress(img *image.RGBA, points []Point, progress ProgressCallback) *image.RGBA {
 	bounds := img.Bounds()
 	result := image.NewRGBA(bounds)
 	draw.Draw(result, bounds, img, bounds.Min, draw.Src)
+	report := stageProgress(progress, "Drawing borders", 70, 85, bounds.Dy())
 
 	// For each pixel, check if neighbors belong to different regions
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
@@ -246,36 +429,18 @@ func addVoronoiBorders(img *image.RGBA, points []Point) *image.RGBA {
 				result.Set(x, y, color.RGBA{0, 0, 0, 255})
 			}
 		}
+		if report != nil {
+			report(y - bounds.Min.Y + 1)
+		}
 	}
 
 	return result
 }
 
-// isBorderPixel checks if a pixel is on the border between regions
+// isBorderPixel checks if a pixel is on the border between regions. Only the right and
+// down neighbors are checked, which creates a single-pixel border on one side of each boundary.
 func isBorderPixel(x, y int, img *image.RGBA, points []Point) bool {
-	bounds := img.Bounds()
-	current := findNearestPoint(x, y, points)
-
-	// Only check right and down neighbors to create thinner lines
-	// This creates a single-pixel border on one side of each boundary
-	neighbors := [][2]int{
-		{x + 1, y}, // right
-		{x, y + 1}, // down
-	}
-
-	for _, n := range neighbors {
-		nx, ny := n[0], n[1]
-		if nx < bounds.Min.X || nx >= bounds.Max.X || ny < bounds.Min.Y || ny >= bounds.Max.Y {
-			continue
-		}
-
-		neighbor := findNearestPoint(nx, ny, points)
-		if neighbor != current {
-			return true
-		}
-	}
-
-	return false
+	return isBorderPixelWithWidth(x, y, img, points, 1, CellEuclidean, ConnectivityForward)
 }
 
 // ColorDistance calculates the Euclidean distance between two colors
@@ -296,14 +461,7 @@ func sortColorsByBrightness(colors []color.Color) []color.Color {
 	copy(sorted, colors)
 
 	sort.Slice(sorted, func(i, j int) bool {
-		r1, g1, b1, _ := sorted[i].RGBA()
-		r2, g2, b2, _ := sorted[j].RGBA()
-
-		// Perceived brightness formula
-		brightness1 := 0.299*float64(r1) + 0.587*float64(g1) + 0.114*float64(b1)
-		brightness2 := 0.299*float64(r2) + 0.587*float64(g2) + 0.114*float64(b2)
-
-		return brightness1 < brightness2
+		return luminance(sorted[i]) < luminance(sorted[j])
 	})
 
 	return sorted
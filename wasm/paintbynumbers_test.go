@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGeneratePaletteIsDeterministicForASeed(t *testing.T) {
+	img := syntheticImage(128)
+
+	first := generatePalette(img, 8, rand.New(rand.NewSource(42)))
+	second := generatePalette(img, 8, rand.New(rand.NewSource(42)))
+	if len(first) != len(second) {
+		t.Fatalf("palettes have %d and %d colors", len(first), len(second))
+	}
+	for i := range first {
+		a := color.RGBAModel.Convert(first[i]).(color.RGBA)
+		b := color.RGBAModel.Convert(second[i]).(color.RGBA)
+		if a != b {
+			t.Errorf("color %d = %v, then %v with the same seed", i, a, b)
+		}
+	}
+}
+
+func TestFlatImageKeepsItsExactColors(t *testing.T) {
+	// Five flat bands, none of which k-means would land on exactly
+	colors := []color.RGBA{{201, 13, 77, 255}, {13, 201, 77, 255}, {77, 13, 201, 255}, {250, 250, 245, 255}, {33, 33, 31, 255}}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 100; x++ {
+			img.SetRGBA(x, y, colors[x/20])
+		}
+	}
+
+	if n := countDistinctColors(img, 64); n != 5 {
+		t.Errorf("countDistinctColors = %d, want 5", n)
+	}
+
+	palette := layoutPalette(img, 8, ProcessOptions{}, rand.New(rand.NewSource(1)))
+	if len(palette) != len(colors) {
+		t.Fatalf("palette has %d colors, want %d", len(palette), len(colors))
+	}
+	have := make(map[color.RGBA]bool)
+	for _, c := range palette {
+		have[color.RGBAModel.Convert(c).(color.RGBA)] = true
+	}
+	for _, c := range colors {
+		if !have[c] {
+			t.Errorf("palette lost %v", c)
+		}
+	}
+}
+
+func TestProgressIsMonotonicAndFineGrained(t *testing.T) {
+	type report struct {
+		stage   string
+		percent int
+	}
+	var reports []report
+	convertToPaintByNumbersWithProgress(syntheticImage(160), 200, 6, func(stage string, percent int) {
+		reports = append(reports, report{stage, percent})
+	})
+
+	if len(reports) < 20 {
+		t.Fatalf("%d progress reports, want at least 20: %v", len(reports), reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].percent < reports[i-1].percent {
+			t.Fatalf("progress went back from %v to %v", reports[i-1], reports[i])
+		}
+	}
+	if last := reports[len(reports)-1]; last.percent != 100 {
+		t.Errorf("last report %v, want 100%%", last)
+	}
+
+	// The border and numbering passes report as they go rather than once each
+	perStage := make(map[string]int)
+	for _, r := range reports {
+		perStage[r.stage]++
+	}
+	for _, stage := range []string{"Drawing borders", "Adding numbers"} {
+		if perStage[stage] < 5 {
+			t.Errorf("%q reported %d times, want incremental updates", stage, perStage[stage])
+		}
+	}
+}
+
+func TestPaletteSamplingIsCapped(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bounds := image.Rect(0, 0, 4000, 3000) // 120,000 grid points at step 10
+	for _, limit := range []int{0, 500, 5000} {
+		points := paletteSamplePoints(bounds, paletteSampleStep, limit, rng)
+		want := limit
+		if want == 0 {
+			want = defaultMaxPaletteSamples
+		}
+		if len(points) != want {
+			t.Errorf("cap %d: sampled %d points, want %d", limit, len(points), want)
+		}
+		for i := 1; i < len(points); i++ {
+			if points[i].Y < points[i-1].Y || (points[i].Y == points[i-1].Y && points[i].X <= points[i-1].X) {
+				t.Fatalf("cap %d: samples out of raster order at %d", limit, i)
+			}
+		}
+	}
+
+	// Four flat quadrants on a large image still come out as the palette from 500 samples
+	quadrants := []color.RGBA{{220, 40, 40, 255}, {40, 160, 60, 255}, {40, 70, 200, 255}, {240, 200, 40, 255}}
+	img := image.NewRGBA(image.Rect(0, 0, 2000, 1600))
+	for y := 0; y < 1600; y++ {
+		for x := 0; x < 2000; x++ {
+			img.SetRGBA(x, y, quadrants[(y/800)*2+x/1000])
+		}
+	}
+	palette := generatePaletteWithMetric(context.Background(), img, 4, MetricEuclidean, paletteSampleStep, 500, rng)
+	for _, q := range quadrants {
+		if d := colorDistance(q, palette[findNearestColor(q, palette)]); d > 10 {
+			t.Errorf("quadrant color %v is %.0f from the nearest palette color of %v", q, d, palette)
+		}
+	}
+}
+
+func TestPaletteSamplesOptionIsRead(t *testing.T) {
+	img := syntheticImage(64)
+	if result := callProcessImage(t, img, testSheetArgs, map[string]interface{}{"paletteSamples": 50}); result.ErrorCode != "invalid_param" {
+		t.Errorf("paletteSamples 50: errorCode %q (%s), want invalid_param", result.ErrorCode, result.Error)
+	}
+	if result := callProcessImage(t, img, testSheetArgs, map[string]interface{}{"paletteSamples": 500}); result.Error != "" {
+		t.Errorf("paletteSamples 500 failed: %s", result.Error)
+	}
+}
+
+func TestAverageColorOfNothingIsMidGray(t *testing.T) {
+	if got := averageColor(nil); got != (color.RGBA{128, 128, 128, 255}) {
+		t.Errorf("averageColor(nil) = %v, want opaque mid-gray", got)
+	}
+	if got := averageColor([]color.Color{}); got != (color.RGBA{128, 128, 128, 255}) {
+		t.Errorf("averageColor of an empty slice = %v, want opaque mid-gray", got)
+	}
+
+	got := averageColor([]color.Color{color.RGBA{200, 0, 100, 255}, color.RGBA{100, 50, 0, 255}})
+	if got != (color.RGBA{150, 25, 50, 255}) {
+		t.Errorf("averageColor of two colors = %v, want their mean", got)
+	}
+}
+
+func TestPaletteResolutionMatchesFullResolutionFaster(t *testing.T) {
+	// Six noisy patches of well-separated colors
+	colors := []color.RGBA{{220, 40, 40, 255}, {40, 160, 60, 255}, {40, 70, 200, 255}, {240, 200, 40, 255}, {30, 30, 30, 255}, {230, 230, 230, 255}}
+	rng := rand.New(rand.NewSource(1))
+	noisy := func(v uint8) uint8 { return uint8(math.Max(0, math.Min(255, float64(v)+float64(rng.Intn(21)-10)))) }
+	img := image.NewRGBA(image.Rect(0, 0, 1024, 768))
+	for y := 0; y < 768; y++ {
+		for x := 0; x < 1024; x++ {
+			c := colors[(x/342+y/384*3)%6]
+			img.SetRGBA(x, y, color.RGBA{noisy(c.R), noisy(c.G), noisy(c.B), 255})
+		}
+	}
+
+	// Full resolution clusters every pixel of the image
+	start := time.Now()
+	full := generatePaletteWithMetric(context.Background(), img, 6, MetricEuclidean, 1, 1024*768, rand.New(rand.NewSource(1)))
+	fullTime := time.Since(start)
+
+	start = time.Now()
+	sampled := layoutPalette(img, 6, ProcessOptions{PaletteSize: 256}, rand.New(rand.NewSource(1)))
+	sampledTime := time.Since(start)
+
+	if len(sampled) != len(full) {
+		t.Fatalf("256px palette has %d colors, full resolution %d", len(sampled), len(full))
+	}
+	for _, c := range sampled {
+		nearest := math.Inf(1)
+		for _, f := range full {
+			nearest = math.Min(nearest, colorDistance(c, f)/257)
+		}
+		if nearest > 6 {
+			t.Errorf("256px palette color %v is %.1f from the nearest full resolution color", c, nearest)
+		}
+	}
+	if sampledTime >= fullTime {
+		t.Errorf("256px palette took %v, full resolution %v", sampledTime, fullTime)
+	}
+}
+
+func TestMoreColorsThanTheImageHasGivesDistinctColors(t *testing.T) {
+	// Thousands of samples of only three colors
+	three := []color.Color{color.RGBA{220, 40, 40, 255}, color.RGBA{40, 160, 60, 255}, color.RGBA{40, 70, 200, 255}}
+	var samples []color.Color
+	for i := 0; i < 3000; i++ {
+		samples = append(samples, three[i%3])
+	}
+	if palette := kMeansClustering(context.Background(), samples[:30], 64, rand.New(rand.NewSource(1))); len(palette) != 3 {
+		t.Errorf("64 colors from 30 samples of 3 colors gave %d palette entries, want 3", len(palette))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 90, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 90; x++ {
+			img.Set(x, y, three[x/30])
+		}
+	}
+	args := testSheetArgs
+	args.colors = 64
+	if result := mustProcessImage(t, img, args, nil); len(result.Palette) != 3 {
+		t.Errorf("64 colors from a 3-color image gave %d palette entries, want 3", len(result.Palette))
+	}
+}
+
+func TestCancelledKMeansReturnsPromptlyWithAUsablePalette(t *testing.T) {
+	samples := patchSamples(rand.New(rand.NewSource(1)), 100000)
+	const k = 12
+
+	started := time.Now()
+	kMeansClusteringWithMetric(context.Background(), samples, k, MetricEuclidean, rand.New(rand.NewSource(1)))
+	full := time.Since(started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), full/10)
+	defer cancel()
+	started = time.Now()
+	palette := kMeansClusteringWithMetric(ctx, samples, k, MetricEuclidean, rand.New(rand.NewSource(1)))
+	if elapsed := time.Since(started); elapsed > full/2 {
+		t.Errorf("cancelled after %v, clustering took %v, full run %v", full/10, elapsed, full)
+	}
+
+	// Usable: every color asked for, and each patch of the image has a color close to it
+	if len(palette) != k {
+		t.Fatalf("cancelled clustering returned %d colors, want %d", len(palette), k)
+	}
+	for _, patch := range []color.RGBA{{220, 40, 40, 255}, {40, 160, 60, 255}, {40, 70, 200, 255}, {240, 200, 40, 255}, {30, 30, 30, 255}, {230, 230, 230, 255}} {
+		nearest := math.Inf(1)
+		for _, c := range palette {
+			nearest = math.Min(nearest, colorDistance(patch, c)/257)
+		}
+		if nearest > 30 {
+			t.Errorf("patch %v is %.0f from the nearest color of the cancelled palette", patch, nearest)
+		}
+	}
+}
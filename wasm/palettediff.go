@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"math"
+	"sort"
+	"syscall/js"
+)
+
+// paletteMatchThreshold is the largest CIEDE2000 difference at which a color of one palette
+// still counts as the same color as one in the other; anything further apart is added or removed
+const paletteMatchThreshold = 15.0
+
+// PaletteMatch pairs a color of the first palette with its counterpart in the second
+type PaletteMatch struct {
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+	Shift float64 `json:"shift"` // CIEDE2000 difference
+}
+
+// PaletteDiff reports how a palette changed between two runs
+type PaletteDiff struct {
+	Matched      []PaletteMatch `json:"matched"`
+	Added        []string       `json:"added"`   // Colors only in the second palette
+	Removed      []string       `json:"removed"` // Colors only in the first palette
+	AverageShift float64        `json:"averageShift"`
+}
+
+// comparePalettes matches colors of a to colors of b one-to-one, closest pairs first, as
+// long as they are within paletteMatchThreshold. Unmatched colors of b are added and
+// unmatched colors of a removed; AverageShift is the mean difference over matched pairs.
+func comparePalettes(a, b []color.Color) PaletteDiff {
+	type pair struct {
+		i, j  int
+		shift float64
+	}
+	var pairs []pair
+	for i, ca := range a {
+		for j, cb := range b {
+			if shift := MetricCIEDE2000.distance(ca, cb); shift <= paletteMatchThreshold {
+				pairs = append(pairs, pair{i, j, shift})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(x, y int) bool {
+		return pairs[x].shift < pairs[y].shift
+	})
+
+	diff := PaletteDiff{Matched: []PaletteMatch{}, Added: []string{}, Removed: []string{}}
+	usedA := make([]bool, len(a))
+	usedB := make([]bool, len(b))
+	total := 0.0
+	for _, p := range pairs {
+		if usedA[p.i] || usedB[p.j] {
+			continue
+		}
+		usedA[p.i], usedB[p.j] = true, true
+		diff.Matched = append(diff.Matched, PaletteMatch{
+			From:  colorToHex(a[p.i]),
+			To:    colorToHex(b[p.j]),
+			Shift: math.Round(p.shift*100) / 100,
+		})
+		total += p.shift
+	}
+	for i, c := range a {
+		if !usedA[i] {
+			diff.Removed = append(diff.Removed, colorToHex(c))
+		}
+	}
+	for j, c := range b {
+		if !usedB[j] {
+			diff.Added = append(diff.Added, colorToHex(c))
+		}
+	}
+	if len(diff.Matched) > 0 {
+		diff.AverageShift = math.Round(total/float64(len(diff.Matched))*100) / 100
+	}
+
+	return diff
+}
+
+// comparePalettesJS is called from JavaScript with two palettes, each an array of "#rrggbb"
+// strings or of palette entries with a hex field (as processImage returns), and returns a
+// JSON PaletteDiff
+func comparePalettesJS(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return createErrorResult(invalidParam("Invalid arguments: expected (paletteA, paletteB)"))
+	}
+
+	palettes := make([][]color.Color, 2)
+	for n, arg := range args[:2] {
+		if arg.Type() != js.TypeObject || !arg.InstanceOf(js.Global().Get("Array")) {
+			return createErrorResult(invalidParam("Palettes must be arrays of hex colors"))
+		}
+		for i := 0; i < arg.Length(); i++ {
+			entry := arg.Index(i)
+			if entry.Type() == js.TypeObject {
+				entry = entry.Get("hex")
+			}
+			if entry.Type() != js.TypeString {
+				return createErrorResult(invalidParam("Palette entries must be hex colors like #3a5f8c"))
+			}
+			c, ok := parseHexColor(entry.String())
+			if !ok {
+				return createErrorResult(invalidParam("Invalid palette color %q", entry.String()))
+			}
+			palettes[n] = append(palettes[n], c)
+		}
+	}
+
+	jsonBytes, err := json.Marshal(comparePalettes(palettes[0], palettes[1]))
+	if err != nil {
+		return createErrorResult(conversionError(ErrInternal, "Failed to marshal JSON: %v", err))
+	}
+	return string(jsonBytes)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestComparePalettesCountsMatchedAddedRemoved(t *testing.T) {
+	a := []color.Color{
+		color.RGBA{220, 40, 40, 255},   // Red, nudged in b
+		color.RGBA{40, 160, 60, 255},   // Green, kept exactly
+		color.RGBA{40, 70, 200, 255},   // Blue, dropped
+		color.RGBA{255, 255, 255, 255}, // White, kept exactly
+	}
+	b := []color.Color{
+		color.RGBA{255, 255, 255, 255},
+		color.RGBA{224, 44, 40, 255},
+		color.RGBA{40, 160, 60, 255},
+		color.RGBA{240, 200, 40, 255}, // Yellow, new
+		color.RGBA{20, 20, 20, 255},   // Black, new
+	}
+
+	diff := comparePalettes(a, b)
+	if len(diff.Matched) != 3 || len(diff.Added) != 2 || len(diff.Removed) != 1 {
+		t.Fatalf("matched %d, added %d, removed %d; want 3, 2 and 1", len(diff.Matched), len(diff.Added), len(diff.Removed))
+	}
+	if diff.Removed[0] != "#2846c8" {
+		t.Errorf("removed %v, want the blue", diff.Removed)
+	}
+	if !slices.Equal(diff.Added, []string{"#f0c828", "#141414"}) {
+		t.Errorf("added %v, want the yellow and black", diff.Added)
+	}
+	for _, m := range diff.Matched {
+		if m.From == "#dc2828" && m.To != "#e02c28" {
+			t.Errorf("red matched to %s, want its nudged copy", m.To)
+		}
+	}
+	if diff.AverageShift <= 0 || diff.AverageShift > 2 {
+		t.Errorf("average shift %.2f, want a small positive shift from the red alone", diff.AverageShift)
+	}
+}
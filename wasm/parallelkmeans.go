@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"sync"
+)
+
+// maxPaletteShards caps how many shards parallelKMeans splits the samples into
+const maxPaletteShards = 16
+
+// shardOversample is how many more centroids than the palette size each shard finds. A
+// shard whose k-means settles with one centroid between two real clusters would otherwise
+// hand the merge a heavy color that belongs to neither; finer shard centroids keep such
+// mistakes small.
+const shardOversample = 2
+
+// generateShardedPalette samples img like generatePaletteWithMetric and clusters the
+// samples with parallelKMeans
+func generateShardedPalette(ctx context.Context, img image.Image, numColors int, metric ColorMetric, shards, step, maxSamples int, rng *rand.Rand) []color.Color {
+	var colors []color.Color
+	for _, p := range paletteSamplePoints(img.Bounds(), step, maxSamples, rng) {
+		colors = append(colors, img.At(p.X, p.Y))
+	}
+	return parallelKMeans(ctx, colors, numColors, shards, metric, rng)
+}
+
+// parallelKMeans clusters colors map-reduce style: the samples are dealt round-robin into
+// shards, each shard is clustered into shardOversample×k colors on its own goroutine, and
+// the shards' centroids are clustered again, weighted by how many samples each one stood
+// for, into the final k. The result is close to one k-means over all the samples, and the shards
+// run in parallel where goroutines get more than one thread (not under js/wasm, where
+// they take turns on the page's single thread).
+func parallelKMeans(ctx context.Context, colors []color.Color, k, shards int, metric ColorMetric, rng *rand.Rand) []color.Color {
+	// Every shard needs at least as many samples as centroids to find
+	shardK := shardOversample * k
+	if k > 0 && shards > len(colors)/shardK {
+		shards = len(colors) / shardK
+	}
+	if k <= 0 || shards <= 1 {
+		return kMeansClusteringWithMetric(ctx, colors, k, metric, rng)
+	}
+
+	parts := make([][]color.Color, shards)
+	for i, c := range colors {
+		parts[i%shards] = append(parts[i%shards], c)
+	}
+
+	// rand.Rand is not safe for concurrent use, so each shard gets its own, seeded up front
+	// to keep results reproducible for a given seed
+	seeds := make([]int64, shards)
+	for i := range seeds {
+		seeds[i] = rng.Int63()
+	}
+
+	results := make([][]histogramBin, shards)
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			centroids := kMeansClusteringWithMetric(ctx, parts[i], shardK, metric, rand.New(rand.NewSource(seeds[i])))
+			counts := make([]int, len(centroids))
+			for _, c := range parts[i] {
+				counts[findNearestColorWithMetric(c, centroids, metric)]++
+			}
+			for j, c := range centroids {
+				if counts[j] > 0 {
+					results[i] = append(results[i], histogramBin{Color: color.RGBAModel.Convert(c).(color.RGBA), Count: counts[j]})
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var merged []histogramBin
+	for _, bins := range results {
+		merged = append(merged, bins...)
+	}
+	return weightedKMeans(ctx, merged, k, metric, rng)
+}
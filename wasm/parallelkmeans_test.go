@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// patchSamples returns n noisy samples of six well-separated colors
+func patchSamples(rng *rand.Rand, n int) []color.Color {
+	centers := []color.RGBA{{220, 40, 40, 255}, {40, 160, 60, 255}, {40, 70, 200, 255}, {240, 200, 40, 255}, {30, 30, 30, 255}, {230, 230, 230, 255}}
+	noisy := func(v uint8) uint8 { return uint8(math.Max(0, math.Min(255, float64(v)+float64(rng.Intn(21)-10)))) }
+	samples := make([]color.Color, n)
+	for i := range samples {
+		c := centers[rng.Intn(len(centers))]
+		samples[i] = color.RGBA{noisy(c.R), noisy(c.G), noisy(c.B), 255}
+	}
+	return samples
+}
+
+func TestParallelKMeansIsCloseToSerial(t *testing.T) {
+	samples := patchSamples(rand.New(rand.NewSource(1)), 40000)
+	serial := kMeansClusteringWithMetric(context.Background(), samples, 6, MetricEuclidean, rand.New(rand.NewSource(1)))
+	for _, shards := range []int{2, 4, 8} {
+		merged := parallelKMeans(context.Background(), samples, 6, shards, MetricEuclidean, rand.New(rand.NewSource(1)))
+		if len(merged) != len(serial) {
+			t.Fatalf("%d shards: %d colors, serial %d", shards, len(merged), len(serial))
+		}
+		for _, c := range serial {
+			nearest := math.Inf(1)
+			for _, m := range merged {
+				nearest = math.Min(nearest, colorDistance(c, m)/257)
+			}
+			if nearest > 6 {
+				t.Errorf("%d shards: serial color %v is %.1f from the nearest merged color", shards, c, nearest)
+			}
+		}
+	}
+}
+
+func BenchmarkParallelKMeans(b *testing.B) {
+	samples := patchSamples(rand.New(rand.NewSource(1)), 100000)
+	for _, shards := range []int{1, 4} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rng := rand.New(rand.NewSource(1))
+				if shards == 1 {
+					kMeansClusteringWithMetric(context.Background(), samples, 12, MetricEuclidean, rng)
+				} else {
+					parallelKMeans(context.Background(), samples, 12, shards, MetricEuclidean, rng)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngCompressionLevels maps the compression option to zlib effort; any other name gets
+// the default
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// encodePNG encodes img as PNG at the named compression level: "best" for the smallest
+// downloads, "fast" for quick previews
+func encodePNG(w io.Writer, img image.Image, compression string) error {
+	encoder := png.Encoder{CompressionLevel: pngCompressionLevels[compression]}
+	return encoder.Encode(w, img)
+}
+
+// pngChunk is one ancillary chunk to add to an encoded PNG
+type pngChunk struct {
+	kind string // Four-letter chunk type, e.g. "sRGB"
+	data []byte
+}
+
+// srgbChunks tag an image as sRGB: the sRGB chunk (perceptual intent) for decoders that
+// know it, plus the gAMA and cHRM values the PNG spec says should accompany it for those
+// that don't
+var srgbChunks = []pngChunk{
+	{kind: "sRGB", data: []byte{0}},
+	{kind: "gAMA", data: pngUint32s(45455)},
+	{kind: "cHRM", data: pngUint32s(31270, 32900, 64000, 33000, 30000, 60000, 15000, 6000)},
+}
+
+// pngUint32s encodes values as consecutive big-endian uint32s, the layout of gAMA and cHRM
+func pngUint32s(values ...uint32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], v)
+	}
+	return data
+}
+
+// insertPNGChunks returns a copy of an encoded PNG with chunks placed right after IHDR,
+// where color space chunks must appear (before PLTE and IDAT)
+func insertPNGChunks(data []byte, chunks []pngChunk) ([]byte, error) {
+	// Signature, then IHDR: length, type, 13 data bytes and CRC
+	ihdrEnd := len(pngSignature) + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.Equal(data[:len(pngSignature)], pngSignature) ||
+		string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, errors.New("not a PNG starting with IHDR")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	for _, chunk := range chunks {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(chunk.data)))
+		copy(header[4:], chunk.kind)
+		out.Write(header[:])
+		out.Write(chunk.data)
+
+		// The CRC covers the type and data, not the length
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(chunk.data)
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		out.Write(sum[:])
+	}
+	out.Write(data[ihdrEnd:])
+
+	return out.Bytes(), nil
+}
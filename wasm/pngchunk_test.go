@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// pngChunkTypes walks an encoded PNG and returns its chunk types in order, failing on a
+// bad CRC
+func pngChunkTypes(t *testing.T, data []byte) []string {
+	t.Helper()
+	if !bytes.HasPrefix(data, pngSignature) {
+		t.Fatal("data does not start with the PNG signature")
+	}
+	var types []string
+	for rest := data[len(pngSignature):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			t.Fatalf("truncated chunk after %v", types)
+		}
+		length := int(binary.BigEndian.Uint32(rest[:4]))
+		body := rest[4 : 8+length]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(rest[8+length:12+length]) {
+			t.Fatalf("chunk %s has a bad CRC", body[:4])
+		}
+		types = append(types, string(body[:4]))
+		rest = rest[12+length:]
+	}
+	return types
+}
+
+func TestColorProfileTagsResultAsSRGB(t *testing.T) {
+	for _, tc := range []struct {
+		profile string
+		tagged  bool
+	}{
+		{"none", false},
+		{"srgb", true},
+	} {
+		result := mustProcessImage(t, syntheticImage(96), testSheetArgs, map[string]interface{}{"colorProfile": tc.profile})
+		data := decodeBase64(t, result.Image)
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%s: result does not decode: %v", tc.profile, err)
+		}
+
+		seen := make(map[string]int)
+		for i, kind := range pngChunkTypes(t, data) {
+			if _, ok := seen[kind]; !ok {
+				seen[kind] = i
+			}
+		}
+		for _, kind := range []string{"sRGB", "gAMA", "cHRM"} {
+			i, ok := seen[kind]
+			if ok != tc.tagged {
+				t.Errorf("colorProfile %s: %s chunk present = %v", tc.profile, kind, ok)
+			}
+			if ok && i > seen["IDAT"] {
+				t.Errorf("colorProfile %s: %s chunk comes after IDAT", tc.profile, kind)
+			}
+		}
+	}
+}
+
+func TestCompressionLevelsTradeSizeForSpeed(t *testing.T) {
+	img := syntheticImage(512)
+	sizes := make(map[string]int)
+	times := make(map[string]time.Duration)
+	for _, level := range []string{"none", "fast", "default", "best"} {
+		for run := 0; run < 3; run++ {
+			var buf bytes.Buffer
+			start := time.Now()
+			if err := encodePNG(&buf, img, level); err != nil {
+				t.Fatalf("%s: %v", level, err)
+			}
+			if elapsed := time.Since(start); run == 0 || elapsed < times[level] {
+				times[level] = elapsed
+			}
+			sizes[level] = buf.Len()
+		}
+	}
+
+	if !(sizes["best"] <= sizes["default"] && sizes["default"] <= sizes["fast"] && sizes["fast"] < sizes["none"]) {
+		t.Errorf("sizes %v, want best <= default <= fast < none", sizes)
+	}
+	if times["fast"] >= times["best"] {
+		t.Errorf("fast took %v, best %v; want fast quicker", times["fast"], times["best"])
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Polygon is one traced area of equal assignment values: its rings of pixel-corner
+// coordinates, the outer boundary first and then any holes. Rings are closed (the last
+// point repeats the first) and keep only the corners where the boundary turns.
+type Polygon struct {
+	Value int
+	Rings [][]image.Point
+}
+
+// Directions of boundary edges between pixel corners, clockwise on screen (y down)
+var traceDirections = [4]image.Point{{X: 1, Y: 0}, {X: 0, Y: 1}, {X: -1, Y: 0}, {X: 0, Y: -1}}
+
+// tracePolygons outlines every 4-connected area of equal values in assignment (row-major
+// over bounds). Each boundary edge is walked with its area on the right, turning right
+// where it can, so areas touching only at a corner get separate rings. Coordinates are
+// in bounds' pixel coordinates; a pixel (x, y) spans (x, y) to (x+1, y+1).
+func tracePolygons(assignment []int, bounds image.Rectangle) []Polygon {
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 || len(assignment) < width*height {
+		return nil
+	}
+	value := func(x, y int) (int, bool) {
+		if x < 0 || x >= width || y < 0 || y >= height {
+			return 0, false
+		}
+		return assignment[y*width+x], true
+	}
+
+	// Number the 4-connected areas so rings can be grouped by area
+	area := make([]int, width*height)
+	for i := range area {
+		area[i] = -1
+	}
+	var areaValues []int
+	var stack []int
+	for start := range area {
+		if area[start] >= 0 {
+			continue
+		}
+		id := len(areaValues)
+		areaValues = append(areaValues, assignment[start])
+		area[start] = id
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%width, i/width
+			for _, d := range traceDirections {
+				nx, ny := x+d.X, y+d.Y
+				if v, ok := value(nx, ny); ok && v == assignment[start] && area[ny*width+nx] < 0 {
+					area[ny*width+nx] = id
+					stack = append(stack, ny*width+nx)
+				}
+			}
+		}
+	}
+
+	// Mark boundary edges by start corner: bit d means an edge leaving in traceDirections[d].
+	// Top edges run right, right edges down, bottom edges left and left edges up, so the
+	// pixel they bound is always on the right.
+	cornersWide := width + 1
+	edges := make([]uint8, cornersWide*(height+1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := assignment[y*width+x]
+			if n, ok := value(x, y-1); !ok || n != v {
+				edges[y*cornersWide+x] |= 1 << 0
+			}
+			if n, ok := value(x+1, y); !ok || n != v {
+				edges[y*cornersWide+x+1] |= 1 << 1
+			}
+			if n, ok := value(x, y+1); !ok || n != v {
+				edges[(y+1)*cornersWide+x+1] |= 1 << 2
+			}
+			if n, ok := value(x-1, y); !ok || n != v {
+				edges[(y+1)*cornersWide+x] |= 1 << 3
+			}
+		}
+	}
+
+	rings := make([][][]image.Point, len(areaValues))
+	for corner := range edges {
+		for d := 0; d < 4; d++ {
+			if edges[corner]&(1<<d) == 0 {
+				continue
+			}
+
+			// The pixel on the right of the first edge names the area this ring bounds
+			cx, cy := corner%cornersWide, corner/cornersWide
+			px, py := cx, cy
+			switch d {
+			case 1:
+				px--
+			case 2:
+				px, py = px-1, py-1
+			case 3:
+				py--
+			}
+			id := area[py*width+px]
+
+			ring := traceRing(edges, cornersWide, cx, cy, d)
+			for i := range ring {
+				ring[i] = ring[i].Add(bounds.Min)
+			}
+			rings[id] = append(rings[id], ring)
+		}
+	}
+
+	polygons := make([]Polygon, 0, len(areaValues))
+	for id, areaRings := range rings {
+		// The outer boundary runs clockwise (positive area on screen), holes the other way
+		sort.SliceStable(areaRings, func(a, b int) bool {
+			return ringArea(areaRings[a]) > ringArea(areaRings[b])
+		})
+		polygons = append(polygons, Polygon{Value: areaValues[id], Rings: areaRings})
+	}
+	return polygons
+}
+
+// traceRing follows boundary edges from corner (x, y) leaving in direction d until it
+// leaves that corner the same way again, clearing each edge it uses, and returns the
+// corners where it turns, closed
+func traceRing(edges []uint8, cornersWide, x, y, d int) []image.Point {
+	startX, startY, startD := x, y, d
+	var ring []image.Point
+	for {
+		// The first edge stays set until the end so the walk can recognize it
+		if x != startX || y != startY || d != startD {
+			edges[y*cornersWide+x] &^= 1 << d
+		}
+		x, y = x+traceDirections[d].X, y+traceDirections[d].Y
+
+		// Right turn, then straight on, then left turn
+		next := -1
+		for _, turn := range []int{1, 0, 3} {
+			nd := (d + turn) % 4
+			if edges[y*cornersWide+x]&(1<<nd) != 0 {
+				next = nd
+				break
+			}
+		}
+		if next != d {
+			ring = append(ring, image.Point{X: x, Y: y})
+		}
+		if next < 0 || (x == startX && y == startY && next == startD) {
+			break
+		}
+		d = next
+	}
+	edges[startY*cornersWide+startX] &^= 1 << startD
+
+	// Start at the first turn and close the ring
+	if len(ring) > 0 && ring[len(ring)-1] != ring[0] {
+		ring = append(ring, ring[0])
+	}
+	return ring
+}
+
+// ringArea is the shoelace area of a closed ring, positive when it runs clockwise on screen
+func ringArea(ring []image.Point) int {
+	area := 0
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i].X*ring[i+1].Y - ring[i+1].X*ring[i].Y
+	}
+	return area / 2
+}
+
+// GeoJSON-like output of traced regions, one feature per numbered region with its rings
+// as [x, y] pixel-corner coordinates of the output image
+type (
+	RegionGeoJSON struct {
+		Type     string    `json:"type"` // Always "FeatureCollection"
+		Features []Feature `json:"features"`
+	}
+	Feature struct {
+		Type       string            `json:"type"` // Always "Feature"
+		Properties FeatureProperties `json:"properties"`
+		Geometry   PolygonGeometry   `json:"geometry"`
+	}
+	FeatureProperties struct {
+		Number int    `json:"number"`
+		Hex    string `json:"hex"`
+	}
+	PolygonGeometry struct {
+		Type        string     `json:"type"` // Always "Polygon"
+		Coordinates [][][2]int `json:"coordinates"`
+	}
+)
+
+// regionPolygons traces the numbered regions of the layout, numbered as in palette (the
+// renumbered palette render returns), with coordinates shifted by offset
+func (l *sheetLayout) regionPolygons(palette []color.Color, offset image.Point) *RegionGeoJSON {
+	regions := buildLabeledRegions(l.assignment(), l.bounds, l.palette, l.minArea, l.labeling, l.keepAllColors)
+
+	// Trace regions rather than raw colors, so fragments merged into a region stay inside it
+	collection := &RegionGeoJSON{Type: "FeatureCollection", Features: []Feature{}}
+	for _, polygon := range tracePolygons(regionOwners(regions, l.bounds), l.bounds) {
+		if polygon.Value < 0 {
+			continue
+		}
+		c := l.palette[regions[polygon.Value].ColorIndex]
+		number := 0
+		for i, p := range palette {
+			if colorsEqual(p, c) {
+				number = i + 1
+				break
+			}
+		}
+
+		coordinates := make([][][2]int, len(polygon.Rings))
+		for i, ring := range polygon.Rings {
+			for _, p := range ring {
+				p = p.Sub(l.bounds.Min).Add(offset)
+				coordinates[i] = append(coordinates[i], [2]int{p.X, p.Y})
+			}
+		}
+		collection.Features = append(collection.Features, Feature{
+			Type:       "Feature",
+			Properties: FeatureProperties{Number: number, Hex: colorToHex(c)},
+			Geometry:   PolygonGeometry{Type: "Polygon", Coordinates: coordinates},
+		})
+	}
+	return collection
+}
+
+// regionOwners maps every pixel of bounds (row-major) to the index of the region in
+// regions that holds it, or -1 for pixels in none
+func regionOwners(regions []Region, bounds image.Rectangle) []int {
+	width := bounds.Dx()
+	owner := make([]int, width*bounds.Dy())
+	for i := range owner {
+		owner[i] = -1
+	}
+	for ri, region := range regions {
+		for _, p := range region.Pixels {
+			owner[(p.Y-bounds.Min.Y)*width+(p.X-bounds.Min.X)] = ri
+		}
+	}
+	return owner
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestRectangleTracesToClosedFourCornerPolygon(t *testing.T) {
+	bounds := image.Rect(0, 0, 20, 15)
+	rect := image.Rect(5, 3, 12, 9)
+	assignment := make([]int, 20*15)
+	paintRect(assignment, 20, rect, 1)
+
+	var found *Polygon
+	polygons := tracePolygons(assignment, bounds)
+	for i := range polygons {
+		if polygons[i].Value == 1 {
+			if found != nil {
+				t.Fatal("value 1 traced to more than one polygon")
+			}
+			found = &polygons[i]
+		}
+	}
+	if found == nil || len(found.Rings) != 1 {
+		t.Fatalf("value 1 traced to %+v, want one polygon with one ring", found)
+	}
+
+	ring := found.Rings[0]
+	if len(ring) != 4 && len(ring) != 5 {
+		t.Fatalf("ring has %d points, want 4 corners (5 if closed): %v", len(ring), ring)
+	}
+	if len(ring) == 5 && ring[0] != ring[4] {
+		t.Errorf("5-point ring does not end where it starts: %v", ring)
+	}
+	corners := map[image.Point]bool{rect.Min: true, {rect.Max.X, rect.Min.Y}: true, rect.Max: true, {rect.Min.X, rect.Max.Y}: true}
+	for _, p := range ring[:4] {
+		if !corners[p] {
+			t.Errorf("ring point %v is not a corner of %v", p, rect)
+		}
+		delete(corners, p)
+	}
+	if len(corners) != 0 {
+		t.Errorf("ring %v misses corners %v", ring, corners)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"syscall/js"
+)
+
+// recipeVersion is bumped whenever the recipe format changes incompatibly
+const recipeVersion = 1
+
+// Recipe records everything behind a sheet (parameters, seed, palette and seed points) so
+// it can be saved, shared and passed back with the same image to reproduce it exactly
+type Recipe struct {
+	Version      int             `json:"version"`
+	Points       int             `json:"points"`
+	Colors       int             `json:"colors"`
+	LineWidth    int             `json:"lineWidth"`
+	MaxDimension int             `json:"maxDimension"`
+	ShowColors   bool            `json:"showColors"`
+	UseVoronoi   bool            `json:"useVoronoi"`
+	Options      json.RawMessage `json:"options"` // The options object as passed to processImage
+	Seed         int64           `json:"seed"`
+	Width        int             `json:"width"` // Size of the image after downsampling
+	Height       int             `json:"height"`
+	Palette      []string        `json:"palette"`              // Unnumbered palette as hex
+	SeedPoints   [][3]int        `json:"seedPoints,omitempty"` // x, y, palette index (Voronoi only)
+}
+
+// buildRecipe completes base (which carries the request parameters) with the seed,
+// palette and points of layout and encodes it as JSON
+func buildRecipe(base Recipe, layout *sheetLayout, seed int64) ([]byte, error) {
+	recipe := base
+	recipe.Version = recipeVersion
+	recipe.Seed = seed
+	recipe.Width = layout.bounds.Dx()
+	recipe.Height = layout.bounds.Dy()
+
+	recipe.Palette = make([]string, len(layout.palette))
+	for i, c := range layout.palette {
+		recipe.Palette[i] = recipeColorHex(c)
+	}
+
+	for _, p := range layout.points {
+		recipe.SeedPoints = append(recipe.SeedPoints, [3]int{
+			p.X - layout.bounds.Min.X,
+			p.Y - layout.bounds.Min.Y,
+			p.ColorIndex,
+		})
+	}
+
+	return json.Marshal(recipe)
+}
+
+// parseRecipe decodes and checks a recipe produced by buildRecipe
+func parseRecipe(text string) (Recipe, error) {
+	var recipe Recipe
+	if err := json.Unmarshal([]byte(text), &recipe); err != nil {
+		return recipe, invalidParam("Recipe is not valid JSON: %v", err)
+	}
+	if recipe.Version != recipeVersion {
+		return recipe, invalidParam("Recipe version %d is not supported", recipe.Version)
+	}
+	if len(recipe.Palette) == 0 {
+		return recipe, invalidParam("Recipe has no palette")
+	}
+	if _, err := parsePaletteHex(recipe.Palette, "Recipe palette"); err != nil {
+		return recipe, err
+	}
+	for _, p := range recipe.SeedPoints {
+		if p[2] < 0 || p[2] >= len(recipe.Palette) {
+			return recipe, invalidParam("Recipe seed point refers to palette color %d of %d", p[2]+1, len(recipe.Palette))
+		}
+	}
+	return recipe, nil
+}
+
+// parsePaletteHex converts a palette given as hex colors, as recipes record it and the
+// palette option takes it; name says which one an error is about
+func parsePaletteHex(hexes []string, name string) ([]color.Color, error) {
+	palette := make([]color.Color, len(hexes))
+	for i, hex := range hexes {
+		c, ok := parseHexColor(hex)
+		if !ok {
+			return nil, invalidParam("%s color %q is not a hex color", name, hex)
+		}
+		palette[i] = c
+	}
+	return palette, nil
+}
+
+// options returns the recorded options as a JavaScript object for parseProcessOptions
+func (r Recipe) options() js.Value {
+	if len(r.Options) == 0 {
+		return js.Undefined()
+	}
+	return js.Global().Get("JSON").Call("parse", string(r.Options))
+}
+
+// layout rebuilds the sheet layout from the recorded palette and points instead of
+// running palette generation and point sampling again
+func (r Recipe) layout(img image.Image, opts ProcessOptions) (*sheetLayout, error) {
+	opts = opts.simplified(img.Bounds())
+	bounds := img.Bounds()
+	if bounds.Dx() != r.Width || bounds.Dy() != r.Height {
+		return nil, invalidParam("Recipe was made from a %dx%d image but this one is %dx%d", r.Width, r.Height, bounds.Dx(), bounds.Dy())
+	}
+
+	palette, err := parsePaletteHex(r.Palette, "Recipe palette")
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.UseVoronoi {
+		return newGridLayout(img, palette, opts), nil
+	}
+
+	points := make([]Point, len(r.SeedPoints))
+	for i, p := range r.SeedPoints {
+		points[i] = Point{
+			X:          p[0] + bounds.Min.X,
+			Y:          p[1] + bounds.Min.Y,
+			Color:      palette[p[2]],
+			Index:      i,
+			ColorIndex: p[2],
+		}
+	}
+	return newVoronoiLayout(img, palette, points, opts), nil
+}
+
+// recordedOptions serializes a JavaScript options object for a recipe, leaving out the
+// recipe fields themselves
+func recordedOptions(v js.Value) (json.RawMessage, error) {
+	if v.Type() != js.TypeObject {
+		return json.RawMessage("{}"), nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(js.Global().Get("JSON").Call("stringify", v).String()), &fields); err != nil {
+		return nil, fmt.Errorf("failed to record options: %w", err)
+	}
+	delete(fields, "recipe")
+	delete(fields, "exportRecipe")
+
+	// Seed points are recorded, so the (possibly large) map they were drawn from is not needed
+	if _, ok := fields["densityMap"]; ok {
+		delete(fields, "densityMap")
+		delete(fields, "distribution")
+	}
+	return json.Marshal(fields)
+}
+
+// recipeColorHex writes a palette color as #rrggbb, adding an alpha byte when it is not opaque
+func recipeColorHex(c color.Color) string {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	if rgba.A == 255 {
+		return colorToHex(rgba)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", rgba.R, rgba.G, rgba.B, rgba.A)
+}
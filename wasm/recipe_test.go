@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestRecipeReproducesIdenticalOutput(t *testing.T) {
+	img := syntheticImage(192)
+	for _, useVoronoi := range []bool{true, false} {
+		args := testSheetArgs
+		args.useVoronoi = useVoronoi
+		args.showColors = true
+		first := mustProcessImage(t, img, args, map[string]interface{}{"exportRecipe": true, "numberSpacing": 60})
+		if first.Recipe == "" {
+			t.Fatalf("useVoronoi=%v: no recipe returned", useVoronoi)
+		}
+
+		// The recipe, not these deliberately different arguments, decides the result
+		replay := mustProcessImage(t, img, sheetArgs{points: 500, colors: 12, lineWidth: 3, maxDimension: 1024}, map[string]interface{}{"recipe": first.Recipe})
+		if replay.Image != first.Image {
+			t.Errorf("useVoronoi=%v: replayed image differs from the original", useVoronoi)
+		}
+		if len(replay.Palette) != len(first.Palette) {
+			t.Fatalf("useVoronoi=%v: replayed palette has %d colors, want %d", useVoronoi, len(replay.Palette), len(first.Palette))
+		}
+		for i := range first.Palette {
+			if replay.Palette[i].Hex != first.Palette[i].Hex {
+				t.Errorf("useVoronoi=%v: palette color %d = %s, want %s", useVoronoi, i+1, replay.Palette[i].Hex, first.Palette[i].Hex)
+			}
+		}
+	}
+}
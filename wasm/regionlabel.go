@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// RegionLabel selects what is printed inside each region of the sheet
+type RegionLabel int
+
+const (
+	// RegionLabelNumber prints the color's number, to look up in the legend (the default)
+	RegionLabelNumber RegionLabel = iota
+	// RegionLabelHex prints the color's hex code without the leading '#', e.g. "3A7F2C"
+	RegionLabelHex
+	// RegionLabelName prints the name of the nearest basic color, e.g. "OLIVE"
+	RegionLabelName
+)
+
+// parseRegionLabel converts a label name to a RegionLabel
+func parseRegionLabel(name string) (RegionLabel, bool) {
+	switch name {
+	case "", "number":
+		return RegionLabelNumber, true
+	case "hex":
+		return RegionLabelHex, true
+	case "name":
+		return RegionLabelName, true
+	}
+	return RegionLabelNumber, false
+}
+
+// text returns what to print for a region of color c numbered colorNumber
+func (l RegionLabel) text(colorNumber int, c color.Color) string {
+	switch l {
+	case RegionLabelHex:
+		return strings.ToUpper(strings.TrimPrefix(colorToHex(c), "#"))
+	case RegionLabelName:
+		return colorName(c)
+	default:
+		return strconv.Itoa(colorNumber)
+	}
+}
+
+// namedColors are the names RegionLabelName chooses from: single words short enough to
+// fit a region, with one well-known shade for each
+var namedColors = []struct {
+	name  string
+	color color.RGBA
+}{
+	{"BLACK", color.RGBA{0, 0, 0, 255}},
+	{"GRAY", color.RGBA{128, 128, 128, 255}},
+	{"SILVER", color.RGBA{192, 192, 192, 255}},
+	{"WHITE", color.RGBA{255, 255, 255, 255}},
+	{"MAROON", color.RGBA{128, 0, 0, 255}},
+	{"RED", color.RGBA{220, 20, 20, 255}},
+	{"PINK", color.RGBA{255, 170, 190, 255}},
+	{"BROWN", color.RGBA{140, 80, 30, 255}},
+	{"ORANGE", color.RGBA{255, 140, 0, 255}},
+	{"TAN", color.RGBA{210, 180, 140, 255}},
+	{"BEIGE", color.RGBA{240, 230, 200, 255}},
+	{"GOLD", color.RGBA{230, 180, 30, 255}},
+	{"YELLOW", color.RGBA{255, 235, 40, 255}},
+	{"OLIVE", color.RGBA{128, 128, 0, 255}},
+	{"LIME", color.RGBA{150, 220, 40, 255}},
+	{"GREEN", color.RGBA{30, 140, 40, 255}},
+	{"FOREST", color.RGBA{20, 80, 30, 255}},
+	{"TEAL", color.RGBA{0, 128, 128, 255}},
+	{"CYAN", color.RGBA{60, 210, 230, 255}},
+	{"SKY", color.RGBA{135, 200, 240, 255}},
+	{"BLUE", color.RGBA{30, 80, 220, 255}},
+	{"NAVY", color.RGBA{20, 30, 110, 255}},
+	{"PURPLE", color.RGBA{120, 40, 150, 255}},
+	{"VIOLET", color.RGBA{190, 130, 230, 255}},
+	{"MAGENTA", color.RGBA{220, 30, 180, 255}},
+}
+
+// colorName returns the name of the perceptually nearest (CIEDE2000) entry of namedColors
+func colorName(c color.Color) string {
+	best, bestDist := "", 0.0
+	for i, named := range namedColors {
+		if dist := MetricCIEDE2000.distance(c, named.color); i == 0 || dist < bestDist {
+			best, bestDist = named.name, dist
+		}
+	}
+	return best
+}
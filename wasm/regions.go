@@ -0,0 +1,369 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// defaultMinRegionArea is the smallest region (in pixels) that gets its own number
+const defaultMinRegionArea = 100
+
+// AreaThreshold is a minimum region size given either in pixels or as a percentage of the
+// image area, so one setting behaves the same at any resolution. The zero value means
+// defaultMinRegionArea pixels.
+type AreaThreshold struct {
+	Pixels  int
+	Percent float64
+}
+
+// resolve converts the threshold to a pixel count for an image of the given bounds
+func (t AreaThreshold) resolve(bounds image.Rectangle) int {
+	if t.Percent > 0 {
+		area := int(float64(bounds.Dx()*bounds.Dy()) * t.Percent / 100)
+		if area < 1 {
+			area = 1
+		}
+		return area
+	}
+	if t.Pixels > 0 {
+		return t.Pixels
+	}
+	return defaultMinRegionArea
+}
+
+// LabelAlgorithm selects how connected components of the assignment map are found
+type LabelAlgorithm int
+
+const (
+	// LabelUnionFind is a two-pass scan with union-find merging (fast, the default)
+	LabelUnionFind LabelAlgorithm = iota
+	// LabelFloodFill grows each component with an explicit stack
+	LabelFloodFill
+)
+
+// parseLabelAlgorithm converts an algorithm name to a LabelAlgorithm
+func parseLabelAlgorithm(name string) (LabelAlgorithm, bool) {
+	switch name {
+	case "", "unionfind":
+		return LabelUnionFind, true
+	case "floodfill":
+		return LabelFloodFill, true
+	}
+	return LabelUnionFind, false
+}
+
+// label assigns every pixel a component label. Labels are numbered in raster order of each
+// component's first pixel, so both algorithms produce identical output. colors and areas
+// are indexed by label.
+func (a LabelAlgorithm) label(assignment []int, width, height int) (labels, colors, areas []int) {
+	if a == LabelFloodFill {
+		return labelComponentsFloodFill(assignment, width, height)
+	}
+	return labelComponentsUnionFind(assignment, width, height)
+}
+
+// labelComponentsFloodFill labels 4-connected components by flood filling from each unlabeled pixel
+func labelComponentsFloodFill(assignment []int, width, height int) (labels, colors, areas []int) {
+	labels = make([]int, width*height)
+	for i := range labels {
+		labels[i] = -1
+	}
+
+	var stack []int
+	for start := range labels {
+		if labels[start] >= 0 {
+			continue
+		}
+
+		label := len(colors)
+		colorIdx := assignment[start]
+		labels[start] = label
+		stack = append(stack[:0], start)
+		area := 0
+
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			area++
+
+			x, y := i%width, i/width
+			neighbors := [4]int{-1, -1, -1, -1}
+			if x > 0 {
+				neighbors[0] = i - 1
+			}
+			if x < width-1 {
+				neighbors[1] = i + 1
+			}
+			if y > 0 {
+				neighbors[2] = i - width
+			}
+			if y < height-1 {
+				neighbors[3] = i + width
+			}
+
+			for _, n := range neighbors {
+				if n >= 0 && labels[n] < 0 && assignment[n] == colorIdx {
+					labels[n] = label
+					stack = append(stack, n)
+				}
+			}
+		}
+
+		colors = append(colors, colorIdx)
+		areas = append(areas, area)
+	}
+
+	return labels, colors, areas
+}
+
+// labelComponentsUnionFind labels 4-connected components in two linear passes: the first
+// gives each pixel a provisional label from its left or upper neighbor and records
+// equivalences, the second resolves them to final labels
+func labelComponentsUnionFind(assignment []int, width, height int) (labels, colors, areas []int) {
+	labels = make([]int, width*height)
+	var parent []int
+
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	// Pass 1: provisional labels and equivalences
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			colorIdx := assignment[i]
+			left := x > 0 && assignment[i-1] == colorIdx
+			up := y > 0 && assignment[i-width] == colorIdx
+
+			switch {
+			case left && up:
+				a, b := find(labels[i-1]), find(labels[i-width])
+				if a > b {
+					a, b = b, a
+				}
+				parent[b] = a
+				labels[i] = a
+			case left:
+				labels[i] = labels[i-1]
+			case up:
+				labels[i] = labels[i-width]
+			default:
+				labels[i] = len(parent)
+				parent = append(parent, len(parent))
+			}
+		}
+	}
+
+	// Pass 2: resolve to final labels numbered by first appearance
+	final := make([]int, len(parent))
+	for i := range final {
+		final[i] = -1
+	}
+	for i, provisional := range labels {
+		root := find(provisional)
+		if final[root] < 0 {
+			final[root] = len(colors)
+			colors = append(colors, assignment[i])
+			areas = append(areas, 0)
+		}
+		labels[i] = final[root]
+		areas[labels[i]]++
+	}
+
+	return labels, colors, areas
+}
+
+// buildVoronoiAssignment maps every pixel to the palette index of its nearest seed point,
+// calling report (if non-nil) with the number of rows done
+func buildVoronoiAssignment(bounds image.Rectangle, points []Point, index NearestIndex, report func(done int)) []int {
+	width := bounds.Dx()
+	assignment := make([]int, width*bounds.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			idx := (y-bounds.Min.Y)*width + (x - bounds.Min.X)
+			assignment[idx] = points[index.FindNearest(x, y)].ColorIndex
+		}
+		if report != nil {
+			report(y - bounds.Min.Y + 1)
+		}
+	}
+
+	return assignment
+}
+
+// buildLabeledRegions turns a per-pixel palette index map into numbered regions.
+// Connected components of one color are found first, then components smaller than
+// minArea are merged into their largest adjacent component of the same color, which
+// touches them only diagonally, so every returned region is big enough to carry a number.
+// Merging never crosses colors: a region's ColorIndex always matches the fill of every
+// pixel in it. Small components with no same-color neighbor are left unnumbered.
+// With keepAllColors, a color whose components are all below minArea keeps its largest
+// one anyway, so every color present in the assignment gets at least one region. Pixels
+// marked maskedOut are never merged into a region and never become one.
+func buildLabeledRegions(assignment []int, bounds image.Rectangle, palette []color.Color, minArea int, labeling LabelAlgorithm, keepAllColors bool) []Region {
+	width := bounds.Dx()
+	height := bounds.Dy()
+	if width <= 0 || height <= 0 || len(assignment) < width*height {
+		return nil
+	}
+
+	// Stage 1: label 4-connected components of equal color index
+	labels, colors, areas := labeling.label(assignment, width, height)
+
+	// Stage 2: record which components touch (8-connected, so diagonal same-color pieces are neighbors)
+	adjacency := make([]map[int]bool, len(colors))
+	addEdge := func(a, b int) {
+		if a == b {
+			return
+		}
+		if adjacency[a] == nil {
+			adjacency[a] = make(map[int]bool)
+		}
+		if adjacency[b] == nil {
+			adjacency[b] = make(map[int]bool)
+		}
+		adjacency[a][b] = true
+		adjacency[b][a] = true
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			if x < width-1 {
+				addEdge(labels[i], labels[i+1])
+			}
+			if y < height-1 {
+				addEdge(labels[i], labels[i+width])
+				if x < width-1 {
+					addEdge(labels[i], labels[i+width+1])
+				}
+				if x > 0 {
+					addEdge(labels[i], labels[i+width-1])
+				}
+			}
+		}
+	}
+
+	// Stage 3: merge sub-threshold components into a same-color neighbor, smallest first.
+	// Protected components stand in for colors that would otherwise vanish.
+	protected := make([]bool, len(colors))
+	if keepAllColors {
+		largest := make(map[int]int)
+		for c, colorIdx := range colors {
+			if best, ok := largest[colorIdx]; !ok || areas[c] > areas[best] {
+				largest[colorIdx] = c
+			}
+		}
+		for _, c := range largest {
+			protected[c] = areas[c] < minArea
+		}
+	}
+
+	parent := make([]int, len(colors))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	order := make([]int, len(colors))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return areas[order[a]] < areas[order[b]]
+	})
+
+	for _, c := range order {
+		if find(c) != c || areas[c] >= minArea || protected[c] || colors[c] == maskedOut {
+			continue
+		}
+
+		best := -1
+		for n := range adjacency[c] {
+			r := find(n)
+			if r == c || colors[r] != colors[c] {
+				continue
+			}
+			if best < 0 || betterMergeTarget(r, best, areas) {
+				best = r
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		parent[c] = best
+		areas[best] += areas[c]
+		for n := range adjacency[c] {
+			if n != best {
+				addEdge(best, n)
+			}
+		}
+	}
+
+	// Stage 4: collect pixels for each surviving component
+	regionIndex := make(map[int]int)
+	var regions []Region
+	for i, label := range labels {
+		root := find(label)
+		if (areas[root] < minArea && !protected[root]) || colors[root] == maskedOut {
+			continue
+		}
+
+		ri, ok := regionIndex[root]
+		if !ok {
+			ri = len(regions)
+			regionIndex[root] = ri
+			regions = append(regions, Region{ColorIndex: colors[root]})
+		}
+		regions[ri].Pixels = append(regions[ri].Pixels, image.Point{
+			X: i%width + bounds.Min.X,
+			Y: i/width + bounds.Min.Y,
+		})
+	}
+
+	for i := range regions {
+		sumX, sumY := 0, 0
+		for _, p := range regions[i].Pixels {
+			sumX += p.X
+			sumY += p.Y
+		}
+		regions[i].Area = len(regions[i].Pixels)
+		regions[i].Centroid = image.Point{
+			X: sumX / regions[i].Area,
+			Y: sumY / regions[i].Area,
+		}
+	}
+
+	return regions
+}
+
+// regionBounds returns the smallest rectangle containing every pixel of the region
+func regionBounds(region Region) image.Rectangle {
+	var box image.Rectangle
+	for _, p := range region.Pixels {
+		box = box.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+	}
+	return box
+}
+
+// betterMergeTarget reports whether same-color component a is a better merge target than
+// b: the larger area wins, then the lower label, so the result is deterministic
+func betterMergeTarget(a, b int, areas []int) bool {
+	if areas[a] != areas[b] {
+		return areas[a] > areas[b]
+	}
+	return a < b
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// paintRect sets the pixels of rect in a width-wide assignment to colorIdx
+func paintRect(assignment []int, width int, rect image.Rectangle, colorIdx int) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			assignment[y*width+x] = colorIdx
+		}
+	}
+}
+
+// regionAreas returns the color and area of each region, sorted by area
+func regionAreas(regions []Region) [][2]int {
+	areas := make([][2]int, len(regions))
+	for i, r := range regions {
+		areas[i] = [2]int{r.ColorIndex, r.Area}
+	}
+	sort.Slice(areas, func(a, b int) bool { return areas[a][1] < areas[b][1] })
+	return areas
+}
+
+func TestBuildLabeledRegions(t *testing.T) {
+	bounds := image.Rect(0, 0, 20, 20)
+	width := bounds.Dx()
+	assignment := make([]int, width*bounds.Dy())
+	paintRect(assignment, width, bounds, 2)                   // Background, 251 pixels
+	paintRect(assignment, width, image.Rect(0, 0, 10, 10), 0) // 100 pixels
+	paintRect(assignment, width, image.Rect(10, 10, 13, 13), 0)
+	paintRect(assignment, width, image.Rect(15, 2, 17, 4), 1)   // Small, and no other pixels of color 1
+	paintRect(assignment, width, image.Rect(14, 14, 20, 20), 0) // 36 pixels, apart from the others
+
+	regions := buildLabeledRegions(assignment, bounds, testPalette, 30, LabelUnionFind, false)
+
+	t.Run("merging", func(t *testing.T) {
+		// The 3×3 block touches the big one only at a corner and joins it
+		want := [][2]int{{0, 36}, {0, 109}, {2, 251}}
+		got := regionAreas(regions)
+		if len(got) != len(want) {
+			t.Fatalf("regions (color, area) = %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("regions (color, area) = %v, want %v", got, want)
+				break
+			}
+		}
+	})
+
+	t.Run("merging keeps fill and number together", func(t *testing.T) {
+		for _, r := range regions {
+			for _, p := range r.Pixels {
+				if c := assignment[p.Y*width+p.X]; c != r.ColorIndex {
+					t.Fatalf("region of color %d holds pixel %v of color %d", r.ColorIndex, p, c)
+				}
+			}
+		}
+	})
+
+	t.Run("thresholding", func(t *testing.T) {
+		for _, r := range regions {
+			if r.Area < 30 {
+				t.Errorf("region of color %d has area %d, below the threshold", r.ColorIndex, r.Area)
+			}
+			if r.ColorIndex == 1 {
+				t.Errorf("4-pixel island of color 1 became a region")
+			}
+		}
+
+		// Lowering the threshold numbers the island and the corner block on their own
+		low := regionAreas(buildLabeledRegions(assignment, bounds, testPalette, 4, LabelUnionFind, false))
+		want := [][2]int{{1, 4}, {0, 9}, {0, 36}, {0, 100}, {2, 251}}
+		if len(low) != len(want) {
+			t.Fatalf("with minArea 4, regions = %v, want %v", low, want)
+		}
+		for i := range want {
+			if low[i] != want[i] {
+				t.Errorf("with minArea 4, regions = %v, want %v", low, want)
+				break
+			}
+		}
+	})
+
+	t.Run("connectivity", func(t *testing.T) {
+		// Same-color blocks split by another color stay separate regions, each connected (the
+		// diagonal merge included)
+		for _, r := range regions {
+			in := make(map[image.Point]bool, len(r.Pixels))
+			for _, p := range r.Pixels {
+				in[p] = true
+			}
+			seen := map[image.Point]bool{r.Pixels[0]: true}
+			stack := []image.Point{r.Pixels[0]}
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for _, d := range []image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {-1, -1}, {1, -1}, {-1, 1}} {
+					if n := p.Add(d); in[n] && !seen[n] {
+						seen[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+			if len(seen) != len(r.Pixels) {
+				t.Errorf("region of color %d with %d pixels is not connected (%d reachable)", r.ColorIndex, len(r.Pixels), len(seen))
+			}
+		}
+	})
+}
+
+func TestBuildLabeledRegionsSkipsMaskedOut(t *testing.T) {
+	bounds := image.Rect(0, 0, 20, 10)
+	assignment := stripeAssignment(bounds.Dx(), bounds.Dy(), 0, maskedOut)
+
+	regions := buildLabeledRegions(assignment, bounds, testPalette, 10, LabelUnionFind, false)
+	if len(regions) != 1 || regions[0].ColorIndex != 0 || regions[0].Area != 100 {
+		t.Errorf("regions = %v, want one region of color 0 and area 100", regionAreas(regions))
+	}
+}
+
+func TestRelativeAreaThresholdScalesWithImage(t *testing.T) {
+	threshold := AreaThreshold{Percent: 0.1}
+
+	for _, size := range []int{256, 2048} {
+		bounds := image.Rect(0, 0, size, size)
+		minArea := threshold.resolve(bounds)
+		if want := size * size / 1000; minArea != want {
+			t.Errorf("%dpx: 0.1%% resolved to %d pixels, want %d", size, minArea, want)
+		}
+
+		// The same two islands at each scale: 0.098% of the image drops, 0.12% is numbered
+		assignment := make([]int, size*size)
+		small, large := size/32, size*9/256
+		paintRect(assignment, size, image.Rect(0, 0, small, small).Add(image.Pt(size/8, size/8)), 1)
+		paintRect(assignment, size, image.Rect(0, 0, large, large).Add(image.Pt(size/2, size/2)), 2)
+
+		var numbered []int
+		for _, r := range buildLabeledRegions(assignment, bounds, testPalette, minArea, LabelUnionFind, false) {
+			if r.ColorIndex != 0 {
+				numbered = append(numbered, r.ColorIndex)
+			}
+		}
+		if len(numbered) != 1 || numbered[0] != 2 {
+			t.Errorf("%dpx: numbered islands of colors %v, want only color 2", size, numbered)
+		}
+	}
+}
+
+// randomAssignment paints random rectangles of numColors colors over a width×height map,
+// giving components of many shapes and sizes
+func randomAssignment(rng *rand.Rand, width, height, numColors, rects int) []int {
+	assignment := make([]int, width*height)
+	for i := 0; i < rects; i++ {
+		x, y := rng.Intn(width), rng.Intn(height)
+		rect := image.Rect(x, y, x+1+rng.Intn(width/4+1), y+1+rng.Intn(height/4+1)).Intersect(image.Rect(0, 0, width, height))
+		paintRect(assignment, width, rect, rng.Intn(numColors))
+	}
+	return assignment
+}
+
+func TestUnionFindLabelingMatchesFloodFill(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		width, height := 1+rng.Intn(80), 1+rng.Intn(80)
+		assignment := randomAssignment(rng, width, height, 2+rng.Intn(4), rng.Intn(60))
+		if trial%5 == 0 {
+			// Pixel noise: the most components and the most merges
+			for i := range assignment {
+				assignment[i] = rng.Intn(3)
+			}
+		}
+
+		wantLabels, wantColors, wantAreas := labelComponentsFloodFill(assignment, width, height)
+		labels, colors, areas := labelComponentsUnionFind(assignment, width, height)
+		if !slices.Equal(labels, wantLabels) || !slices.Equal(colors, wantColors) || !slices.Equal(areas, wantAreas) {
+			t.Fatalf("trial %d (%dx%d): union-find found %d components, flood fill %d, or labeled them differently",
+				trial, width, height, len(colors), len(wantColors))
+		}
+	}
+}
+
+func BenchmarkLabelComponents(b *testing.B) {
+	const width, height = 1024, 768
+	assignment := randomAssignment(rand.New(rand.NewSource(1)), width, height, 12, 3000)
+	for _, name := range []string{"unionfind", "floodfill"} {
+		algorithm, _ := parseLabelAlgorithm(name)
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				algorithm.label(assignment, width, height)
+			}
+		})
+	}
+}
+
+func TestPruneUnusedColors(t *testing.T) {
+	// Color 1 only appears as a 2×2 speck, below the area threshold
+	bounds := image.Rect(0, 0, 20, 20)
+	assignment := stripeAssignment(20, 20, 0, 2)
+	paintRect(assignment, 20, image.Rect(4, 4, 6, 6), 1)
+
+	for _, tc := range []struct {
+		keepAllColors bool
+		want          []color.Color
+	}{
+		{false, []color.Color{testPalette[0], testPalette[2]}},                // Pruned from the legend
+		{true, []color.Color{testPalette[0], testPalette[1], testPalette[2]}}, // Forced a region
+	} {
+		regions := buildLabeledRegions(assignment, bounds, testPalette[:3], 30, LabelUnionFind, tc.keepAllColors)
+		palette := compactLabelNumbering(regions, testPalette[:3])
+		if !slices.Equal(palette, tc.want) {
+			t.Errorf("keepAllColors=%v: legend %v, want %v", tc.keepAllColors, palette, tc.want)
+		}
+		if tc.keepAllColors {
+			speck := false
+			for _, r := range regions {
+				speck = speck || (colorsEqual(palette[r.ColorIndex], testPalette[1]) && r.Area == 4)
+			}
+			if !speck {
+				t.Errorf("keepAllColors: no region for the speck of color 1 in %v", regionAreas(regions))
+			}
+		}
+	}
+}
+
+func TestDespeckleReassignsStrayPixel(t *testing.T) {
+	bounds := image.Rect(0, 0, 12, 10)
+	assignment := make([]int, 12*10)
+	paintRect(assignment, 12, image.Rect(6, 0, 12, 10), 1)
+	assignment[4*12+2] = 2 // A stray pixel inside color 0
+
+	regionsBefore := len(buildLabeledRegions(assignment, bounds, testPalette, 1, LabelUnionFind, false))
+	if changed := despeckleAssignment(assignment, bounds, 1); changed != 1 {
+		t.Errorf("despeckle changed %d pixels, want 1", changed)
+	}
+	if got := assignment[4*12+2]; got != 0 {
+		t.Errorf("stray pixel became color %d, want its surroundings' 0", got)
+	}
+	if regionsAfter := len(buildLabeledRegions(assignment, bounds, testPalette, 1, LabelUnionFind, false)); regionsAfter != regionsBefore-1 {
+		t.Errorf("%d regions after despeckling, want one fewer than %d", regionsAfter, regionsBefore)
+	}
+
+	// The straight boundary between the two halves is left alone
+	for y := 0; y < 10; y++ {
+		if assignment[y*12+5] != 0 || assignment[y*12+6] != 1 {
+			t.Fatalf("row %d of the boundary moved", y)
+		}
+	}
+}
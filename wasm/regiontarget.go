@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+const (
+	// maxRegionTrials caps the trial layouts tunePointsForRegions makes
+	maxRegionTrials = 6
+	// regionTolerance is how far from the target region count, as a fraction, is close enough
+	regionTolerance = 0.1
+)
+
+// regionCount is how many numbered regions the layout's sheet has
+func (l *sheetLayout) regionCount() int {
+	return len(buildLabeledRegions(l.assignment(), l.bounds, l.palette, l.minArea, l.labeling, l.keepAllColors))
+}
+
+// tunePointsForRegions searches for the Voronoi seed point count whose sheet of img has
+// about target numbered regions, since merging same-colored cells and dropping small ones
+// make the count hard to predict from the points. Each trial lays the image out with
+// opts.Seed and counts its regions; the next guess scales the point count by how far off
+// that was, kept inside the range already bracketed by earlier trials. It stops within
+// regionTolerance or after maxRegionTrials, returning the closest point count, its region
+// count and its layout.
+func tunePointsForRegions(img image.Image, target, numColors int, opts ProcessOptions) (int, int, *sheetLayout) {
+	progress := opts.Progress
+	opts.Progress = nil
+
+	lo, hi := minSheetPoints, maxSheetPoints
+	points := clampPoints(target * 2)
+	bestPoints, bestRegions, bestError := 0, 0, math.Inf(1)
+	var best *sheetLayout
+
+	for trial := 0; trial < maxRegionTrials; trial++ {
+		layout := prepareLayout(img, points, numColors, true, opts)
+		regions := layout.regionCount()
+
+		miss := math.Abs(float64(regions-target)) / float64(target)
+		if miss < bestError {
+			bestPoints, bestRegions, bestError, best = points, regions, miss, layout
+		}
+		if miss <= regionTolerance {
+			break
+		}
+
+		if regions < target {
+			lo = points
+		} else {
+			hi = points
+		}
+		if hi-lo <= 1 {
+			break
+		}
+
+		// Regions grow roughly in proportion to points; past the bracket, split it instead
+		next := int(math.Round(float64(points) * float64(target) / math.Max(float64(regions), 1)))
+		if next <= lo || next >= hi {
+			next = int(math.Round(math.Sqrt(float64(lo) * float64(hi))))
+		}
+		if next == points {
+			break
+		}
+		points = next
+	}
+
+	best.progress = progress
+	return bestPoints, bestRegions, best
+}
+
+// regionTargetWarning returns a warning when the tuned sheet's region count missed the
+// target by more than regionTolerance, or "" if it came close enough
+func regionTargetWarning(target, regions, points int) string {
+	if math.Abs(float64(regions-target)) <= regionTolerance*float64(target) {
+		return ""
+	}
+	return fmt.Sprintf("Asked for about %d regions; the closest sheet found has %d, from %d points. Changing the color count or minimum region area moves how many regions the image can give.",
+		target, regions, points)
+}
+
+// clampPoints limits a seed point count to the range processImage accepts
+func clampPoints(points int) int {
+	return int(math.Min(math.Max(float64(points), minSheetPoints), maxSheetPoints))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTargetRegionsLandsWithinTwentyPercent(t *testing.T) {
+	// A patchwork of small tiles, so the region count keeps growing with the point count
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for ty := 0; ty < 256; ty += 16 {
+		for tx := 0; tx < 256; tx += 16 {
+			c := testPalette[rng.Intn(len(testPalette))].(color.RGBA)
+			for y := ty; y < ty+16; y++ {
+				for x := tx; x < tx+16; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	const target = 100
+	result := mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"targetRegions": target, "regionsByColor": true, "seed": 5})
+	regions := 0
+	for _, c := range result.RegionsByColor {
+		regions += len(c.Regions)
+	}
+	if math.Abs(float64(regions-target)) > 0.2*target {
+		t.Errorf("targetRegions %d gave %d regions (%s)", target, regions, result.Warning)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"syscall/js"
+	"time"
+)
+
+// selfTestSize is the side of the synthetic image the self-test converts
+const selfTestSize = 160
+
+// SelfTestResult reports the outcome of runSelfTest to JavaScript
+type SelfTestResult struct {
+	OK          bool    `json:"ok"`
+	Error       string  `json:"error,omitempty"`
+	TotalMillis float64 `json:"totalMs"`
+}
+
+// runSelfTest pushes a small synthetic image through every decoder and both layouts and
+// checks that a sheet, numbers, exact palette fills and a legend come out, returning the
+// first failure. It catches broken builds (a decoder not linked in, a missing glyph) and
+// draw steps that blend colors before users do.
+func runSelfTest() error {
+	img := syntheticImage(selfTestSize)
+
+	// Every format processImage accepts must round-trip through image.Decode
+	encoders := []struct {
+		format string
+		encode func(io.Writer, image.Image) error
+	}{
+		{"png", png.Encode},
+		{"jpeg", func(w io.Writer, m image.Image) error { return jpeg.Encode(w, m, nil) }},
+		{"gif", func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) }},
+	}
+	for _, e := range encoders {
+		var buf bytes.Buffer
+		if err := e.encode(&buf, img); err != nil {
+			return fmt.Errorf("encoding %s: %v", e.format, err)
+		}
+		decoded, format, err := image.Decode(&buf)
+		if err != nil {
+			return fmt.Errorf("decoding %s: %v", e.format, err)
+		}
+		if format != e.format || decoded.Bounds() != img.Bounds() {
+			return fmt.Errorf("decoding %s gave a %s image of %v", e.format, format, decoded.Bounds())
+		}
+	}
+
+	for digit := 0; digit <= 9; digit++ {
+		if len(digitPixels(digit, 0, 0, 1)) == 0 {
+			return fmt.Errorf("digit %d has no glyph", digit)
+		}
+	}
+
+	for _, useVoronoi := range []bool{true, false} {
+		mode := "grid"
+		if useVoronoi {
+			mode = "voronoi"
+		}
+
+		layout := prepareLayout(img, 200, 8, useVoronoi, ProcessOptions{Seed: 1})
+		sheet, palette := layout.render(1, false)
+		if sheet.Bounds() != img.Bounds() {
+			return fmt.Errorf("%s sheet is %v, expected %v", mode, sheet.Bounds(), img.Bounds())
+		}
+		if len(palette) < 2 {
+			return fmt.Errorf("%s sheet has %d numbered colors", mode, len(palette))
+		}
+
+		labels := layout.renderLabels(1)
+		drawn := false
+		for i := 3; i < len(labels.Pix); i += 4 {
+			if labels.Pix[i] != 0 {
+				drawn = true
+				break
+			}
+		}
+		if !drawn {
+			return fmt.Errorf("%s sheet has no numbers", mode)
+		}
+
+		// Colored fills must be exact palette colors; only borders and numbers may differ
+		colored, coloredPalette := layout.render(1, true)
+		if n := offPalettePixels(colored, labels, coloredPalette); n > 0 {
+			return fmt.Errorf("%s colored sheet has %d pixels outside the palette", mode, n)
+		}
+
+		if legend := renderLegend(palette); legend.Bounds().Empty() {
+			return fmt.Errorf("%s legend is empty", mode)
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, sheet); err != nil {
+			return fmt.Errorf("encoding %s sheet: %v", mode, err)
+		}
+	}
+
+	return nil
+}
+
+// offPalettePixels counts the pixels of sheet that are neither a palette color, black
+// border, nor covered by a number in labels, which would mean a resize or draw step blended
+// colors the painter has no paint for
+func offPalettePixels(sheet image.Image, labels *image.RGBA, palette []color.Color) int {
+	allowed := map[color.RGBA]bool{{0, 0, 0, 255}: true}
+	for _, c := range palette {
+		allowed[color.RGBAModel.Convert(c).(color.RGBA)] = true
+	}
+
+	count := 0
+	bounds := sheet.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if labels.RGBAAt(x, y).A != 0 {
+				continue
+			}
+			if !allowed[color.RGBAModel.Convert(sheet.At(x, y)).(color.RGBA)] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// selfTest is called from JavaScript and returns a JSON SelfTestResult
+func selfTest(this js.Value, args []js.Value) interface{} {
+	start := time.Now()
+	err := runSelfTest()
+
+	result := SelfTestResult{
+		OK:          err == nil,
+		TotalMillis: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	jsonBytes, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		return createErrorResult(conversionError(ErrInternal, "Failed to marshal JSON: %v", marshalErr))
+	}
+	return string(jsonBytes)
+}
+
+// syntheticImage draws a deterministic test picture: a diagonal gradient background with
+// flat rectangles and discs, so edge detection, clustering and labeling all have work to do
+func syntheticImage(size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(255 * x / size),
+				G: uint8(255 * y / size),
+				B: uint8(255 * (x + y) / (2 * size)),
+				A: 255,
+			})
+		}
+	}
+
+	shapes := []color.RGBA{
+		{220, 40, 40, 255},
+		{40, 160, 60, 255},
+		{30, 60, 200, 255},
+		{240, 200, 40, 255},
+		{20, 20, 20, 255},
+	}
+	step := size / 6
+	for i, c := range shapes {
+		x0 := step/2 + i*step
+		for y := step; y < size-step; y++ {
+			for x := x0; x < x0+step/2; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+
+		cx, cy, r := x0+step/4, step/2+(i%2)*(size-step), step/3
+		for y := cy - r; y <= cy+r; y++ {
+			for x := cx - r; x <= cx+r; x++ {
+				if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r && image.Pt(x, y).In(img.Bounds()) {
+					img.SetRGBA(x, y, shapes[(i+2)%len(shapes)])
+				}
+			}
+		}
+	}
+
+	return img
+}
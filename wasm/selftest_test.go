@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"syscall/js"
+	"testing"
+)
+
+func TestSelfTestPasses(t *testing.T) {
+	if err := runSelfTest(); err != nil {
+		t.Fatalf("runSelfTest: %v", err)
+	}
+
+	var result SelfTestResult
+	if err := json.Unmarshal([]byte(selfTest(js.Undefined(), nil).(string)), &result); err != nil {
+		t.Fatalf("selfTest returned invalid JSON: %v", err)
+	}
+	if !result.OK || result.Error != "" || result.TotalMillis <= 0 {
+		t.Errorf("selfTest = %+v, want ok with a positive duration", result)
+	}
+}
+
+func TestColoredSheetUsesOnlyExactPaletteColors(t *testing.T) {
+	// Larger than maxDimension, so the resize runs too
+	for _, useVoronoi := range []bool{true, false} {
+		args := testSheetArgs
+		args.showColors = true
+		args.useVoronoi = useVoronoi
+		result := mustProcessImage(t, syntheticImage(400), args, map[string]interface{}{"labelLayer": true, "seed": 4})
+
+		sheet := decodeBase64PNG(t, result.Image)
+		labels := image.NewRGBA(sheet.Bounds())
+		draw.Draw(labels, labels.Bounds(), decodeBase64PNG(t, result.LabelLayer), image.Point{}, draw.Src)
+		var palette []color.Color
+		for _, c := range result.Palette {
+			palette = append(palette, color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255})
+		}
+
+		if n := offPalettePixels(sheet, labels, palette); n > 0 {
+			t.Errorf("useVoronoi=%v: %d interior pixels are not exactly a palette color", useVoronoi, n)
+		}
+	}
+}
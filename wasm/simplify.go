@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// At simplify 100, palette colors closer than maxSimplifyMerge (CIEDE2000) are merged and
+// regions under maxSimplifyArea percent of the image lose their numbers; both scale down
+// linearly with the level, as does the despeckle strength from maxDespeckle
+const (
+	maxSimplifyMerge = 20.0
+	maxSimplifyArea  = 0.5
+)
+
+// simplified folds the Simplify level (0-100) into the individual simplification passes
+// for an image of the given bounds: merging near-duplicate palette colors, numbering only
+// larger regions and, in grid mode, despeckling. A pass the caller already set more
+// strongly is left alone, as is an explicit minimum region area. Level 0 returns the
+// options unchanged.
+func (o ProcessOptions) simplified(bounds image.Rectangle) ProcessOptions {
+	if o.Simplify <= 0 {
+		return o
+	}
+	level := math.Min(float64(o.Simplify), 100) / 100
+
+	if merge := level * maxSimplifyMerge; merge > o.MergeDistance {
+		o.MergeDistance = merge
+	}
+	if o.MinRegionArea == (AreaThreshold{}) {
+		area := int(float64(bounds.Dx()*bounds.Dy()) * level * maxSimplifyArea / 100)
+		if area > defaultMinRegionArea {
+			o.MinRegionArea = AreaThreshold{Pixels: area}
+		}
+	}
+	if despeckle := int(math.Round(level * maxDespeckle)); despeckle > o.Despeckle {
+		o.Despeckle = despeckle
+	}
+	return o
+}
+
+// mergeSimilarColors repeatedly replaces the two closest palette colors with their average
+// while they are within distance (CIEDE2000) of each other, so a palette never asks for
+// two paints the eye can barely tell apart. Distance 0 returns the palette unchanged.
+func mergeSimilarColors(palette []color.Color, distance float64) []color.Color {
+	if distance <= 0 || len(palette) < 2 {
+		return palette
+	}
+
+	merged := make([]color.Color, len(palette))
+	copy(merged, palette)
+	for len(merged) > 1 {
+		bestI, bestJ, best := -1, -1, distance
+		for i := range merged {
+			for j := i + 1; j < len(merged); j++ {
+				if d := MetricCIEDE2000.distance(merged[i], merged[j]); d < best {
+					bestI, bestJ, best = i, j, d
+				}
+			}
+		}
+		if bestI < 0 {
+			break
+		}
+
+		a := color.RGBAModel.Convert(merged[bestI]).(color.RGBA)
+		b := color.RGBAModel.Convert(merged[bestJ]).(color.RGBA)
+		merged[bestI] = color.RGBA{
+			R: uint8((int(a.R) + int(b.R) + 1) / 2),
+			G: uint8((int(a.G) + int(b.G) + 1) / 2),
+			B: uint8((int(a.B) + int(b.B) + 1) / 2),
+			A: 255,
+		}
+		merged = append(merged[:bestJ], merged[bestJ+1:]...)
+	}
+	return merged
+}
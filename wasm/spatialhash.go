@@ -0,0 +1,201 @@
+package main
+
+import "math"
+
+// NearestIndex finds the seed point that owns a pixel; KDTree and SpatialHash both
+// return the same answers, ties included
+type NearestIndex interface {
+	FindNearest(x, y int) int
+}
+
+// PointIndex selects the structure that answers nearest-seed lookups
+type PointIndex int
+
+const (
+	// PointIndexAuto uses a spatial hash for uniformly distributed points and a k-d tree
+	// otherwise (the default)
+	PointIndexAuto PointIndex = iota
+	// PointIndexKDTree always uses a k-d tree, which adapts to clustered points
+	PointIndexKDTree
+	// PointIndexHash always uses a spatial hash, fastest when points are spread evenly
+	PointIndexHash
+)
+
+// parsePointIndex converts a point index name to a PointIndex
+func parsePointIndex(name string) (PointIndex, bool) {
+	switch name {
+	case "", "auto":
+		return PointIndexAuto, true
+	case "kdtree":
+		return PointIndexKDTree, true
+	case "hash":
+		return PointIndexHash, true
+	}
+	return PointIndexAuto, false
+}
+
+// resolve picks the concrete index for points placed by distribution
+func (k PointIndex) resolve(distribution PointDistribution) PointIndex {
+	if k != PointIndexAuto {
+		return k
+	}
+	if distribution == DistributionUniform {
+		return PointIndexHash
+	}
+	return PointIndexKDTree
+}
+
+// build indexes points for nearest-seed lookups under metric
+func (k PointIndex) build(points []Point, metric CellMetric) NearestIndex {
+	if k == PointIndexHash {
+		return NewSpatialHashWithMetric(points, metric)
+	}
+	return NewKDTreeWithMetric(points, metric)
+}
+
+// SpatialHash bins points into a uniform grid of square cells sized so each holds about
+// one point, and answers nearest neighbor queries by searching rings of cells outward
+// from the query's cell. Lookups cost O(1) when points are spread evenly, where a k-d
+// tree pays O(log n) per query.
+type SpatialHash struct {
+	minX, minY int
+	cellSize   int
+	cols, rows int
+	cellStart  []int       // Offsets into entries per cell, with one extra at the end
+	entries    []hashEntry // Points grouped by cell
+	metric     CellMetric
+}
+
+type hashEntry struct {
+	x, y, index int
+}
+
+// NewSpatialHash builds a spatial hash from a slice of points
+func NewSpatialHash(points []Point) *SpatialHash {
+	return NewSpatialHashWithMetric(points, CellEuclidean)
+}
+
+// NewSpatialHashWithMetric builds a spatial hash whose nearest neighbor searches use the
+// given metric
+func NewSpatialHashWithMetric(points []Point, metric CellMetric) *SpatialHash {
+	h := &SpatialHash{metric: metric}
+	if len(points) == 0 {
+		return h
+	}
+
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+	for _, p := range points[1:] {
+		if p.X < minX {
+			minX = p.X
+		} else if p.X > maxX {
+			maxX = p.X
+		}
+		if p.Y < minY {
+			minY = p.Y
+		} else if p.Y > maxY {
+			maxY = p.Y
+		}
+	}
+	h.minX, h.minY = minX, minY
+	width, height := maxX-minX+1, maxY-minY+1
+
+	// About one point per cell: the expected spacing of evenly spread points
+	h.cellSize = int(math.Ceil(math.Sqrt(float64(width*height) / float64(len(points)))))
+	if h.cellSize < 1 {
+		h.cellSize = 1
+	}
+	h.cols = (width + h.cellSize - 1) / h.cellSize
+	h.rows = (height + h.cellSize - 1) / h.cellSize
+
+	// Counting sort of the points by cell
+	h.cellStart = make([]int, h.cols*h.rows+1)
+	for _, p := range points {
+		h.cellStart[h.cellOf(p.X, p.Y)+1]++
+	}
+	for i := 1; i < len(h.cellStart); i++ {
+		h.cellStart[i] += h.cellStart[i-1]
+	}
+	next := make([]int, h.cols*h.rows)
+	copy(next, h.cellStart)
+	h.entries = make([]hashEntry, len(points))
+	for _, p := range points {
+		c := h.cellOf(p.X, p.Y)
+		h.entries[next[c]] = hashEntry{x: p.X, y: p.Y, index: p.Index}
+		next[c]++
+	}
+	return h
+}
+
+// cellOf returns the cell holding a point inside the grid
+func (h *SpatialHash) cellOf(x, y int) int {
+	return ((y-h.minY)/h.cellSize)*h.cols + (x-h.minX)/h.cellSize
+}
+
+// FindNearest returns the index of the nearest point to (x, y). Ties go to the lowest
+// index, matching KDTree and a linear scan.
+func (h *SpatialHash) FindNearest(x, y int) int {
+	if len(h.entries) == 0 {
+		return 0
+	}
+
+	// Start from the cell nearest the query, which may lie outside the grid
+	cx := h.clampCell(floorDiv(x-h.minX, h.cellSize), h.cols)
+	cy := h.clampCell(floorDiv(y-h.minY, h.cellSize), h.rows)
+
+	best, bestDist := -1, math.Inf(1)
+	for r := 0; ; r++ {
+		for gy := cy - r; gy <= cy+r; gy++ {
+			if gy < 0 || gy >= h.rows {
+				continue
+			}
+			// Interior rows of the ring only have their two end cells
+			step := 2 * r
+			if gy == cy-r || gy == cy+r || r == 0 {
+				step = 1
+			}
+			for gx := cx - r; gx <= cx+r; gx += step {
+				if gx < 0 || gx >= h.cols {
+					continue
+				}
+				cell := gy*h.cols + gx
+				for _, e := range h.entries[h.cellStart[cell]:h.cellStart[cell+1]] {
+					d := h.metric.distance(x, y, e.x, e.y)
+					if d < bestDist || (d == bestDist && e.index < best) {
+						best, bestDist = e.index, d
+					}
+				}
+			}
+		}
+
+		if cx-r <= 0 && cy-r <= 0 && cx+r >= h.cols-1 && cy+r >= h.rows-1 {
+			return best
+		}
+
+		// Every point beyond this ring lies at least gap pixels away on one axis, counting
+		// only the sides where the grid goes on
+		gap := math.MaxInt
+		if cx-r > 0 {
+			gap = min(gap, x-(h.minX+(cx-r)*h.cellSize)+1)
+		}
+		if cx+r < h.cols-1 {
+			gap = min(gap, h.minX+(cx+r+1)*h.cellSize-x)
+		}
+		if cy-r > 0 {
+			gap = min(gap, y-(h.minY+(cy-r)*h.cellSize)+1)
+		}
+		if cy+r < h.rows-1 {
+			gap = min(gap, h.minY+(cy+r+1)*h.cellSize-y)
+		}
+		if best >= 0 && gap > 0 && h.metric.planeDistance(gap) > bestDist {
+			return best
+		}
+	}
+}
+
+// clampCell limits a cell coordinate to the n cells of the grid's row or column
+func (h *SpatialHash) clampCell(c, n int) int {
+	if c < 0 {
+		return 0
+	}
+	return min(c, n-1)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSpatialHashMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	uniform := randomPoints(rng, 400, 300)
+	// A tight clump in one corner leaves most cells empty, so searches must widen
+	clumped := randomPoints(rng, 60, 20)
+
+	for name, points := range map[string][]Point{"uniform": uniform, "clumped": clumped} {
+		for _, metric := range []CellMetric{CellEuclidean, CellManhattan, CellChebyshev} {
+			hash := NewSpatialHashWithMetric(points, metric)
+			for q := 0; q < 2000; q++ {
+				x, y := rng.Intn(300), rng.Intn(300)
+				got, want := hash.FindNearest(x, y), findNearestPointWithMetric(x, y, points, metric)
+				if got != want && metric.distance(x, y, points[got].X, points[got].Y) != metric.distance(x, y, points[want].X, points[want].Y) {
+					t.Fatalf("%s, metric %d: FindNearest(%d, %d) = %d, brute force %d", name, metric, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkNearestIndex looks up every pixel of a 512×512 sheet among 5000 uniform points
+func BenchmarkNearestIndex(b *testing.B) {
+	const size = 512
+	points := randomPoints(rand.New(rand.NewSource(1)), 5000, size)
+	for _, bc := range []struct {
+		name  string
+		index NearestIndex
+	}{
+		{"kdtree", NewKDTree(points)},
+		{"hash", NewSpatialHash(points)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						bc.index.FindNearest(x, y)
+					}
+				}
+			}
+		})
+	}
+}
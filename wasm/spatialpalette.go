@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+// spatialFeature maps a color and its position to one clustering vector. Position is
+// rescaled so the longer image side spans the same 0-255 range as a color channel, then
+// multiplied by weight, so weight 1 makes crossing the image cost as much as black to white.
+func spatialFeature(c color.Color, p image.Point, bounds image.Rectangle, weight float64) []float64 {
+	r, g, b, _ := c.RGBA()
+	side := bounds.Dx()
+	if bounds.Dy() > side {
+		side = bounds.Dy()
+	}
+	scale := 255.0 * weight / float64(side)
+	return []float64{
+		float64(r >> 8),
+		float64(g >> 8),
+		float64(b >> 8),
+		float64(p.X-bounds.Min.X) * scale,
+		float64(p.Y-bounds.Min.Y) * scale,
+	}
+}
+
+// generateSpatialPalette clusters colors together with their positions, so similar colors
+// in separate parts of the image can land in separate clusters. Clusters that end up with
+// exactly the same color are collapsed, since they would be indistinguishable on the sheet.
+func generateSpatialPalette(ctx context.Context, img image.Image, numColors int, spatialWeight float64, step, maxSamples int, rng *rand.Rand) []color.Color {
+	bounds := img.Bounds()
+
+	// Sample colors from the image
+	positions := paletteSamplePoints(bounds, step, maxSamples, rng)
+	colors := make([]color.Color, len(positions))
+	for i, p := range positions {
+		colors[i] = img.At(p.X, p.Y)
+	}
+
+	var palette []color.Color
+	for _, c := range kMeansClusteringSpatial(ctx, colors, positions, bounds, numColors, spatialWeight, rng) {
+		duplicate := false
+		for _, existing := range palette {
+			if colorsEqual(existing, c) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			palette = append(palette, c)
+		}
+	}
+	return palette
+}
+
+// kMeansClusteringSpatial runs k-means++ on combined color+position features and returns
+// the color part of each centroid. A spatialWeight of 0 clusters on color alone. Once ctx
+// is done it returns the centroids reached so far.
+func kMeansClusteringSpatial(ctx context.Context, colors []color.Color, positions []image.Point, bounds image.Rectangle, k int, spatialWeight float64, rng *rand.Rand) []color.Color {
+	if len(colors) == 0 {
+		return []color.Color{color.RGBA{128, 128, 128, 255}}
+	}
+	if distinct := distinctColors(colors, k); distinct != nil {
+		return distinct
+	}
+
+	samples := make([][]float64, len(colors))
+	for i, c := range colors {
+		samples[i] = spatialFeature(c, positions[i], bounds, spatialWeight)
+	}
+	centroids := vectorKMeans(ctx, samples, nil, k, vectorDistanceSquared, rng)
+
+	palette := make([]color.Color, len(centroids))
+	for i, c := range centroids {
+		palette[i] = vectorColor(c)
+	}
+	return palette
+}
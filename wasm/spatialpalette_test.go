@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSpatialWeightSeparatesDistantBlobs(t *testing.T) {
+	// Two nearly identical red blobs, one on a blue and one on a green vertical gradient.
+	// On color alone the gradients vary far more than the reds, so k-means spends its
+	// clusters on them; position tells the blobs apart.
+	redA, redB := color.RGBA{200, 40, 40, 255}, color.RGBA{206, 44, 38, 255}
+	leftBlob, rightBlob := image.Rect(20, 20, 80, 80), image.Rect(120, 20, 180, 80)
+	rng := rand.New(rand.NewSource(4))
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			shade := uint8(y * 6 / 5)
+			c := color.RGBA{30, 20 + shade/2, 120 + shade, 255}
+			if x >= 100 {
+				c = color.RGBA{40, 100 + shade, 30 + shade/2, 255}
+			}
+			if p := image.Pt(x, y); p.In(leftBlob) {
+				c = redA
+			} else if p.In(rightBlob) {
+				c = redB
+			}
+			noise := uint8(rng.Intn(3))
+			img.SetRGBA(x, y, color.RGBA{c.R + noise, c.G + noise, c.B + noise, 255})
+		}
+	}
+
+	// majority returns the palette entry most pixels of blob quantize to
+	majority := func(blob image.Rectangle, palette []color.Color) int {
+		counts := make([]int, len(palette))
+		for y := blob.Min.Y; y < blob.Max.Y; y++ {
+			for x := blob.Min.X; x < blob.Max.X; x++ {
+				counts[findNearestColor(img.At(x, y), palette)]++
+			}
+		}
+		best := 0
+		for i, n := range counts {
+			if n > counts[best] {
+				best = i
+			}
+		}
+		return best
+	}
+
+	for _, tc := range []struct {
+		weight   float64
+		separate bool
+	}{
+		{0, false},
+		{1, true},
+	} {
+		palette := generateSpatialPalette(context.Background(), img, 4, tc.weight, 2, 0, rand.New(rand.NewSource(1)))
+		left, right := majority(leftBlob, palette), majority(rightBlob, palette)
+		if (left != right) != tc.separate {
+			t.Errorf("spatial weight %v: blobs quantize to palette colors %d and %d of %v, want separate=%v",
+				tc.weight, left, right, palette, tc.separate)
+		}
+	}
+}
+
+func TestCancelledSpatialPaletteSkipsSeeding(t *testing.T) {
+	img := syntheticImage(512)
+	const k = 24
+
+	started := time.Now()
+	generateSpatialPalette(context.Background(), img, k, 1, 1, 0, rand.New(rand.NewSource(1)))
+	full := time.Since(started)
+
+	// Cancelled up front, no k-means++ distance pass runs over the samples
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started = time.Now()
+	palette := generateSpatialPalette(ctx, img, k, 1, 1, 0, rand.New(rand.NewSource(1)))
+	if elapsed := time.Since(started); elapsed > full/4 {
+		t.Errorf("cancelled spatial palette took %v, full run %v", elapsed, full)
+	}
+	if len(palette) == 0 {
+		t.Error("cancelled spatial palette is empty")
+	}
+}
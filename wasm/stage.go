@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// PipelineStage selects how far render takes a sheet, for seeing what each step adds
+type PipelineStage int
+
+const (
+	// StageFinal renders the finished sheet (the default)
+	StageFinal PipelineStage = iota
+	// StageRaw fills each Voronoi cell with the image color its seed point was sampled from,
+	// before quantizing to the palette; grid layouts show the analyzed image itself
+	StageRaw
+	// StageQuantized fills the regions with their palette colors, without borders or numbers
+	StageQuantized
+	// StageBorders adds the region borders but no numbers
+	StageBorders
+)
+
+// parsePipelineStage converts a stage name to a PipelineStage
+func parsePipelineStage(name string) (PipelineStage, bool) {
+	switch name {
+	case "", "final":
+		return StageFinal, true
+	case "raw":
+		return StageRaw, true
+	case "quantized":
+		return StageQuantized, true
+	case "borders":
+		return StageBorders, true
+	}
+	return StageFinal, false
+}
+
+// stageSource returns img when stage needs the analyzed image to render, nil otherwise
+func stageSource(img image.Image, stage PipelineStage) image.Image {
+	if stage != StageRaw {
+		return nil
+	}
+	return img
+}
+
+// renderRaw draws the StageRaw view of the layout: Voronoi cells in their seed points'
+// unquantized colors, or for grid layouts the analyzed image as is. Pixels outside the
+// mask still take the mask background.
+func (l *sheetLayout) renderRaw() *image.RGBA {
+	var result *image.RGBA
+	if l.points == nil {
+		result = image.NewRGBA(l.bounds)
+		draw.Draw(result, l.bounds, l.source, l.bounds.Min, draw.Src)
+	} else {
+		raw := make([]Point, len(l.points))
+		for i, p := range l.points {
+			raw[i] = p
+			raw[i].Color = l.source.At(p.X, p.Y)
+		}
+		result, _ = createVoronoiDiagramWithMetric(l.bounds, raw, l.cellMetric, l.pointIndex, l.fill, nil)
+	}
+	l.applyMask(result, 0)
+	return result
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestStagesAddFeaturesInOrder(t *testing.T) {
+	img := syntheticImage(160)
+	args := testSheetArgs
+	args.showColors = true
+
+	type features struct{ colors, offPalette, black int }
+	seen := make(map[string]features)
+	for _, stage := range []string{"raw", "quantized", "borders", "final"} {
+		result := mustProcessImage(t, img, args, map[string]interface{}{"stage": stage, "seed": 9})
+		sheet := decodeBase64PNG(t, result.Image)
+
+		palette := make(map[color.RGBA]bool)
+		for _, c := range result.Palette {
+			palette[color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}] = true
+		}
+		distinct := make(map[color.RGBA]bool)
+		var f features
+		f.black = countPixels(sheet, func(c color.RGBA) bool {
+			distinct[c] = true
+			if !palette[c] && c != (color.RGBA{0, 0, 0, 255}) {
+				f.offPalette++
+			}
+			return c == color.RGBA{0, 0, 0, 255}
+		})
+		f.colors = len(distinct)
+		seen[stage] = f
+	}
+
+	raw, quantized, borders, final := seen["raw"], seen["quantized"], seen["borders"], seen["final"]
+	if raw.offPalette == 0 || raw.colors <= quantized.colors {
+		t.Errorf("raw stage has %d colors, %d pixels off the palette; want the unquantized seed colors", raw.colors, raw.offPalette)
+	}
+	if quantized.offPalette != 0 || quantized.black != 0 {
+		t.Errorf("quantized stage has %d pixels off the palette and %d black; want palette fills only", quantized.offPalette, quantized.black)
+	}
+	if borders.black == 0 {
+		t.Error("borders stage has no border pixels")
+	}
+	if final.black <= borders.black {
+		t.Errorf("final stage has %d black pixels, borders stage %d; want numbers on top", final.black, borders.black)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// renderStipple draws an antialiased dot of each point's palette color at its seed
+// position over a plain background, for a pointillist take on the Voronoi layout
+func renderStipple(points []Point, palette []color.Color, bounds image.Rectangle, radius int, background color.Color) image.Image {
+	img := image.NewRGBA(bounds)
+	bg := color.RGBAModel.Convert(background).(color.RGBA)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = bg.R
+		img.Pix[i+1] = bg.G
+		img.Pix[i+2] = bg.B
+		img.Pix[i+3] = 255
+	}
+
+	r := float64(radius)
+	for _, p := range points {
+		if p.ColorIndex < 0 || p.ColorIndex >= len(palette) {
+			continue
+		}
+		c := color.RGBAModel.Convert(palette[p.ColorIndex]).(color.RGBA)
+
+		area := image.Rect(p.X-radius-1, p.Y-radius-1, p.X+radius+2, p.Y+radius+2).Intersect(bounds)
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				// Coverage falls off over one pixel at the rim for a smooth edge
+				dist := math.Hypot(float64(x-p.X), float64(y-p.Y))
+				coverage := math.Min(1, math.Max(0, r+0.5-dist))
+				if coverage == 0 {
+					continue
+				}
+
+				offset := img.PixOffset(x, y)
+				img.Pix[offset] = blendChannel(img.Pix[offset], c.R, coverage)
+				img.Pix[offset+1] = blendChannel(img.Pix[offset+1], c.G, coverage)
+				img.Pix[offset+2] = blendChannel(img.Pix[offset+2], c.B, coverage)
+			}
+		}
+	}
+
+	return img
+}
+
+// blendChannel mixes src over dst with the given coverage (0-1)
+func blendChannel(dst, src uint8, coverage float64) uint8 {
+	return uint8(float64(dst)*(1-coverage) + float64(src)*coverage + 0.5)
+}
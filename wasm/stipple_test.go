@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// countBlobs returns the number of 8-connected components of pixels differing from bg
+func countBlobs(img image.Image, bg color.RGBA) int {
+	bounds := img.Bounds()
+	seen := make(map[image.Point]bool)
+	inBlob := func(p image.Point) bool {
+		return p.In(bounds) && color.RGBAModel.Convert(img.At(p.X, p.Y)).(color.RGBA) != bg
+	}
+
+	blobs := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			start := image.Pt(x, y)
+			if seen[start] || !inBlob(start) {
+				continue
+			}
+			blobs++
+			seen[start] = true
+			stack := []image.Point{start}
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						if n := p.Add(image.Pt(dx, dy)); !seen[n] && inBlob(n) {
+							seen[n] = true
+							stack = append(stack, n)
+						}
+					}
+				}
+			}
+		}
+	}
+	return blobs
+}
+
+func TestRenderStippleDrawsOneBlobPerPoint(t *testing.T) {
+	// Seeds 14 pixels apart with radius 4 never overlap
+	var points []Point
+	for y := 7; y < 100; y += 14 {
+		for x := 7; x < 120; x += 14 {
+			points = append(points, Point{X: x, Y: y, ColorIndex: len(points) % len(testPalette), Index: len(points)})
+		}
+	}
+	white := color.RGBA{255, 255, 255, 255}
+
+	img := renderStipple(points, testPalette, image.Rect(0, 0, 120, 100), 4, white)
+	if blobs := countBlobs(img, white); blobs != len(points) {
+		t.Errorf("stipple has %d blobs, want one for each of %d points", blobs, len(points))
+	}
+	for _, p := range points {
+		want := color.RGBAModel.Convert(testPalette[p.ColorIndex]).(color.RGBA)
+		if got := img.At(p.X, p.Y); got != want {
+			t.Errorf("dot center %d,%d = %v, want %v", p.X, p.Y, got, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// svgStyle is what encodeSVG draws besides the shapes of the regions
+type svgStyle struct {
+	fill        bool        // Paint regions in their palette colors instead of white
+	background  color.Color // Behind pixels no region holds, such as outside a mask (nil = white)
+	lineWidth   int         // Border stroke width in pixels (0 = no borders)
+	borderColor color.Color // Border stroke color (nil = black)
+	numbers     bool        // Label the regions
+	label       numberStyle // Placement, size, halo, color and text of the labels
+}
+
+// encodeSVG writes regions as an SVG document covering bounds, for sheets that print
+// crisp at any size. Each region becomes one <path> along its pixel edges, holes included,
+// filled with its palette color (ColorIndex indexes palette) or white without style.fill.
+// Borders are stroked polylines along region edges; edges on the rim of bounds are left
+// unstroked like on the raster sheet, while the paths themselves still close along it.
+// Labels become <text> centered where the raster sheet draws its digits.
+func encodeSVG(regions []Region, palette []color.Color, bounds image.Rectangle, style svgStyle) ([]byte, error) {
+	if bounds.Empty() {
+		return nil, fmt.Errorf("cannot encode an empty %dx%d sheet as SVG", bounds.Dx(), bounds.Dy())
+	}
+	polygons := tracePolygons(regionOwners(regions, bounds), bounds)
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="%d %d %d %d">`+"\n",
+		bounds.Dx(), bounds.Dy(), bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy())
+
+	background := style.background
+	if background == nil {
+		background = color.White
+	}
+	fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n",
+		bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy(), colorToHex(background))
+
+	// A region joined to a piece only at a corner traces as several polygons; it is still one path
+	rings := make([][][]image.Point, len(regions))
+	for _, polygon := range polygons {
+		if polygon.Value >= 0 {
+			rings[polygon.Value] = append(rings[polygon.Value], polygon.Rings...)
+		}
+	}
+	buf.WriteString(`<g fill-rule="evenodd" stroke="none">` + "\n")
+	for ri, regionRings := range rings {
+		if len(regionRings) == 0 {
+			continue
+		}
+		fill := "#ffffff"
+		if ci := regions[ri].ColorIndex; style.fill && ci >= 0 && ci < len(palette) {
+			fill = colorToHex(palette[ci])
+		}
+		buf.WriteString(`<path d="`)
+		for _, ring := range regionRings {
+			writeSVGRing(&buf, ring)
+		}
+		fmt.Fprintf(&buf, `" fill="%s"/>`+"\n", fill)
+	}
+	buf.WriteString("</g>\n")
+
+	if style.lineWidth > 0 {
+		border := style.borderColor
+		if border == nil {
+			border = color.Black
+		}
+		fmt.Fprintf(&buf, `<g fill="none" stroke="%s" stroke-width="%d" stroke-linejoin="round" stroke-linecap="round">`+"\n",
+			colorToHex(border), style.lineWidth)
+		for _, regionRings := range rings {
+			for _, ring := range regionRings {
+				for _, line := range innerPolylines(ring, bounds) {
+					buf.WriteString(`<polyline points="`)
+					for i, p := range line {
+						if i > 0 {
+							buf.WriteByte(' ')
+						}
+						fmt.Fprintf(&buf, "%d,%d", p.X, p.Y)
+					}
+					buf.WriteString(`"/>` + "\n")
+				}
+			}
+		}
+		buf.WriteString("</g>\n")
+	}
+
+	if style.numbers {
+		writeSVGLabels(&buf, placeRegionLabels(regions, palette, bounds, style.label), style.label)
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), nil
+}
+
+// writeSVGRing appends a closed ring of pixel corners to a path's d attribute
+func writeSVGRing(buf *bytes.Buffer, ring []image.Point) {
+	for i, p := range ring {
+		if i == len(ring)-1 && p == ring[0] {
+			break
+		}
+		command := 'L'
+		if i == 0 {
+			command = 'M'
+		}
+		fmt.Fprintf(buf, "%c%d %d", command, p.X, p.Y)
+	}
+	buf.WriteByte('Z')
+}
+
+// innerPolylines splits a closed ring into the runs of its edges that do not lie on the rim
+// of bounds, so borders are only stroked between regions. A ring clear of the rim comes
+// back whole, closed.
+func innerPolylines(ring []image.Point, bounds image.Rectangle) [][]image.Point {
+	n := len(ring) - 1 // Segments; the last point repeats the first
+	if n < 1 {
+		return nil
+	}
+	onRim := func(i int) bool {
+		a, b := ring[i%n], ring[(i+1)%n]
+		return (a.X == b.X && (a.X == bounds.Min.X || a.X == bounds.Max.X)) ||
+			(a.Y == b.Y && (a.Y == bounds.Min.Y || a.Y == bounds.Max.Y))
+	}
+
+	// Start just after a rim segment, so no run is cut in two where the ring closes
+	start := -1
+	for i := 0; i < n; i++ {
+		if onRim(i) {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return [][]image.Point{ring}
+	}
+
+	var lines [][]image.Point
+	var line []image.Point
+	for i := start; i < start+n; i++ {
+		if onRim(i) {
+			if len(line) > 1 {
+				lines = append(lines, line)
+			}
+			line = nil
+			continue
+		}
+		if line == nil {
+			line = append(line, ring[i%n])
+		}
+		line = append(line, ring[(i+1)%n])
+	}
+	if len(line) > 1 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// writeSVGLabels writes labels as <text>, each centered on the glyph the raster sheet draws
+// for it, in the label color with a white halo when the style has an outline
+func writeSVGLabels(buf *bytes.Buffer, labels []placedLabel, style numberStyle) {
+	ink := style.color
+	if ink == nil {
+		ink = color.Black
+	}
+	// The 5x7 glyphs are about as tall as the capitals of a sans-serif font this size
+	fmt.Fprintf(buf, `<g font-family="sans-serif" font-size="%d" text-anchor="middle" dominant-baseline="central" fill="%s"`,
+		10*style.glyphScale(), colorToHex(ink))
+	if outline := min(style.outline, maxNumberOutline); outline > 0 {
+		fmt.Fprintf(buf, ` stroke="#ffffff" stroke-width="%d" stroke-linejoin="round" paint-order="stroke"`, 2*outline)
+	}
+	buf.WriteString(">\n")
+
+	for _, label := range labels {
+		if len(label.glyph) == 0 {
+			continue
+		}
+		box := image.Rectangle{Min: label.glyph[0], Max: label.glyph[0].Add(image.Pt(1, 1))}
+		for _, p := range label.glyph[1:] {
+			box = box.Union(image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))})
+		}
+		fmt.Fprintf(buf, `<text x="%g" y="%g">`, float64(box.Min.X+box.Max.X)/2, float64(box.Min.Y+box.Max.Y)/2)
+		xml.EscapeText(buf, []byte(label.text))
+		buf.WriteString("</text>\n")
+	}
+	buf.WriteString("</g>\n")
+}
+
+// renderSVG encodes the layout as an SVG sheet with the regions, fills, borders and numbers
+// render draws for the same arguments. The legend and margin are only added to the raster.
+func (l *sheetLayout) renderSVG(lineWidth int, showColors bool) ([]byte, error) {
+	if l.style != nil {
+		showColors = l.style.fill
+	}
+	regions := buildLabeledRegions(l.assignment(), l.bounds, l.palette, l.minArea, l.labeling, l.keepAllColors)
+	palette := l.numbers.numbering(regions, l.palette)
+
+	var background color.Color
+	if l.mask != nil {
+		background = l.maskBackground
+	}
+	return encodeSVG(regions, palette, l.bounds, svgStyle{
+		fill:        showColors,
+		background:  background,
+		lineWidth:   lineWidth,
+		borderColor: l.borderColor,
+		numbers:     l.showNumbers(lineWidth),
+		label:       l.numbers,
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// svgDoc holds the parts of an encodeSVG document the tests look at
+type svgDoc struct {
+	Groups []struct {
+		Paths []struct {
+			D    string `xml:"d,attr"`
+			Fill string `xml:"fill,attr"`
+		} `xml:"path"`
+		Polylines []struct {
+			Points string `xml:"points,attr"`
+		} `xml:"polyline"`
+		Texts []string `xml:"text"`
+	} `xml:"g"`
+}
+
+// parseSVGRings reads the closed rings of a path's d attribute, as written by writeSVGRing
+func parseSVGRings(t *testing.T, d string) [][]image.Point {
+	t.Helper()
+	var rings [][]image.Point
+	for _, ring := range strings.Split(strings.TrimSuffix(d, "Z"), "Z") {
+		if !strings.HasPrefix(ring, "M") {
+			t.Fatalf("ring %q does not start with M", ring)
+		}
+		var points []image.Point
+		for _, cmd := range strings.FieldsFunc(ring, func(r rune) bool { return r == 'M' || r == 'L' }) {
+			var p image.Point
+			var err1, err2 error
+			xy := strings.Fields(cmd)
+			if len(xy) != 2 {
+				t.Fatalf("path command %q is not x y", cmd)
+			}
+			p.X, err1 = strconv.Atoi(xy[0])
+			p.Y, err2 = strconv.Atoi(xy[1])
+			if err1 != nil || err2 != nil {
+				t.Fatalf("path command %q is not x y", cmd)
+			}
+			points = append(points, p)
+		}
+		rings = append(rings, points)
+	}
+	return rings
+}
+
+// insideRings reports whether the point (x, y) is inside rings under the even-odd rule
+func insideRings(rings [][]image.Point, x, y float64) bool {
+	inside := false
+	for _, ring := range rings {
+		for i := range ring {
+			a, b := ring[i], ring[(i+1)%len(ring)]
+			if (float64(a.Y) > y) != (float64(b.Y) > y) &&
+				x < float64(a.X)+(y-float64(a.Y))*float64(b.X-a.X)/float64(b.Y-a.Y) {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func TestSVGPathsCloseAroundEveryRegion(t *testing.T) {
+	// Three stripes, all touching the rim, one holding an island of color 3, and another
+	// piece of color 3 in the bottom right corner
+	bounds := image.Rect(0, 0, 48, 30)
+	assignment := stripeAssignment(bounds.Dx(), bounds.Dy(), 0, 1, 2)
+	paintRect(assignment, bounds.Dx(), image.Rect(20, 10, 28, 20), 3)
+	paintRect(assignment, bounds.Dx(), image.Rect(40, 24, 48, 30), 3)
+	regions := buildLabeledRegions(assignment, bounds, testPalette, 1, LabelUnionFind, false)
+	if len(regions) != 5 {
+		t.Fatalf("%d regions, want 5", len(regions))
+	}
+
+	data, err := encodeSVG(regions, testPalette, bounds, svgStyle{fill: true, lineWidth: 1, numbers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc svgDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("SVG does not parse: %v", err)
+	}
+	if len(doc.Groups) != 3 {
+		t.Fatalf("SVG has %d groups, want fills, borders and labels", len(doc.Groups))
+	}
+	fills, borders, labels := doc.Groups[0], doc.Groups[1], doc.Groups[2]
+
+	// Every pixel center lies inside exactly the path of the region holding it
+	if len(fills.Paths) != len(regions) {
+		t.Fatalf("%d paths for %d regions", len(fills.Paths), len(regions))
+	}
+	owner := regionOwners(regions, bounds)
+	for ri, path := range fills.Paths {
+		if want := colorToHex(testPalette[regions[ri].ColorIndex]); path.Fill != want {
+			t.Errorf("region %d filled %s, want %s", ri, path.Fill, want)
+		}
+		rings := parseSVGRings(t, path.D)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				if in, want := insideRings(rings, float64(x)+0.5, float64(y)+0.5), owner[y*bounds.Dx()+x] == ri; in != want {
+					t.Fatalf("region %d path covers pixel (%d, %d): %v, want %v", ri, x, y, in, want)
+				}
+			}
+		}
+	}
+
+	// Borders run between regions only, never along the rim
+	onRim := func(a, b image.Point) bool {
+		return (a.X == b.X && (a.X == bounds.Min.X || a.X == bounds.Max.X)) || (a.Y == b.Y && (a.Y == bounds.Min.Y || a.Y == bounds.Max.Y))
+	}
+	for _, line := range borders.Polylines {
+		var points []image.Point
+		for _, xy := range strings.Fields(line.Points) {
+			var p image.Point
+			if _, err := fmt.Sscanf(xy, "%d,%d", &p.X, &p.Y); err != nil {
+				t.Fatalf("polyline point %q: %v", xy, err)
+			}
+			points = append(points, p)
+		}
+		for i := 1; i < len(points); i++ {
+			if onRim(points[i-1], points[i]) {
+				t.Errorf("border segment %v-%v lies on the rim", points[i-1], points[i])
+			}
+		}
+	}
+	if len(borders.Polylines) == 0 {
+		t.Error("no borders stroked")
+	}
+
+	if len(labels.Texts) != len(regions) {
+		t.Errorf("labels %q, want one per region", labels.Texts)
+	}
+
+	// Through processImage the result is a parseable document too
+	result := mustProcessImage(t, syntheticImage(96), testSheetArgs, map[string]interface{}{"format": "svg"})
+	if err := xml.Unmarshal(decodeBase64(t, result.SVG), &doc); err != nil {
+		t.Errorf("format svg result does not parse: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "image/color"
+
+// maxTemperatureShift is how far temperature ±100 moves red and blue in opposite
+// directions, in 8-bit channel units; green follows red by a quarter, toward orange
+const maxTemperatureShift = 30.0
+
+// shiftTemperature returns palette moved along the blue-orange axis: positive temperatures
+// (up to 100) add red and take away blue for a warm rendition, negative ones (down to -100)
+// the reverse. Temperature 0 returns the palette unchanged.
+func shiftTemperature(palette []color.Color, temperature int) []color.Color {
+	if temperature == 0 {
+		return palette
+	}
+	shift := float64(temperature) / 100 * maxTemperatureShift
+
+	shifted := make([]color.Color, len(palette))
+	for i, c := range palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		shifted[i] = color.RGBA{
+			R: clampChannel(float64(rgba.R) + shift),
+			G: clampChannel(float64(rgba.G) + shift/4),
+			B: clampChannel(float64(rgba.B) - shift),
+			A: 255,
+		}
+	}
+	return shifted
+}
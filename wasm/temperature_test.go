@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestTemperatureRaisesRedToBlueRatio(t *testing.T) {
+	// redBlue is the ratio of the palette's average red to its average blue
+	redBlue := func(temperature int) float64 {
+		result := mustProcessImage(t, syntheticImage(96), testSheetArgs, map[string]interface{}{"temperature": temperature, "seed": 7})
+		var red, blue float64
+		for _, c := range result.Palette {
+			red += float64(c.R)
+			blue += float64(c.B)
+		}
+		return red / blue
+	}
+
+	cool, neutral, warm := redBlue(-100), redBlue(0), redBlue(100)
+	if !(cool < neutral && neutral < warm) {
+		t.Errorf("red/blue ratio at temperature -100, 0, 100 = %.3f, %.3f, %.3f, want increasing", cool, neutral, warm)
+	}
+}
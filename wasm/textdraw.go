@@ -4,6 +4,9 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Simple 5x7 bitmap font for digits
@@ -100,6 +103,253 @@ var digitBitmaps = map[rune][][]bool{
 	},
 }
 
+// Letter glyphs in the same 5x7 style, enough to print hex codes and color names
+var glyphBitmaps = map[rune][][]bool{
+	'#': {
+		{false, true, false, true, false},
+		{false, true, false, true, false},
+		{true, true, true, true, true},
+		{false, true, false, true, false},
+		{true, true, true, true, true},
+		{false, true, false, true, false},
+		{false, true, false, true, false},
+	},
+	'A': {
+		{false, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+	},
+	'B': {
+		{true, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, false},
+	},
+	'C': {
+		{false, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, true},
+		{false, true, true, true, false},
+	},
+	'D': {
+		{true, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, false},
+	},
+	'E': {
+		{true, true, true, true, true},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, true, true, true, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, true, true, true, true},
+	},
+	'F': {
+		{true, true, true, true, true},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, true, true, true, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+	},
+	'G': {
+		{false, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, false},
+		{true, false, true, true, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{false, true, true, true, false},
+	},
+	'H': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+	},
+	'I': {
+		{false, true, true, true, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, true, true, true, false},
+	},
+	'J': {
+		{false, false, true, true, true},
+		{false, false, false, true, false},
+		{false, false, false, true, false},
+		{false, false, false, true, false},
+		{false, false, false, true, false},
+		{true, false, false, true, false},
+		{false, true, true, false, false},
+	},
+	'K': {
+		{true, false, false, false, true},
+		{true, false, false, true, false},
+		{true, false, true, false, false},
+		{true, true, false, false, false},
+		{true, false, true, false, false},
+		{true, false, false, true, false},
+		{true, false, false, false, true},
+	},
+	'L': {
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, true, true, true, true},
+	},
+	'M': {
+		{true, false, false, false, true},
+		{true, true, false, true, true},
+		{true, false, true, false, true},
+		{true, false, true, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+	},
+	'N': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, false, false, true},
+		{true, false, true, false, true},
+		{true, false, false, true, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+	},
+	'O': {
+		{false, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{false, true, true, true, false},
+	},
+	'P': {
+		{true, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+	},
+	'Q': {
+		{false, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, true, false, true},
+		{true, false, false, true, false},
+		{false, true, true, false, true},
+	},
+	'R': {
+		{true, true, true, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, true, true, true, false},
+		{true, false, true, false, false},
+		{true, false, false, true, false},
+		{true, false, false, false, true},
+	},
+	'S': {
+		{false, true, true, true, true},
+		{true, false, false, false, false},
+		{true, false, false, false, false},
+		{false, true, true, true, false},
+		{false, false, false, false, true},
+		{false, false, false, false, true},
+		{true, true, true, true, false},
+	},
+	'T': {
+		{true, true, true, true, true},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+	},
+	'U': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{false, true, true, true, false},
+	},
+	'V': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{false, true, false, true, false},
+		{false, false, true, false, false},
+	},
+	'W': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{true, false, true, false, true},
+		{true, false, true, false, true},
+		{true, false, true, false, true},
+		{false, true, false, true, false},
+	},
+	'X': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{false, true, false, true, false},
+		{false, false, true, false, false},
+		{false, true, false, true, false},
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+	},
+	'Y': {
+		{true, false, false, false, true},
+		{true, false, false, false, true},
+		{false, true, false, true, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+		{false, false, true, false, false},
+	},
+	'Z': {
+		{true, true, true, true, true},
+		{false, false, false, false, true},
+		{false, false, false, true, false},
+		{false, false, true, false, false},
+		{false, true, false, false, false},
+		{true, false, false, false, false},
+		{true, true, true, true, true},
+	},
+}
+
 // Region represents a connected area in the image
 type Region struct {
 	ColorIndex int
@@ -108,119 +358,216 @@ type Region struct {
 	Area       int
 }
 
-// findRegions identifies connected regions for each color
-func findRegions(img *image.RGBA, points []Point, kdtree *KDTree) []Region {
+// findRegions identifies connected regions for each palette color
+func findRegions(img *image.RGBA, points []Point, index NearestIndex, palette []color.Color, minArea int, labeling LabelAlgorithm, keepAllColors bool, report func(done int)) []Region {
 	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+	assignment := buildVoronoiAssignment(bounds, points, index, report)
+	return buildLabeledRegions(assignment, bounds, palette, minArea, labeling, keepAllColors)
+}
 
-	// Build region map
-	visited := make([]bool, width*height)
-	var regions []Region
+// maxNumberOutline is the thickest halo drawNumberWithStyle accepts, in pixels
+const maxNumberOutline = 3
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			idx := (y-bounds.Min.Y)*width + (x - bounds.Min.X)
-			if visited[idx] {
-				continue
-			}
+// maxNumberScale is the largest glyph magnification drawNumberWithStyle accepts
+const maxNumberScale = 3
 
-			// Start new region
-			colorIdx := kdtree.FindNearest(x, y)
-			region := floodFill(img, x, y, colorIdx, visited, kdtree, bounds)
-
-			// Only keep regions with reasonable size
-			if len(region.Pixels) >= 100 {
-				// Calculate centroid
-				sumX, sumY := 0, 0
-				for _, p := range region.Pixels {
-					sumX += p.X
-					sumY += p.Y
-				}
-				region.Centroid = image.Point{
-					X: sumX / len(region.Pixels),
-					Y: sumY / len(region.Pixels),
-				}
-				region.Area = len(region.Pixels)
-				regions = append(regions, region)
-			}
-		}
+// numberStyle controls how region numbers are placed and drawn
+type numberStyle struct {
+	spacing int         // Repeat numbers across large regions every this many pixels (0 = once per region)
+	outline int         // White halo around the digits, in pixels (0 = none)
+	scale   int         // Whole-pixel glyph magnification (0 or 1 = the 5x7 bitmap as is)
+	grid    int         // Snap labels to intersections of a grid with this pitch in pixels (0 = off)
+	minGap  int         // Drop labels closer than this many pixels to a larger region's label (0 = off)
+	inset   int         // Keep label digits at least this many pixels from region borders (0 = off)
+	color   color.Color // Digit color (nil = black)
+	label   RegionLabel // What each region shows (the zero value prints its number)
+	locked  bool        // Keep every palette color's number, used or not (a palette locked by the caller)
+}
+
+// numbering returns the palette the regions' numbers refer to: compacted to the colors in
+// use (see compactLabelNumbering) unless the palette is locked
+func (s numberStyle) numbering(regions []Region, palette []color.Color) []color.Color {
+	if s.locked {
+		return palette
 	}
+	return compactLabelNumbering(regions, palette)
+}
 
-	return regions
+// positions returns where to draw a region's numbers under this style
+func (s numberStyle) positions(region Region) []image.Point {
+	positions := labelPositions(region, s.spacing)
+	if s.grid > 0 {
+		positions = snapLabelsToGrid(region, positions, s.grid)
+	}
+	return positions
 }
 
-// floodFill performs flood fill to identify a connected region
-func floodFill(img *image.RGBA, startX, startY, colorIdx int, visited []bool, kdtree *KDTree, bounds image.Rectangle) Region {
-	width := bounds.Dx()
-	queue := []image.Point{{X: startX, Y: startY}}
-	region := Region{ColorIndex: colorIdx}
+// drawNumber draws a number at the specified position (smaller, black text)
+func drawNumber(img *image.RGBA, num int, x, y int) {
+	drawNumberWithStyle(img, num, x, y, numberStyle{})
+}
 
-	for len(queue) > 0 {
-		p := queue[0]
-		queue = queue[1:]
+// drawNumberWithStyle draws a number in style.color magnified by style.scale with a white
+// halo style.outline pixels thick around the glyphs (0 = flat text), which keeps numbers
+// readable over busy colored cells
+func drawNumberWithStyle(img *image.RGBA, num int, x, y int, style numberStyle) {
+	drawGlyphWithStyle(img, numberGlyph(num, x, y, style.glyphScale()), style)
+}
 
-		if p.X < bounds.Min.X || p.X >= bounds.Max.X || p.Y < bounds.Min.Y || p.Y >= bounds.Max.Y {
-			continue
-		}
+// drawTextWithStyle draws text centered near (x, y) with the same color, magnification and
+// halo as drawNumberWithStyle
+func drawTextWithStyle(img *image.RGBA, text string, x, y int, style numberStyle) {
+	drawGlyphWithStyle(img, textGlyph(text, x, y, style.glyphScale()), style)
+}
 
-		idx := (p.Y-bounds.Min.Y)*width + (p.X - bounds.Min.X)
-		if visited[idx] {
-			continue
-		}
+// glyphScale is the style's magnification clamped to 1-maxNumberScale
+func (s numberStyle) glyphScale() int {
+	if s.scale < 1 {
+		return 1
+	}
+	return min(s.scale, maxNumberScale)
+}
+
+// numberGlyph returns the pixels of num magnified by scale, centered near (x, y)
+func numberGlyph(num int, x, y int, scale int) []image.Point {
+	if num >= 10 {
+		// For two-digit numbers, draw them side by side (closer together for smaller size)
+		tens := num / 10
+		ones := num % 10
+		return append(digitPixels(tens, x-2*scale, y, scale), digitPixels(ones, x+2*scale, y, scale)...)
+	}
+	return digitPixels(num, x, y, scale)
+}
 
-		currentColorIdx := kdtree.FindNearest(p.X, p.Y)
-		if currentColorIdx != colorIdx {
-			continue
+// textGlyph returns the pixels of text magnified by scale, centered near (x, y)
+func textGlyph(text string, x, y int, scale int) []image.Point {
+	var glyph []image.Point
+	startX := x - textWidth(text, scale)/2
+	startY := y - 3*scale
+	for i, ch := range strings.ToUpper(text) {
+		bitmap := digitBitmaps[ch]
+		if bitmap == nil {
+			bitmap = glyphBitmaps[ch]
 		}
+		if bitmap != nil {
+			glyph = append(glyph, scaledBitmapPixels(bitmap, startX+i*6*scale, startY, scale)...)
+		}
+	}
+	return glyph
+}
 
-		visited[idx] = true
-		region.Pixels = append(region.Pixels, p)
+// fitGlyph shifts glyph pixels just far enough that they and a margin-pixel halo around
+// them lie inside bounds, so a region touching the image edge still gets its whole label.
+// A glyph too big for bounds keeps its top-left corner inside.
+func fitGlyph(glyph []image.Point, bounds image.Rectangle, margin int) []image.Point {
+	if len(glyph) == 0 {
+		return glyph
+	}
+	box := image.Rectangle{Min: glyph[0], Max: glyph[0].Add(image.Point{X: 1, Y: 1})}
+	for _, p := range glyph[1:] {
+		box = box.Union(image.Rectangle{Min: p, Max: p.Add(image.Point{X: 1, Y: 1})})
+	}
+	box = box.Inset(-margin)
 
-		// Add neighbors to queue
-		queue = append(queue,
-			image.Point{X: p.X - 1, Y: p.Y},
-			image.Point{X: p.X + 1, Y: p.Y},
-			image.Point{X: p.X, Y: p.Y - 1},
-			image.Point{X: p.X, Y: p.Y + 1},
-		)
+	var shift image.Point
+	if box.Max.X > bounds.Max.X {
+		shift.X = bounds.Max.X - box.Max.X
+	}
+	if box.Min.X+shift.X < bounds.Min.X {
+		shift.X = bounds.Min.X - box.Min.X
+	}
+	if box.Max.Y > bounds.Max.Y {
+		shift.Y = bounds.Max.Y - box.Max.Y
+	}
+	if box.Min.Y+shift.Y < bounds.Min.Y {
+		shift.Y = bounds.Min.Y - box.Min.Y
+	}
+	if shift == (image.Point{}) {
+		return glyph
 	}
 
-	return region
+	fitted := make([]image.Point, len(glyph))
+	for i, p := range glyph {
+		fitted[i] = p.Add(shift)
+	}
+	return fitted
 }
 
-// drawNumber draws a number at the specified position (smaller, black text)
-func drawNumber(img *image.RGBA, num int, x, y int) {
-	if num >= 10 {
-		// For two-digit numbers, draw them side by side (closer together for smaller size)
-		tens := num / 10
-		ones := num % 10
-		drawDigit(img, tens, x-2, y)
-		drawDigit(img, ones, x+2, y)
-	} else {
-		drawDigit(img, num, x, y)
+// textWidth is how many pixels wide drawTextWithStyle draws text at scale
+func textWidth(text string, scale int) int {
+	if text == "" {
+		return 0
+	}
+	return (6*len(text) - 1) * scale
+}
+
+// textFitsRegion reports whether text at scale fits inside the region's bounding box with
+// a pixel to spare on each side, so long labels skip regions they would spill out of
+func textFitsRegion(region Region, text string, scale int) bool {
+	if scale < 1 {
+		scale = 1
+	}
+	if len(region.Pixels) == 0 {
+		return false
+	}
+	box := image.Rectangle{Min: region.Pixels[0], Max: region.Pixels[0].Add(image.Point{X: 1, Y: 1})}
+	for _, p := range region.Pixels[1:] {
+		box = box.Union(image.Rectangle{Min: p, Max: p.Add(image.Point{X: 1, Y: 1})})
 	}
+	return box.Dx() >= textWidth(text, scale)+2 && box.Dy() >= 7*scale+2
 }
 
-// drawDigit draws a single digit (small black text)
-func drawDigit(img *image.RGBA, digit int, centerX, centerY int) {
+// drawGlyphWithStyle draws already-positioned glyph pixels in style.color with a white halo
+// style.outline pixels thick
+func drawGlyphWithStyle(img *image.RGBA, glyph []image.Point, style numberStyle) {
+	// Halo first for the whole label, so one character's halo never covers another
+	outline := min(style.outline, maxNumberOutline)
+	if outline > 0 {
+		inGlyph := make(map[image.Point]bool, len(glyph))
+		for _, p := range glyph {
+			inGlyph[p] = true
+		}
+		haloColor := color.RGBA{255, 255, 255, 255}
+		for _, p := range glyph {
+			for dy := -outline; dy <= outline; dy++ {
+				for dx := -outline; dx <= outline; dx++ {
+					q := image.Point{X: p.X + dx, Y: p.Y + dy}
+					if !inGlyph[q] && q.In(img.Bounds()) {
+						img.Set(q.X, q.Y, haloColor)
+					}
+				}
+			}
+		}
+	}
+
+	var textColor color.Color = color.RGBA{0, 0, 0, 255}
+	if style.color != nil {
+		textColor = style.color
+	}
+	for _, p := range glyph {
+		if p.In(img.Bounds()) {
+			img.Set(p.X, p.Y, textColor)
+		}
+	}
+}
+
+// digitPixels returns the pixels of a single digit magnified by scale, centered near
+// (centerX, centerY)
+func digitPixels(digit int, centerX, centerY int, scale int) []image.Point {
 	if digit < 0 || digit > 9 {
-		return
+		return nil
 	}
 
 	bitmap := digitBitmaps[rune('0'+digit)]
 	if bitmap == nil {
-		return
+		return nil
 	}
 
-	// Calculate top-left position (center the digit)
-	// Reduce size by using smaller offset
-	startX := centerX - 1
-	startY := centerY - 2
+	// Calculate top-left position (center the digit); the offset grows with the scale
+	startX := centerX - scale
+	startY := centerY - 2*scale
 
-	// Draw black text only (no outline for cleaner, smaller look)
-	textColor := color.RGBA{0, 0, 0, 255}
-	drawBitmapScaled(img, bitmap, startX, startY, textColor, 0.6) // 60% of original size
+	return scaledBitmapPixels(bitmap, startX, startY, scale)
 }
 
 // drawBitmap draws a bitmap at the specified position
@@ -239,57 +586,252 @@ func drawBitmap(img *image.RGBA, bitmap [][]bool, startX, startY int, c color.Co
 	}
 }
 
-// drawBitmapScaled draws a bitmap scaled down
-func drawBitmapScaled(img *image.RGBA, bitmap [][]bool, startX, startY int, c color.Color, scale float64) {
-	if scale >= 1.0 {
-		drawBitmap(img, bitmap, startX, startY, c)
-		return
+// drawText draws text at full bitmap size with its top-left corner at (x, y).
+// Letters are drawn uppercase; characters without a glyph leave a blank space.
+func drawText(img *image.RGBA, text string, x, y int, c color.Color) {
+	for i, ch := range strings.ToUpper(text) {
+		bitmap := digitBitmaps[ch]
+		if bitmap == nil {
+			bitmap = glyphBitmaps[ch]
+		}
+		if bitmap != nil {
+			drawBitmap(img, bitmap, x+i*6, y, c)
+		}
 	}
+}
 
-	bounds := img.Bounds()
-	// Draw every nth pixel to achieve scaling effect
-	skipFactor := int(1.0 / scale)
-	if skipFactor < 1 {
-		skipFactor = 1
+// scaledBitmapPixels returns the pixels a bitmap covers when each of its pixels becomes a
+// scale×scale block and its top-left corner is at (startX, startY). Whole-number scaling
+// never drops a row or column, so strokes stay intact.
+func scaledBitmapPixels(bitmap [][]bool, startX, startY int, scale int) []image.Point {
+	if scale < 1 {
+		scale = 1
 	}
 
-	for y := 0; y < len(bitmap); y++ {
-		if y%skipFactor != 0 {
-			continue
-		}
-		row := bitmap[y]
-		scaledY := y / skipFactor
-
-		for x := 0; x < len(row); x++ {
-			if x%skipFactor != 0 {
+	var pixels []image.Point
+	for y, row := range bitmap {
+		for x, pixel := range row {
+			if !pixel {
 				continue
 			}
-			if row[x] {
-				scaledX := x / skipFactor
-				px := startX + scaledX
-				py := startY + scaledY
-				if px >= bounds.Min.X && px < bounds.Max.X && py >= bounds.Min.Y && py < bounds.Max.Y {
-					img.Set(px, py, c)
+			for sy := 0; sy < scale; sy++ {
+				for sx := 0; sx < scale; sx++ {
+					pixels = append(pixels, image.Point{X: startX + x*scale + sx, Y: startY + y*scale + sy})
 				}
 			}
 		}
 	}
+
+	return pixels
 }
 
-// addRegionNumbers adds color numbers to each region
-func addRegionNumbers(img *image.RGBA, points []Point, kdtree *KDTree) *image.RGBA {
+// addRegionNumbers adds color numbers to each region and returns the palette renumbered to match
+func addRegionNumbers(img *image.RGBA, points []Point, index NearestIndex, palette []color.Color, minArea int, labeling LabelAlgorithm, keepAllColors bool, style numberStyle, progress ProgressCallback) (*image.RGBA, []color.Color) {
 	result := image.NewRGBA(img.Bounds())
 	draw.Draw(result, img.Bounds(), img, img.Bounds().Min, draw.Src)
 
 	// Find all regions
-	regions := findRegions(img, points, kdtree)
+	report := stageProgress(progress, "Adding numbers", 85, 98, img.Bounds().Dy())
+	regions := findRegions(img, points, index, palette, minArea, labeling, keepAllColors, report)
+
+	// Renumber so only colors with a numbered region appear, as 1..N, unless the palette is locked
+	palette = style.numbering(regions, palette)
 
 	// Draw numbers on each region
-	for _, region := range regions {
+	drawRegionNumbers(result, regions, palette, style)
+
+	return result, palette
+}
+
+// drawRegionNumbers draws the labels placeRegionLabels works out for regions into img
+func drawRegionNumbers(img *image.RGBA, regions []Region, palette []color.Color, style numberStyle) {
+	for _, label := range placeRegionLabels(regions, palette, img.Bounds(), style) {
+		drawGlyphWithStyle(img, label.glyph, style)
+	}
+}
+
+// placedLabel is one region label as it is drawn: its text and the pixels of its glyph
+type placedLabel struct {
+	text  string
+	glyph []image.Point
+}
+
+// placeRegionLabels lays out each region's color number (ColorIndex + 1) at its label
+// positions, or its palette color's hex code or name if style.label asks for one; regions
+// too small for that text are left unlabeled. With style.minGap set, regions are labeled
+// largest first and a label within minGap pixels of one already placed is skipped, so
+// crowded small regions don't print touching numbers. With style.inset set, each label
+// moves to the nearest spot in its region where its digits clear every border by that many
+// pixels. Glyphs near the edge of bounds are moved inward rather than clipped.
+func placeRegionLabels(regions []Region, palette []color.Color, bounds image.Rectangle, style numberStyle) []placedLabel {
+	order := make([]int, len(regions))
+	for i := range order {
+		order[i] = i
+	}
+	if style.minGap > 0 {
+		sort.SliceStable(order, func(a, b int) bool {
+			return regions[order[a]].Area > regions[order[b]].Area
+		})
+	}
+
+	var field *insetField
+	if style.inset > 0 {
+		field = newInsetField(regions, bounds)
+	}
+
+	var labels []placedLabel
+	var placed []image.Point
+	for _, ri := range order {
+		region := regions[ri]
 		// Color numbers start at 1
 		colorNumber := region.ColorIndex + 1
-		drawNumber(result, colorNumber, region.Centroid.X, region.Centroid.Y)
+		text := ""
+		if style.label != RegionLabelNumber && region.ColorIndex < len(palette) {
+			text = style.label.text(colorNumber, palette[region.ColorIndex])
+			if !textFitsRegion(region, text, style.scale) {
+				continue
+			}
+		}
+		glyphAt := func(pos image.Point) []image.Point {
+			if text != "" {
+				return textGlyph(text, pos.X, pos.Y, style.glyphScale())
+			}
+			return numberGlyph(colorNumber, pos.X, pos.Y, style.glyphScale())
+		}
+		shown := text
+		if shown == "" {
+			shown = strconv.Itoa(colorNumber)
+		}
+		for _, pos := range style.positions(region) {
+			if field != nil {
+				pos = field.insetPosition(region, ri, pos, glyphAt, style.inset)
+			}
+			if style.minGap > 0 && labelTooClose(pos, placed, style.minGap) {
+				continue
+			}
+			placed = append(placed, pos)
+			glyph := fitGlyph(glyphAt(pos), bounds, min(style.outline, maxNumberOutline))
+			labels = append(labels, placedLabel{text: shown, glyph: glyph})
+		}
+	}
+	return labels
+}
+
+// labelTooClose reports whether pos lies within gap pixels of any placed label
+func labelTooClose(pos image.Point, placed []image.Point, gap int) bool {
+	for _, p := range placed {
+		dx, dy := pos.X-p.X, pos.Y-p.Y
+		if dx*dx+dy*dy < gap*gap {
+			return true
+		}
+	}
+	return false
+}
+
+// labelPositions returns where to draw a region's number. With spacing 0 that is just the
+// centroid; otherwise the region is tiled on a spacing-sized grid and every cell the region
+// mostly fills gets its own label, so large areas like sky have several to paint by.
+func labelPositions(region Region, spacing int) []image.Point {
+	if spacing <= 0 || region.Area < spacing*spacing {
+		return []image.Point{region.Centroid}
+	}
+
+	type cell struct{ count, sumX, sumY int }
+	cells := make(map[image.Point]*cell)
+	var order []image.Point
+	for _, p := range region.Pixels {
+		key := image.Point{X: floorDiv(p.X, spacing), Y: floorDiv(p.Y, spacing)}
+		c, ok := cells[key]
+		if !ok {
+			c = &cell{}
+			cells[key] = c
+			order = append(order, key)
+		}
+		c.count++
+		c.sumX += p.X
+		c.sumY += p.Y
+	}
+
+	var positions []image.Point
+	for _, key := range order {
+		c := cells[key]
+		if c.count*4 >= spacing*spacing*3 {
+			positions = append(positions, image.Point{X: c.sumX / c.count, Y: c.sumY / c.count})
+		}
+	}
+	if len(positions) == 0 {
+		return []image.Point{region.Centroid}
+	}
+	return positions
+}
+
+// snapLabelsToGrid moves each label to the nearest intersection of a pitch-sized grid that
+// lies inside the region, for a tidy aligned sheet. Labels that snap to the same
+// intersection are merged; a region with no intersection inside keeps its labels as they are.
+func snapLabelsToGrid(region Region, positions []image.Point, pitch int) []image.Point {
+	var candidates []image.Point
+	for _, p := range region.Pixels {
+		if floorDiv(p.X, pitch)*pitch == p.X && floorDiv(p.Y, pitch)*pitch == p.Y {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return positions
+	}
+
+	seen := make(map[image.Point]bool)
+	var snapped []image.Point
+	for _, pos := range positions {
+		best := candidates[0]
+		bestDist := -1
+		for _, c := range candidates {
+			dx, dy := c.X-pos.X, c.Y-pos.Y
+			if dist := dx*dx + dy*dy; bestDist < 0 || dist < bestDist {
+				best, bestDist = c, dist
+			}
+		}
+		if !seen[best] {
+			seen[best] = true
+			snapped = append(snapped, best)
+		}
+	}
+	return snapped
+}
+
+// floorDiv divides rounding toward negative infinity so grid cells line up across the origin
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// compactLabelNumbering remaps region color indices to a gapless range and returns the matching palette.
+// Palette colors without any region are dropped, so the legend only lists numbers that appear on the sheet.
+func compactLabelNumbering(regions []Region, palette []color.Color) []color.Color {
+	used := make([]bool, len(palette))
+	for _, region := range regions {
+		if region.ColorIndex >= 0 && region.ColorIndex < len(palette) {
+			used[region.ColorIndex] = true
+		}
+	}
+
+	// Keep palette order so numbers still follow the original sequence
+	remap := make([]int, len(palette))
+	compacted := make([]color.Color, 0, len(palette))
+	for i, c := range palette {
+		if used[i] {
+			remap[i] = len(compacted)
+			compacted = append(compacted, c)
+		}
+	}
+
+	for i := range regions {
+		if regions[i].ColorIndex >= 0 && regions[i].ColorIndex < len(palette) {
+			regions[i].ColorIndex = remap[regions[i].ColorIndex]
+		}
 	}
 
-	return result
+	return compacted
 }
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// testPalette is a few well-separated colors for building assignments by hand
+var testPalette = []color.Color{
+	color.RGBA{220, 40, 40, 255},
+	color.RGBA{40, 160, 60, 255},
+	color.RGBA{40, 70, 200, 255},
+	color.RGBA{240, 200, 40, 255},
+}
+
+// stripeAssignment returns a width×height assignment split into vertical stripes, one per
+// entry of colors, as evenly as the width allows
+func stripeAssignment(width, height int, colors ...int) []int {
+	assignment := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			assignment[y*width+x] = colors[x*len(colors)/width]
+		}
+	}
+	return assignment
+}
+
+func TestCompactLabelNumberingSkipsUnusedColors(t *testing.T) {
+	bounds := image.Rect(0, 0, 60, 30)
+	// Color 1 never appears, and color 3 only as a sliver too small to number
+	assignment := stripeAssignment(bounds.Dx(), bounds.Dy(), 0, 0, 2, 2)
+	for y := 0; y < bounds.Dy(); y++ {
+		assignment[y*bounds.Dx()+bounds.Dx()-1] = 3
+	}
+
+	regions := buildLabeledRegions(assignment, bounds, testPalette, 100, LabelUnionFind, false)
+	compacted := compactLabelNumbering(regions, testPalette)
+
+	if len(compacted) != 2 {
+		t.Fatalf("compacted palette has %d colors, want 2", len(compacted))
+	}
+	if !colorsEqual(compacted[0], testPalette[0]) || !colorsEqual(compacted[1], testPalette[2]) {
+		t.Errorf("compacted palette = %v, want colors 0 and 2 in order", compacted)
+	}
+
+	used := make([]bool, len(compacted))
+	for _, region := range regions {
+		if region.ColorIndex < 0 || region.ColorIndex >= len(compacted) {
+			t.Fatalf("region number %d outside 1..%d", region.ColorIndex+1, len(compacted))
+		}
+		used[region.ColorIndex] = true
+	}
+	for i, u := range used {
+		if !u {
+			t.Errorf("number %d is skipped", i+1)
+		}
+	}
+}
+
+func TestGridNumbersAndLegendShareCompactNumbering(t *testing.T) {
+	bounds := image.Rect(0, 0, 60, 30)
+	assignment := stripeAssignment(bounds.Dx(), bounds.Dy(), 1, 3)
+
+	_, palette := addGridRegionNumbers(image.NewRGBA(bounds), assignment, bounds, testPalette, 100, LabelUnionFind, false, numberStyle{})
+	if len(palette) != 2 || !colorsEqual(palette[0], testPalette[1]) || !colorsEqual(palette[1], testPalette[3]) {
+		t.Errorf("legend palette = %v, want colors 1 and 3 numbered 1 and 2", palette)
+	}
+}
+
+// rectRegion returns a region of color colorIdx covering rect
+func rectRegion(rect image.Rectangle, colorIdx int) Region {
+	region := Region{ColorIndex: colorIdx, Area: rect.Dx() * rect.Dy()}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			region.Pixels = append(region.Pixels, image.Point{X: x, Y: y})
+		}
+	}
+	region.Centroid = image.Point{X: (rect.Min.X + rect.Max.X) / 2, Y: (rect.Min.Y + rect.Max.Y) / 2}
+	return region
+}
+
+func TestNumberSpacingRepeatsLabelsInLargeRegion(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 160)
+	sky := rectRegion(bounds, 2)
+
+	for _, tc := range []struct {
+		spacing, want int
+	}{
+		{0, 1},   // Once per region
+		{400, 1}, // Spacing larger than the region
+		{50, 12}, // 4×3 full cells
+	} {
+		labels := placeRegionLabels([]Region{sky}, testPalette, bounds, numberStyle{spacing: tc.spacing})
+		if len(labels) != tc.want {
+			t.Errorf("spacing %d: %d labels, want %d", tc.spacing, len(labels), tc.want)
+		}
+		for _, l := range labels {
+			if l.text != "3" {
+				t.Errorf("spacing %d: label %q, want the region's number 3", tc.spacing, l.text)
+			}
+			for _, p := range l.glyph {
+				if !p.In(bounds) {
+					t.Fatalf("spacing %d: glyph pixel %v outside the region", tc.spacing, p)
+				}
+			}
+		}
+	}
+}
+
+func TestNumberOutlineWidth(t *testing.T) {
+	gray := color.RGBA{128, 128, 128, 255}
+	white := color.RGBA{255, 255, 255, 255}
+	for width := 0; width <= maxNumberOutline; width++ {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		draw.Draw(img, img.Bounds(), &image.Uniform{gray}, image.Point{}, draw.Src)
+		drawNumberWithStyle(img, 8, 20, 20, numberStyle{outline: width})
+
+		// The halo's thickness is the run of white right of the glyph's rightmost column
+		right, whites := -1, 0
+		for y := 0; y < 40; y++ {
+			for x := 0; x < 40; x++ {
+				if c := img.RGBAAt(x, y); c == white {
+					whites++
+				} else if c != gray && x > right {
+					right = x
+				}
+			}
+		}
+		thickest := 0
+		for y := 0; y < 40; y++ {
+			if img.RGBAAt(right, y) == white || img.RGBAAt(right, y) == gray {
+				continue
+			}
+			run := 0
+			for x := right + 1; x < 40 && img.RGBAAt(x, y) == white; x++ {
+				run++
+			}
+			if run > thickest {
+				thickest = run
+			}
+		}
+
+		if thickest != width {
+			t.Errorf("outline %d: halo is %d pixels thick", width, thickest)
+		}
+		if width == 0 && whites != 0 {
+			t.Errorf("outline 0 drew %d halo pixels", whites)
+		}
+	}
+}
+
+func TestScaledBitmapPixelsKeepsEveryStroke(t *testing.T) {
+	bitmap := digitBitmaps['8']
+	set := func(pixels []image.Point) map[image.Point]bool {
+		m := make(map[image.Point]bool, len(pixels))
+		for _, p := range pixels {
+			m[p] = true
+		}
+		return m
+	}
+
+	// 1x is the bitmap itself
+	one := set(scaledBitmapPixels(bitmap, 3, 4, 1))
+	on := 0
+	for y, row := range bitmap {
+		for x, pixel := range row {
+			if pixel {
+				on++
+			}
+			if one[image.Pt(3+x, 4+y)] != pixel {
+				t.Errorf("1x pixel %d,%d = %v, bitmap %v", x, y, !pixel, pixel)
+			}
+		}
+	}
+	if len(one) != on {
+		t.Errorf("1x render has %d pixels, the bitmap %d", len(one), on)
+	}
+
+	// 2x turns each bitmap pixel into a 2x2 block
+	two := set(scaledBitmapPixels(bitmap, 3, 4, 2))
+	if len(two) != 4*on {
+		t.Errorf("2x render has %d pixels, want %d", len(two), 4*on)
+	}
+	for y, row := range bitmap {
+		for x, pixel := range row {
+			for _, d := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				if p := image.Pt(3+2*x+d.X, 4+2*y+d.Y); two[p] != pixel {
+					t.Errorf("2x pixel %v = %v, bitmap %d,%d is %v", p, !pixel, x, y, pixel)
+				}
+			}
+		}
+	}
+}
+
+func TestGridLabelsLandOnGridInsideRegions(t *testing.T) {
+	const pitch = 8
+	bounds := image.Rect(0, 0, 120, 90)
+	assignment := randomAssignment(rand.New(rand.NewSource(4)), bounds.Dx(), bounds.Dy(), len(testPalette), 40)
+	regions := buildLabeledRegions(assignment, bounds, testPalette, 20, LabelUnionFind, false)
+	style := numberStyle{spacing: 30, grid: pitch}
+
+	snapped := 0
+	for _, region := range regions {
+		inside := make(map[image.Point]bool, len(region.Pixels))
+		onGrid := false
+		for _, p := range region.Pixels {
+			inside[p] = true
+			onGrid = onGrid || (p.X%pitch == 0 && p.Y%pitch == 0)
+		}
+		if !onGrid {
+			continue // Nothing to snap to; the labels stay where they were
+		}
+
+		for _, p := range style.positions(region) {
+			if p.X%pitch != 0 || p.Y%pitch != 0 {
+				t.Errorf("region of color %d at %v: label at %v is off the grid", region.ColorIndex, region.Centroid, p)
+			}
+			if !inside[p] {
+				t.Errorf("region of color %d at %v: label at %v is outside the region", region.ColorIndex, region.Centroid, p)
+			}
+			snapped++
+		}
+	}
+	if snapped < 10 {
+		t.Errorf("only %d labels snapped; the test image is too coarse", snapped)
+	}
+}
+
+func TestLabelMinSpacingDropsCrowdedLabel(t *testing.T) {
+	// Two small neighbors whose centroids are 12 pixels apart; the first is larger
+	bounds := image.Rect(0, 0, 50, 40)
+	regions := []Region{
+		rectRegion(image.Rect(10, 10, 24, 26), 1),
+		rectRegion(image.Rect(24, 10, 34, 26), 2),
+	}
+
+	if labels := placeRegionLabels(regions, testPalette, bounds, numberStyle{}); len(labels) != 2 {
+		t.Fatalf("without spacing %d labels, want 2", len(labels))
+	}
+
+	labels := placeRegionLabels(regions, testPalette, bounds, numberStyle{minGap: 20})
+	if len(labels) != 1 {
+		t.Fatalf("with labelMinSpacing 20, %d labels, want 1", len(labels))
+	}
+	if labels[0].text != "2" {
+		t.Errorf("kept label %q, want the larger region's 2", labels[0].text)
+	}
+
+	if labels := placeRegionLabels(regions, testPalette, bounds, numberStyle{minGap: 8}); len(labels) != 2 {
+		t.Errorf("with labelMinSpacing below their distance, %d labels, want 2", len(labels))
+	}
+}
+
+func TestHexRegionLabelFitsInsideLargeRegion(t *testing.T) {
+	bounds := image.Rect(0, 0, 160, 100)
+	large := image.Rect(10, 10, 130, 70)
+	regions := []Region{
+		rectRegion(large, 0),
+		rectRegion(image.Rect(140, 80, 148, 88), 1), // Too small for six characters
+	}
+
+	labels := placeRegionLabels(regions, testPalette, bounds, numberStyle{label: RegionLabelHex})
+	if len(labels) != 1 {
+		t.Fatalf("%d labels, want only the large region's", len(labels))
+	}
+	if labels[0].text != "DC2828" {
+		t.Errorf("label text %q, want DC2828", labels[0].text)
+	}
+
+	box := image.Rectangle{Min: labels[0].glyph[0], Max: labels[0].glyph[0].Add(image.Pt(1, 1))}
+	for _, p := range labels[0].glyph {
+		if !p.In(large) {
+			t.Fatalf("glyph pixel %v lies outside the region %v", p, large)
+		}
+		box = box.Union(image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))})
+	}
+	// Six 5-pixel-wide characters with gaps between them
+	if box.Dx() < 6*5 {
+		t.Errorf("label is %d pixels wide, too narrow for six characters", box.Dx())
+	}
+}
+
+func TestEdgeRegionNumbersDrawWhole(t *testing.T) {
+	palette := make([]color.Color, 12)
+	for i := range palette {
+		palette[i] = testPalette[i%len(testPalette)]
+	}
+	style := numberStyle{outline: 1}
+
+	// dark counts the digit pixels of a number drawn on a blank sheet
+	dark := func(img *image.RGBA) int {
+		return countPixels(img, func(c color.RGBA) bool { return c == color.RGBA{0, 0, 0, 255} })
+	}
+	whole := func(num int) int {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+		drawNumberWithStyle(img, num, 20, 20, style)
+		return dark(img)
+	}
+
+	bounds := image.Rect(0, 0, 60, 40)
+	for _, tc := range []struct {
+		name   string
+		region Region
+	}{
+		{"top left corner", rectRegion(image.Rect(0, 0, 3, 3), 0)},
+		{"right edge", rectRegion(image.Rect(57, 0, 60, 40), 11)},
+		{"bottom edge", rectRegion(image.Rect(0, 37, 60, 40), 6)},
+	} {
+		img := image.NewRGBA(bounds)
+		draw.Draw(img, bounds, image.White, image.Point{}, draw.Src)
+		drawRegionNumbers(img, []Region{tc.region}, palette, style)
+		if got, want := dark(img), whole(tc.region.ColorIndex+1); got != want {
+			t.Errorf("%s: number %d drew %d of its %d pixels", tc.name, tc.region.ColorIndex+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// canvasTileSize is the period in pixels after which the canvas texture repeats
+	canvasTileSize = 64
+	// canvasThread is the width of one woven thread of the texture in pixels
+	canvasThread = 4
+	// canvasLattice is the spacing of the smooth noise that varies the weave
+	canvasLattice = 8
+	// maxCanvasDarken is how much the darkest spot of the texture dims a fill at intensity 1
+	maxCanvasDarken = 0.35
+)
+
+// applyCanvasTexture multiplies a tileable canvas texture onto img in place: a basket
+// weave of threads canvasThread pixels wide, varied by smooth noise and a fine grain.
+// Intensity runs 0-1, where 1 dims the darkest spots by maxCanvasDarken; 0 leaves img
+// untouched. The texture is fixed, so identical inputs still give identical output.
+func applyCanvasTexture(img *image.RGBA, intensity float64) {
+	if intensity <= 0 {
+		return
+	}
+	intensity = math.Min(intensity, 1)
+
+	var factors [canvasTileSize * canvasTileSize]float64
+	for y := 0; y < canvasTileSize; y++ {
+		for x := 0; x < canvasTileSize; x++ {
+			factors[y*canvasTileSize+x] = 1 - intensity*maxCanvasDarken*canvasNoise(x, y)
+		}
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := factors[floorMod(y, canvasTileSize)*canvasTileSize:]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			f := row[floorMod(x, canvasTileSize)]
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(float64(img.Pix[i])*f + 0.5)
+			img.Pix[i+1] = uint8(float64(img.Pix[i+1])*f + 0.5)
+			img.Pix[i+2] = uint8(float64(img.Pix[i+2])*f + 0.5)
+		}
+	}
+}
+
+// canvasNoise is the texture's darkening at (x, y) within the tile, 0-1: mostly the
+// shading across each thread, with smooth noise and per-pixel grain mixed in
+func canvasNoise(x, y int) float64 {
+	// Threads alternate between running across and down in a checkerboard of squares,
+	// each brightest along its middle
+	across := x
+	if (x/canvasThread+y/canvasThread)%2 == 0 {
+		across = y
+	}
+	weave := math.Sin(math.Pi * (float64(across%canvasThread) + 0.5) / canvasThread)
+
+	// Smooth noise on a lattice that divides the tile, so it wraps seamlessly
+	const cells = canvasTileSize / canvasLattice
+	fx, fy := float64(x)/canvasLattice, float64(y)/canvasLattice
+	ix, iy := int(fx), int(fy)
+	tx, ty := smoothstep(fx-float64(ix)), smoothstep(fy-float64(iy))
+	top := lerp(latticeHash(ix%cells, iy%cells), latticeHash((ix+1)%cells, iy%cells), tx)
+	bottom := lerp(latticeHash(ix%cells, (iy+1)%cells), latticeHash((ix+1)%cells, (iy+1)%cells), tx)
+	smooth := lerp(top, bottom, ty)
+
+	grain := latticeHash(x+canvasTileSize, y+canvasTileSize)
+
+	return 0.5*(1-weave) + 0.3*smooth + 0.2*grain
+}
+
+// latticeHash maps integer coordinates to a fixed pseudo-random value in 0-1
+func latticeHash(x, y int) float64 {
+	h := uint32(x)*374761393 + uint32(y)*668265263
+	h = (h ^ (h >> 13)) * 1274126177
+	h ^= h >> 16
+	return float64(h) / math.MaxUint32
+}
+
+// smoothstep eases t in 0-1 so interpolated noise has no visible lattice creases
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+// lerp interpolates linearly from a to b by t
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// floorMod is a modulo whose result is never negative, so tiles line up across the origin
+func floorMod(a, b int) int {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTextureOnlyChangesColoredFills(t *testing.T) {
+	img := syntheticImage(128)
+	for _, showColors := range []bool{true, false} {
+		args := testSheetArgs
+		args.showColors = showColors
+		flat := mustProcessImage(t, img, args, map[string]interface{}{"seed": 3})
+		textured := mustProcessImage(t, img, args, map[string]interface{}{"seed": 3, "texture": 0.6})
+
+		if changed := textured.Image != flat.Image; changed != showColors {
+			t.Errorf("showColors=%v: texture changed the sheet: %v", showColors, changed)
+		}
+		if !reflect.DeepEqual(textured.Palette, flat.Palette) {
+			t.Errorf("showColors=%v: texture changed the palette:\n%+v\nwant\n%+v", showColors, textured.Palette, flat.Palette)
+		}
+	}
+}
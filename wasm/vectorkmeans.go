@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"math"
+	"math/rand"
+)
+
+// vectorDistanceSquared is the squared Euclidean distance between two feature vectors of
+// the same length
+func vectorDistanceSquared(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+// vectorColor rounds the first three components of a vector, RGB in the 0-255 range, to an
+// opaque color
+func vectorColor(v []float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(math.Round(v[0])),
+		G: uint8(math.Round(v[1])),
+		B: uint8(math.Round(v[2])),
+		A: 255,
+	}
+}
+
+// vectorKMeans runs k-means++ on feature vectors of one length, such as CMYK inks or color
+// plus position, for palettes clustered outside RGB. Each sample counts as weights[i] copies
+// of itself, so both seeding and centroid means are weighted; nil weights count every sample
+// once. distance compares a sample to a centroid during seeding and assignment.
+//
+// k must be between 1 and the number of distinct samples. Once ctx is done the remaining
+// centroids are picked uniformly at random, which costs nothing, and iteration stops with
+// the centroids reached so far.
+func vectorKMeans(ctx context.Context, samples [][]float64, weights []float64, k int, distance func(a, b []float64) float64, rng *rand.Rand) [][]float64 {
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+
+	// K-means++ initialization, starting from a sample picked in proportion to its weight
+	centroids := make([][]float64, 0, k)
+	centroids = append(centroids, samples[weightedPick(samples, weights, rng)])
+
+	distances := make([]float64, len(samples))
+	for len(centroids) < k {
+		if clusteringDone(ctx) {
+			centroids = append(centroids, samples[rng.Intn(len(samples))])
+			continue
+		}
+
+		totalDist := 0.0
+		for i, s := range samples {
+			minDist := math.MaxFloat64
+			for _, centroid := range centroids {
+				if dist := distance(s, centroid); dist < minDist {
+					minDist = dist
+				}
+			}
+			distances[i] = minDist * weight(i)
+			totalDist += distances[i]
+		}
+
+		target := rng.Float64() * totalDist
+		cumulative := 0.0
+		for i, dist := range distances {
+			cumulative += dist
+			if cumulative >= target {
+				centroids = append(centroids, samples[i])
+				break
+			}
+		}
+	}
+
+	// Run k-means iterations with weighted means
+	for iter := 0; iter < 15; iter++ {
+		if clusteringDone(ctx) {
+			break
+		}
+
+		sums := make([][]float64, k)
+		for i := range sums {
+			sums[i] = make([]float64, len(samples[0]))
+		}
+		totals := make([]float64, k)
+		for j, s := range samples {
+			nearest := 0
+			minDist := math.MaxFloat64
+			for i, centroid := range centroids {
+				if dist := distance(s, centroid); dist < minDist {
+					minDist = dist
+					nearest = i
+				}
+			}
+			w := weight(j)
+			for c := range s {
+				sums[nearest][c] += s[c] * w
+			}
+			totals[nearest] += w
+		}
+
+		changed := false
+		for i := range centroids {
+			if totals[i] == 0 {
+				continue
+			}
+			mean := sums[i]
+			for c := range mean {
+				mean[c] /= totals[i]
+			}
+			if vectorDistanceSquared(mean, centroids[i]) > 1e-9 {
+				centroids[i] = mean
+				changed = true
+			}
+		}
+
+		// Early stopping if converged
+		if !changed {
+			break
+		}
+	}
+
+	return centroids
+}
+
+// weightedPick returns the index of a sample picked in proportion to its weight, or
+// uniformly at random when weights is nil
+func weightedPick(samples [][]float64, weights []float64, rng *rand.Rand) int {
+	if weights == nil {
+		return rng.Intn(len(samples))
+	}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	target := rng.Float64() * total
+	for i, w := range weights {
+		if target < w {
+			return i
+		}
+		target -= w
+	}
+	return len(weights) - 1
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// vectorBlobs returns n samples of dim components scattered around blobs far apart
+func vectorBlobs(rng *rand.Rand, n, dim, blobs int) [][]float64 {
+	samples := make([][]float64, n)
+	for i := range samples {
+		s := make([]float64, dim)
+		for c := range s {
+			s[c] = float64(i%blobs*100) + rng.Float64()*10
+		}
+		samples[i] = s
+	}
+	return samples
+}
+
+func TestVectorKMeansFindsEveryBlob(t *testing.T) {
+	samples := vectorBlobs(rand.New(rand.NewSource(1)), 3000, 4, 3)
+	centroids := vectorKMeans(context.Background(), samples, nil, 3, vectorDistanceSquared, rand.New(rand.NewSource(1)))
+
+	for blob := 0; blob < 3; blob++ {
+		center := float64(blob*100) + 5
+		found := false
+		for _, c := range centroids {
+			found = found || math.Abs(c[0]-center) < 2 && math.Abs(c[3]-center) < 2
+		}
+		if !found {
+			t.Errorf("no centroid near blob %d at %.0f: %v", blob, center, centroids)
+		}
+	}
+}
+
+func TestVectorKMeansWeightsPullTheMean(t *testing.T) {
+	samples := [][]float64{{0}, {10}, {200}}
+	centroids := vectorKMeans(context.Background(), samples, []float64{9, 1, 1}, 2, vectorDistanceSquared, rand.New(rand.NewSource(1)))
+
+	low := math.Min(centroids[0][0], centroids[1][0])
+	if math.Abs(low-1) > 1e-9 {
+		t.Errorf("centroids %v, want the 0 and 10 cluster's weighted mean at 1", centroids)
+	}
+}
+
+func TestCancelledVectorKMeansSkipsSeedingWork(t *testing.T) {
+	samples := vectorBlobs(rand.New(rand.NewSource(1)), 40000, 5, 8)
+	const k = 32
+
+	started := time.Now()
+	vectorKMeans(context.Background(), samples, nil, k, vectorDistanceSquared, rand.New(rand.NewSource(1)))
+	full := time.Since(started)
+
+	// Cancelled before it starts: no distance passes over the samples at all, so every
+	// centroid is a sample picked at random and no time goes into seeding
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started = time.Now()
+	centroids := vectorKMeans(ctx, samples, nil, k, vectorDistanceSquared, rand.New(rand.NewSource(1)))
+	if elapsed := time.Since(started); elapsed > full/10 {
+		t.Errorf("cancelled clustering took %v, full run %v", elapsed, full)
+	}
+	if len(centroids) != k {
+		t.Fatalf("cancelled clustering returned %d centroids, want %d", len(centroids), k)
+	}
+}
@@ -5,58 +5,76 @@ import (
 	"image/color"
 	"math"
 	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
 	"sync"
 )
 
 // Point represents a 2D point with an associated color
 type Point struct {
-	X, Y  int
-	Color color.Color
-	Index int // Index in the points array
+	X, Y       int
+	Color      color.Color
+	Index      int // Index in the points array
+	ColorIndex int // Index in the palette once quantized
 }
 
 // ProgressCallback is called to report progress
 type ProgressCallback func(stage string, percent int)
 
+// stageProgress spreads a row-by-row pass over the percent range [from, to]. The returned
+// func takes the number of rows done and only reports when the percentage changes.
+// It returns nil when progress is nil.
+func stageProgress(progress ProgressCallback, stage string, from, to, rows int) func(done int) {
+	if progress == nil || rows <= 0 {
+		return nil
+	}
+	last := -1
+	return func(done int) {
+		percent := from + (to-from)*done/rows
+		if percent != last {
+			last = percent
+			progress(stage, percent)
+		}
+	}
+}
+
 // generateVoronoiPoints generates random points across the image
 // and samples the color from the original image at those points
 func generateVoronoiPoints(img image.Image, numPoints int) []Point {
-	return generateAdaptiveVoronoiPoints(img, numPoints, nil)
+	return generateAdaptiveVoronoiPoints(img, numPoints, nil, newRequestRand(0))
 }
 
 // generateAdaptiveVoronoiPoints uses edge detection to place more points in high-detail areas
-func generateAdaptiveVoronoiPoints(img image.Image, numPoints int, progress ProgressCallback) []Point {
+func generateAdaptiveVoronoiPoints(img image.Image, numPoints int, progress ProgressCallback, rng *rand.Rand) []Point {
+	return generateVoronoiPointsWithDistribution(img, numPoints, DistributionEdge, nil, nil, progress, rng)
+}
+
+// generateVoronoiPointsWithDistribution samples seed points with the density chosen by
+// distribution; density is the map DistributionCustom follows. With inside set (a
+// maskInside result) points only land on the pixels it selects.
+func generateVoronoiPointsWithDistribution(img image.Image, numPoints int, distribution PointDistribution, density image.Image, inside []bool, progress ProgressCallback, rng *rand.Rand) []Point {
 	bounds := img.Bounds()
 	width := bounds.Dx()
-	height := bounds.Dy()
 
-	if progress != nil {
+	if progress != nil && (distribution == DistributionEdge || distribution == DistributionMultiScale) {
 		progress("Detecting edges", 5)
 	}
-
-	// Compute edge map
-	edgeMap := computeEdgeMap(img)
-
-	// Build cumulative distribution for weighted sampling
-	totalWeight := 0.0
-	weights := make([]float64, width*height)
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			idx := y*width + x
-			// Higher edge strength = higher weight
-			weight := 1.0 + edgeMap[idx]*10.0 // Bias toward edges
-			weights[idx] = weight
-			totalWeight += weight
+	weights := distribution.weights(img, density)
+	if inside != nil {
+		for i, in := range inside {
+			if !in {
+				weights[i] = 0
+			}
 		}
 	}
 
-	// Convert to cumulative distribution
+	// Build cumulative distribution for weighted sampling
 	cumulative := make([]float64, len(weights))
-	sum := 0.0
+	totalWeight := 0.0
 	for i, w := range weights {
-		sum += w
-		cumulative[i] = sum
+		totalWeight += w
+		cumulative[i] = totalWeight
 	}
 
 	if progress != nil {
@@ -67,7 +85,7 @@ func generateAdaptiveVoronoiPoints(img image.Image, numPoints int, progress Prog
 	points := make([]Point, numPoints)
 	for i := 0; i < numPoints; i++ {
 		// Binary search to find weighted random position
-		target := rand.Float64() * totalWeight
+		target := rng.Float64() * totalWeight
 		idx := binarySearch(cumulative, target)
 
 		x := (idx % width) + bounds.Min.X
@@ -87,9 +105,64 @@ func generateAdaptiveVoronoiPoints(img image.Image, numPoints int, progress Prog
 // computeEdgeMap uses Sobel operator for edge detection
 func computeEdgeMap(img image.Image) []float64 {
 	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
+	return sobelMagnitude(grayPlane(img), bounds.Dx(), bounds.Dy())
+}
+
+// edgeScales are the blurs (Gaussian standard deviations in pixels) whose Sobel responses
+// computeMultiScaleEdgeMap adds to the unblurred one, to pick up soft, large structure
+var edgeScales = []float64{2, 6}
 
+// computeMultiScaleEdgeMap combines Sobel responses of img at its own resolution and
+// blurred by each of edgeScales. Each response is normalized to its own strongest edge and
+// the strongest per pixel kept, scaled like computeEdgeMap, so fine texture and broad soft
+// gradients both count as edges.
+func computeMultiScaleEdgeMap(img image.Image) []float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	gray := grayPlane(img)
+
+	combined := sobelMagnitude(gray, width, height)
+	finePeak := maxValue(combined)
+	if finePeak == 0 {
+		return combined
+	}
+	for i := range combined {
+		combined[i] /= finePeak
+	}
+
+	for _, sigma := range edgeScales {
+		response := sobelMagnitude(gaussianBlurPlane(gray, width, height, gaussianKernel(sigma)), width, height)
+		peak := maxValue(response)
+		if peak == 0 {
+			continue
+		}
+		for i, v := range response {
+			combined[i] = math.Max(combined[i], v/peak)
+		}
+	}
+
+	for i := range combined {
+		combined[i] *= finePeak
+	}
+	return combined
+}
+
+// grayPlane returns the luminance of every pixel of img (row-major), from 0 to 1
+func grayPlane(img image.Image) []float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	gray := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray[y*width+x] = luminance(img.At(x+bounds.Min.X, y+bounds.Min.Y))
+		}
+	}
+	return gray
+}
+
+// sobelMagnitude returns the Sobel gradient magnitude of a 0-1 gray plane; the one-pixel
+// border is left at zero
+func sobelMagnitude(gray []float64, width, height int) []float64 {
 	edgeMap := make([]float64, width*height)
 
 	// Sobel kernels
@@ -110,24 +183,56 @@ func computeEdgeMap(img image.Image) []float64 {
 
 			for dy := -1; dy <= 1; dy++ {
 				for dx := -1; dx <= 1; dx++ {
-					r, g, b, _ := img.At(x+dx+bounds.Min.X, y+dy+bounds.Min.Y).RGBA()
-					// Convert to grayscale
-					gray := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
-
-					gx += gray * float64(sobelX[dy+1][dx+1])
-					gy += gray * float64(sobelY[dy+1][dx+1])
+					v := gray[(y+dy)*width+x+dx]
+					gx += v * float64(sobelX[dy+1][dx+1])
+					gy += v * float64(sobelY[dy+1][dx+1])
 				}
 			}
 
 			// Gradient magnitude
-			magnitude := math.Sqrt(gx*gx + gy*gy)
-			edgeMap[y*width+x] = magnitude / 65535.0 // Normalize
+			edgeMap[y*width+x] = math.Sqrt(gx*gx + gy*gy)
 		}
 	}
 
 	return edgeMap
 }
 
+// maxValue returns the largest of values, or 0 if there are none
+func maxValue(values []float64) float64 {
+	peak := 0.0
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+	return peak
+}
+
+// renderEdgeMap visualizes an edge map as grayscale, scaled so the strongest edge is white
+func renderEdgeMap(edgeMap []float64, bounds image.Rectangle) image.Image {
+	img := image.NewGray(bounds)
+
+	maxEdge := 0.0
+	for _, v := range edgeMap {
+		if v > maxEdge {
+			maxEdge = v
+		}
+	}
+	if maxEdge == 0 {
+		return img
+	}
+
+	width := bounds.Dx()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := edgeMap[(y-bounds.Min.Y)*width+(x-bounds.Min.X)]
+			img.SetGray(x, y, color.Gray{Y: uint8(v / maxEdge * 255)})
+		}
+	}
+
+	return img
+}
+
 // binarySearch finds the index where value would be inserted
 func binarySearch(arr []float64, value float64) int {
 	left, right := 0, len(arr)-1
@@ -146,13 +251,16 @@ func binarySearch(arr []float64, value float64) int {
 
 // findNearestPoint finds the nearest Voronoi point to the given coordinates
 func findNearestPoint(x, y int, points []Point) int {
+	return findNearestPointWithMetric(x, y, points, CellEuclidean)
+}
+
+// findNearestPointWithMetric finds the nearest point under the given cell metric
+func findNearestPointWithMetric(x, y int, points []Point, metric CellMetric) int {
 	minDist := math.MaxFloat64
 	nearest := 0
 
 	for i, p := range points {
-		dx := float64(x - p.X)
-		dy := float64(y - p.Y)
-		dist := dx*dx + dy*dy
+		dist := metric.distance(x, y, p.X, p.Y)
 
 		if dist < minDist {
 			minDist = dist
@@ -163,76 +271,112 @@ func findNearestPoint(x, y int, points []Point) int {
 	return nearest
 }
 
+// FillConfig controls how the Voronoi fill is split across goroutines. Rows are handed out
+// in chunks of RowsPerChunk to Workers goroutines as each finishes its last chunk.
+type FillConfig struct {
+	Workers      int // Goroutines filling rows (0 = runtime.NumCPU())
+	RowsPerChunk int // Rows a goroutine fills per chunk (0 = split the rows evenly across Workers)
+}
+
+// fillConfigFromEnv reads a FillConfig from PBN_FILL_WORKERS and PBN_FILL_ROWS, leaving
+// unset or invalid values at their defaults
+func fillConfigFromEnv() FillConfig {
+	var c FillConfig
+	if n, err := strconv.Atoi(os.Getenv("PBN_FILL_WORKERS")); err == nil && n > 0 {
+		c.Workers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("PBN_FILL_ROWS")); err == nil && n > 0 {
+		c.RowsPerChunk = n
+	}
+	return c
+}
+
+// resolve returns the worker count and chunk size to fill height rows with
+func (c FillConfig) resolve(height int) (workers, rowsPerChunk int) {
+	workers = c.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	rowsPerChunk = c.RowsPerChunk
+	if rowsPerChunk <= 0 {
+		rowsPerChunk = (height + workers - 1) / workers
+	}
+	if rowsPerChunk < 1 {
+		rowsPerChunk = 1
+	}
+	return workers, rowsPerChunk
+}
+
 // createVoronoiDiagram creates a Voronoi diagram from the given points
-func createVoronoiDiagram(bounds image.Rectangle, points []Point) (*image.RGBA, *KDTree) {
-	return createVoronoiDiagramWithProgress(bounds, points, nil)
+func createVoronoiDiagram(bounds image.Rectangle, points []Point) (*image.RGBA, NearestIndex) {
+	return createVoronoiDiagramWithProgress(bounds, points, FillConfig{}, nil)
 }
 
 // createVoronoiDiagramWithProgress creates a Voronoi diagram with progress reporting
-func createVoronoiDiagramWithProgress(bounds image.Rectangle, points []Point, progress ProgressCallback) (*image.RGBA, *KDTree) {
+func createVoronoiDiagramWithProgress(bounds image.Rectangle, points []Point, fill FillConfig, progress ProgressCallback) (*image.RGBA, NearestIndex) {
+	return createVoronoiDiagramWithMetric(bounds, points, CellEuclidean, PointIndexKDTree, fill, progress)
+}
+
+// createVoronoiDiagramWithMetric creates a Voronoi diagram whose cells follow the given
+// metric, looking seeds up with the given index and filling rows in parallel as fill configures
+func createVoronoiDiagramWithMetric(bounds image.Rectangle, points []Point, metric CellMetric, index PointIndex, fill FillConfig, progress ProgressCallback) (*image.RGBA, NearestIndex) {
 	img := image.NewRGBA(bounds)
 
 	if progress != nil {
 		progress("Building spatial index", 25)
 	}
 
-	// Build k-d tree for fast nearest neighbor queries
-	kdtree := NewKDTree(points)
+	// Build the k-d tree or spatial hash for fast nearest neighbor queries
+	nearest := index.build(points, metric)
 
 	if progress != nil {
 		progress("Creating regions", 30)
 	}
 
-	// Parallelize row processing
+	// Parallelize row processing: workers take the next chunk of rows until none are left
 	height := bounds.Dy()
-	numWorkers := 8
-	rowsPerWorker := (height + numWorkers - 1) / numWorkers
+	numWorkers, rowsPerChunk := fill.resolve(height)
+	numChunks := (height + rowsPerChunk - 1) / rowsPerChunk
+	numWorkers = min(numWorkers, numChunks)
 
-	var wg sync.WaitGroup
-	progressChan := make(chan int, numWorkers)
-
-	// Progress tracking goroutine
-	if progress != nil {
-		go func() {
-			completed := 0
-			for range progressChan {
-				completed++
-				percent := 30 + (completed*40)/numWorkers
-				progress("Creating regions", percent)
-			}
-		}()
+	chunks := make(chan int, numChunks)
+	for c := 0; c < numChunks; c++ {
+		chunks <- c
 	}
+	close(chunks)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed := 0
 
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
-		startY := w * rowsPerWorker
-		endY := (w + 1) * rowsPerWorker
-		if endY > height {
-			endY = height
-		}
-
-		go func(sy, ey int) {
+		go func() {
 			defer wg.Done()
 
-			for y := sy; y < ey; y++ {
-				for x := 0; x < bounds.Dx(); x++ {
-					actualX := x + bounds.Min.X
-					actualY := y + bounds.Min.Y
-					nearestIdx := kdtree.FindNearest(actualX, actualY)
-					img.Set(actualX, actualY, points[nearestIdx].Color)
+			for c := range chunks {
+				startY := c * rowsPerChunk
+				endY := min(startY+rowsPerChunk, height)
+				for y := startY; y < endY; y++ {
+					for x := 0; x < bounds.Dx(); x++ {
+						actualX := x + bounds.Min.X
+						actualY := y + bounds.Min.Y
+						nearestIdx := nearest.FindNearest(actualX, actualY)
+						img.Set(actualX, actualY, points[nearestIdx].Color)
+					}
 				}
-			}
 
-			if progress != nil {
-				progressChan <- 1
+				if progress != nil {
+					mu.Lock()
+					completed++
+					progress("Creating regions", 30+(completed*40)/numChunks)
+					mu.Unlock()
+				}
 			}
-		}(startY, endY)
+		}()
 	}
 
 	wg.Wait()
-	if progress != nil {
-		close(progressChan)
-	}
 
-	return img, kdtree
+	return img, nearest
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestRenderEdgeMapShowsHighContrastEdge(t *testing.T) {
+	// Black left half, white right half: one vertical edge at x = 32
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(32, 0, 64, 32), image.White, image.Point{}, draw.Src)
+
+	vis := renderEdgeMap(computeEdgeMap(img), img.Bounds())
+	gray := func(x, y int) uint8 { return color.GrayModel.Convert(vis.At(x, y)).(color.Gray).Y }
+
+	for y := 2; y < 30; y++ {
+		if v := max(float64(gray(31, y)), float64(gray(32, y))); v < 200 {
+			t.Errorf("edge at row %d = %.0f, want bright", y, v)
+		}
+		if gray(8, y) != 0 || gray(56, y) != 0 {
+			t.Errorf("flat areas at row %d = %d, %d, want black", y, gray(8, y), gray(56, y))
+		}
+	}
+}
+
+func TestManhattanCellsHaveDiamondBoundary(t *testing.T) {
+	// Seeds 24 apart horizontally and 16 vertically. The Manhattan bisector is vertical at
+	// x = 40 above the seeds, the 45° diagonal x + y = 60 between them, and vertical at
+	// x = 24 below; the Euclidean one is a single slanted line.
+	points := []Point{{X: 20, Y: 20, Index: 0}, {X: 44, Y: 36, Index: 1}}
+	tree := NewKDTreeWithMetric(points, CellManhattan)
+
+	for y := 0; y < 60; y++ {
+		boundary := -1
+		for x := 0; x < 64; x++ {
+			owner := tree.FindNearest(x, y)
+			if owner != findNearestPointWithMetric(x, y, points, CellManhattan) {
+				t.Fatalf("k-d tree and brute force disagree at %d,%d", x, y)
+			}
+			if owner == 1 && boundary < 0 {
+				boundary = x
+			}
+		}
+
+		want := 60 - y
+		if y < 20 {
+			want = 40
+		} else if y > 36 {
+			want = 24
+		}
+		if boundary < want || boundary > want+1 {
+			t.Errorf("row %d: second cell starts at x = %d, want %d", y, boundary, want)
+		}
+	}
+}
+
+func TestPointDistributionUniformAndEdge(t *testing.T) {
+	// Black and white halves meeting at x = 64
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 64, 128), image.Black, image.Point{}, draw.Src)
+	const n = 2000
+
+	uniform := generateVoronoiPointsWithDistribution(img, n, DistributionUniform, nil, nil, nil, rand.New(rand.NewSource(1)))
+	var quadrants [4]int
+	for _, p := range uniform {
+		quadrants[p.X/64+2*(p.Y/64)]++
+	}
+	for q, count := range quadrants {
+		if count < n/4*8/10 || count > n/4*12/10 {
+			t.Errorf("uniform: quadrant %d has %d of %d points, want about %d", q, count, n, n/4)
+		}
+	}
+
+	// The 8-pixel strip along the edge is 1/16 of the image
+	edge := generateVoronoiPointsWithDistribution(img, n, DistributionEdge, nil, nil, nil, rand.New(rand.NewSource(1)))
+	near := 0
+	for _, p := range edge {
+		if p.X >= 60 && p.X < 68 {
+			near++
+		}
+	}
+	if near < 3*n/16 {
+		t.Errorf("edge: %d of %d points near the edge, want well over the %d of an even spread", near, n, n/16)
+	}
+}
+
+func TestMultiScaleEdgeMapFindsFineAndBroadEdges(t *testing.T) {
+	// A slow top-to-bottom ramp everywhere, with a fine checkerboard over the left half
+	const size = 128
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := 64 + y
+			if x < size/2 {
+				v += 24 * ((x/2+y/2)%2*2 - 1)
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+
+	// strength is the mean edge response inside a rectangle, clear of the image border
+	strength := func(edges []float64, rect image.Rectangle) float64 {
+		sum := 0.0
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				sum += edges[y*size+x]
+			}
+		}
+		return sum / float64(rect.Dx()*rect.Dy())
+	}
+	texture, ramp := image.Rect(16, 16, 48, 112), image.Rect(80, 16, 112, 112)
+
+	single, multi := computeEdgeMap(img), computeMultiScaleEdgeMap(img)
+	if r := strength(single, ramp) / strength(single, texture); r > 0.2 {
+		t.Errorf("single-scale ramp response is %.2f of the texture's, want the ramp nearly missed", r)
+	}
+	if r := strength(multi, ramp) / strength(multi, texture); r < 0.5 {
+		t.Errorf("multi-scale ramp response is %.2f of the texture's, want both strong", r)
+	}
+	if strength(multi, texture) < strength(single, texture) {
+		t.Errorf("multi-scale texture response %.4f is below single-scale %.4f", strength(multi, texture), strength(single, texture))
+	}
+}
+
+func TestFillConfigDoesNotChangePixels(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 150)
+	points := randomPoints(rand.New(rand.NewSource(1)), 300, 150)
+	for i := range points {
+		points[i].Color = testPalette[i%len(testPalette)]
+	}
+
+	var want *image.RGBA
+	for _, fill := range []FillConfig{{Workers: 1}, {Workers: runtime.NumCPU()}, {Workers: 3, RowsPerChunk: 7}} {
+		var mu sync.Mutex
+		var percents []int
+		img, _ := createVoronoiDiagramWithProgress(bounds, points, fill, func(stage string, percent int) {
+			mu.Lock()
+			percents = append(percents, percent)
+			mu.Unlock()
+		})
+
+		if want == nil {
+			want = img
+		} else if !bytes.Equal(img.Pix, want.Pix) {
+			t.Errorf("%+v: pixels differ from a single worker's", fill)
+		}
+		if len(percents) == 0 {
+			t.Errorf("%+v: no progress reported", fill)
+		}
+		for _, p := range percents {
+			if p < 0 || p > 100 {
+				t.Errorf("%+v: progress %d%% out of range", fill, p)
+			}
+		}
+	}
+}
@@ -4,80 +4,423 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"sync"
 )
 
 // convertToPaintByNumbersWithParams is the main entry point with line width support
 func convertToPaintByNumbersWithParams(img image.Image, numPoints, numColors, lineWidth int) (image.Image, []color.Color) {
-	return convertToPaintByNumbersWithParamsAndColors(img, numPoints, numColors, lineWidth, true)
+	return convertToPaintByNumbersWithParamsAndColors(img, numPoints, numColors, lineWidth, true, ProcessOptions{})
 }
 
 // convertToPaintByNumbersWithMode supports both Voronoi and Grid modes
 func convertToPaintByNumbersWithMode(img image.Image, numPoints, numColors, lineWidth int, showColors bool, useVoronoi bool) (image.Image, []color.Color) {
+	return convertToPaintByNumbersWithOptions(img, numPoints, numColors, lineWidth, showColors, useVoronoi, ProcessOptions{})
+}
+
+// convertToPaintByNumbersWithOptions supports both modes plus the optional settings object
+func convertToPaintByNumbersWithOptions(img image.Image, numPoints, numColors, lineWidth int, showColors bool, useVoronoi bool, opts ProcessOptions) (image.Image, []color.Color) {
+	return prepareLayout(img, numPoints, numColors, useVoronoi, opts).render(lineWidth, showColors)
+}
+
+// convertToPaintByNumbersWithParamsAndColors allows toggling color display
+func convertToPaintByNumbersWithParamsAndColors(img image.Image, numPoints, numColors, lineWidth int, showColors bool, opts ProcessOptions) (image.Image, []color.Color) {
+	return prepareVoronoiLayout(img, numPoints, numColors, opts).render(lineWidth, showColors)
+}
+
+// sheetStyle fixes which passes render draws, independent of its arguments
+type sheetStyle struct {
+	fill    bool // Paint regions in their palette colors instead of white
+	numbers bool // Number the regions, whatever the line width
+	faint   bool // Default borders to faintBorderColor so they don't compete with the colors
+}
+
+// sheetStyles are the named styles accepted by the style option
+var sheetStyles = map[string]*sheetStyle{
+	"reference": {fill: true, numbers: true, faint: true},
+	"sheet":     {fill: false, numbers: true},
+	"mosaic":    {fill: true, numbers: false},
+	"lineart":   {fill: false, numbers: false},
+}
+
+// faintBorderColor is the light gray of borders in the reference style
+var faintBorderColor = color.RGBA{190, 190, 190, 255}
+
+// sheetLayout holds the randomized analysis of an image (palette plus seed points or
+// per-pixel color indices) so it can be rendered more than once with identical regions
+type sheetLayout struct {
+	bounds        image.Rectangle
+	palette       []color.Color
+	points        []Point        // Quantized seed points (Voronoi mode only)
+	cellMetric    CellMetric     // Distance that shapes the Voronoi cells
+	pointIndex    PointIndex     // K-d tree or spatial hash finding each pixel's seed point
+	supersample   int            // Render colored cells at this multiple of the resolution (0 or 1 = off)
+	colorBorders  bool           // Draw Voronoi borders only where the palette color changes
+	colorIndices  []int          // Per-pixel palette indices (computed lazily in Voronoi mode)
+	minArea       int            // Smallest region in pixels that gets a number
+	labeling      LabelAlgorithm // Connected-component algorithm used when numbering
+	keepAllColors bool           // Keep one region for colors whose regions are all below minArea
+	numbers       numberStyle    // Spacing, outline, size and color of the region numbers
+	borderColor   color.Color    // Color of region borders (nil = black)
+	connectivity  Connectivity   // Neighbors compared to find border pixels
+	style         *sheetStyle    // Fixed fill and numbering, overriding render's arguments (nil = decide per call)
+	trace         image.Image    // Original shown faintly through blank fills (nil = plain white)
+	traceOpacity  float64        // Opacity of trace over the white, 0-1
+	texture       float64        // Canvas texture intensity over colored fills, 0-1 (0 = flat)
+
+	mask           []bool      // Pixels to process, row-major; the rest are maskedOut (nil = all)
+	maskBackground color.Color // Plain color of the pixels outside mask (nil = white)
+
+	stage  PipelineStage // How far render goes (StageFinal = the finished sheet)
+	source image.Image   // The analyzed image, kept for StageRaw only
+
+	stippleRadius int         // Draw dots at the seed points instead of cells (0 = off)
+	background    color.Color // Background behind stipple dots
+
+	fill     FillConfig       // How the Voronoi fill is split across goroutines
+	progress ProgressCallback // Receives stage reports while rendering (nil = none)
+}
+
+// prepareLayout analyzes an image for either Voronoi or grid rendering
+func prepareLayout(img image.Image, numPoints, numColors int, useVoronoi bool, opts ProcessOptions) *sheetLayout {
+	opts = opts.simplified(img.Bounds())
 	if useVoronoi {
-		return convertToPaintByNumbersWithParamsAndColors(img, numPoints, numColors, lineWidth, showColors)
+		return prepareVoronoiLayout(img, numPoints, numColors, opts)
 	}
-	return convertToGridPaintByNumbers(img, numColors, lineWidth, showColors)
+	return prepareGridLayout(img, numColors, opts)
 }
 
-// convertToPaintByNumbersWithParamsAndColors allows toggling color display
-func convertToPaintByNumbersWithParamsAndColors(img image.Image, numPoints, numColors, lineWidth int, showColors bool) (image.Image, []color.Color) {
-	bounds := img.Bounds()
+// prepareVoronoiLayout generates the palette and quantized seed points
+func prepareVoronoiLayout(img image.Image, numPoints, numColors int, opts ProcessOptions) *sheetLayout {
+	opts = opts.reproducible()
+	rng := newRequestRand(opts.Seed)
 
 	// Step 1: Generate color palette
-	palette := generatePalette(img, numColors)
+	if opts.Progress != nil {
+		opts.Progress("Generating color palette", 0)
+	}
+	palette := layoutPalette(img, numColors, opts, rng)
 
-	// Step 2: Generate Voronoi points with adaptive distribution
-	points := generateAdaptiveVoronoiPoints(img, numPoints, nil)
+	// Step 2: Generate Voronoi points with adaptive distribution, only where the mask allows
+	inside := maskInside(opts.Mask, img.Bounds())
+	var points []Point
+	if opts.FeaturePoints {
+		points = generateFeatureVoronoiPoints(img, numPoints, opts.Distribution, opts.DensityMap, inside, opts.Progress, rng)
+	} else {
+		points = generateVoronoiPointsWithDistribution(img, numPoints, opts.Distribution, opts.DensityMap, inside, opts.Progress, rng)
+	}
 
 	// Step 3: Quantize points to palette colors
-	quantizedPoints := quantizePoints(points, palette)
+	if opts.Progress != nil {
+		opts.Progress("Quantizing points", 20)
+	}
+	quantizedPoints := quantizePointsWithMetric(points, palette, opts.ColorMetric)
+
+	// Recoloring moves the seed points themselves, so recipes record the result as is
+	layout := newVoronoiLayout(img, palette, quantizedPoints, opts)
+	if opts.LocalContrast {
+		layout.applyLocalContrast(img)
+	}
+	return layout
+}
 
+// newVoronoiLayout wraps an existing palette and quantized seed points for img in a layout
+func newVoronoiLayout(img image.Image, palette []color.Color, quantizedPoints []Point, opts ProcessOptions) *sheetLayout {
+	bounds := img.Bounds()
+	return &sheetLayout{
+		bounds:        bounds,
+		palette:       palette,
+		points:        quantizedPoints,
+		cellMetric:    opts.CellMetric,
+		pointIndex:    opts.PointIndex.resolve(opts.Distribution),
+		supersample:   opts.Supersample,
+		colorBorders:  opts.ColorBorders,
+		minArea:       opts.MinRegionArea.resolve(bounds),
+		labeling:      opts.Labeling,
+		keepAllColors: opts.KeepAllColors,
+		numbers:       opts.numberStyle(),
+		borderColor:   opts.borderColor(),
+		connectivity:  opts.Connectivity,
+		style:         sheetStyles[opts.Style],
+		trace:         traceImage(img, opts.TraceOpacity),
+		traceOpacity:  opts.TraceOpacity,
+		texture:       opts.Texture,
+
+		mask:           maskInside(opts.Mask, bounds),
+		maskBackground: opts.MaskBackground,
+
+		stage:  opts.Stage,
+		source: stageSource(img, opts.Stage),
+
+		stippleRadius: opts.StippleRadius,
+		background:    opts.Background,
+
+		fill:     opts.Fill,
+		progress: opts.Progress,
+	}
+}
+
+// report passes a stage to the layout's progress callback, if any
+func (l *sheetLayout) report(stage string, percent int) {
+	if l.progress != nil {
+		l.progress(stage, percent)
+	}
+}
+
+// render draws the layout as a colored or blank sheet and returns the palette numbered to match
+func (l *sheetLayout) render(lineWidth int, showColors bool) (image.Image, []color.Color) {
+	if l.style != nil {
+		showColors = l.style.fill
+	}
+	switch l.stage {
+	case StageRaw:
+		return l.renderRaw(), l.palette
+	case StageQuantized:
+		showColors = true // Blank regions would show nothing of the quantization
+	}
+	if l.points == nil {
+		return l.renderGrid(lineWidth, showColors)
+	}
+	if l.stippleRadius > 0 {
+		l.report("Drawing stipple", 30)
+		return renderStipple(l.points, l.palette, l.bounds, l.stippleRadius, l.background), l.palette
+	}
+	return l.renderVoronoi(lineWidth, showColors)
+}
+
+// showNumbers reports whether render numbers the regions: never before StageFinal, and
+// without a style depending on the line width, since thick borders leave too little room
+// for the digits.
+func (l *sheetLayout) showNumbers(lineWidth int) bool {
+	if l.stage != StageFinal {
+		return false
+	}
+	if l.style != nil {
+		return l.style.numbers
+	}
+	return lineWidth <= 2
+}
+
+// renderLabels draws only the numbers render adds for lineWidth, halo included, on a
+// transparent canvas of the same bounds, so they can be shown or hidden over the sheet
+func (l *sheetLayout) renderLabels(lineWidth int) *image.RGBA {
+	labels := image.NewRGBA(l.bounds)
+	if !l.showNumbers(lineWidth) || (l.points != nil && l.stippleRadius > 0) {
+		return labels
+	}
+
+	// Same regions and numbering as render, which labels the same assignment
+	regions := buildLabeledRegions(l.assignment(), l.bounds, l.palette, l.minArea, l.labeling, l.keepAllColors)
+	palette := l.numbers.numbering(regions, l.palette)
+	drawRegionNumbers(labels, regions, palette, l.numbers)
+
+	return labels
+}
+
+// assignment returns the palette index of every pixel, maskedOut outside the mask,
+// computing it once for Voronoi layouts
+func (l *sheetLayout) assignment() []int {
+	if l.colorIndices == nil {
+		l.colorIndices = buildVoronoiAssignment(l.bounds, l.points, l.pointIndex.build(l.points, l.cellMetric), nil)
+		clipAssignment(l.colorIndices, l.mask)
+	}
+	return l.colorIndices
+}
+
+// paletteCoverage returns the fraction of pixels painted in each color of palette, out of
+// the pixels inside the mask. Colors are matched by value, so a compacted palette from
+// render works as well.
+func (l *sheetLayout) paletteCoverage(palette []color.Color) []float64 {
+	counts := make([]int, len(l.palette))
+	total := 0
+	for _, idx := range l.assignment() {
+		if idx != maskedOut {
+			counts[idx]++
+			total++
+		}
+	}
+
+	coverage := make([]float64, len(palette))
+	if total == 0 {
+		return coverage
+	}
+	for i, c := range palette {
+		for j, p := range l.palette {
+			if counts[j] > 0 && colorsEqual(c, p) {
+				coverage[i] = float64(counts[j]) / float64(total)
+				break
+			}
+		}
+	}
+
+	return coverage
+}
+
+// regionsByColor groups the layout's numbered regions by their number in palette
+func (l *sheetLayout) regionsByColor(palette []color.Color) []ColorRegions {
+	regions := buildLabeledRegions(l.assignment(), l.bounds, l.palette, l.minArea, l.labeling, l.keepAllColors)
+
+	result := make([]ColorRegions, len(palette))
+	for i := range result {
+		result[i].Number = i + 1
+		result[i].Regions = []RegionBox{}
+	}
+
+	for _, region := range regions {
+		for i, c := range palette {
+			if !colorsEqual(c, l.palette[region.ColorIndex]) {
+				continue
+			}
+			box := regionBounds(region).Sub(l.bounds.Min)
+			result[i].Regions = append(result[i].Regions, RegionBox{
+				X:         box.Min.X,
+				Y:         box.Min.Y,
+				W:         box.Dx(),
+				H:         box.Dy(),
+				CentroidX: region.Centroid.X - l.bounds.Min.X,
+				CentroidY: region.Centroid.Y - l.bounds.Min.Y,
+				Area:      region.Area,
+			})
+			break
+		}
+	}
+
+	return result
+}
+
+// renderVoronoi draws the Voronoi cells, borders and numbers
+func (l *sheetLayout) renderVoronoi(lineWidth int, showColors bool) (image.Image, []color.Color) {
 	// Step 4: Create Voronoi diagram
+	l.report("Creating regions", 30)
 	var voronoi *image.RGBA
-	var kdtree *KDTree
+	var index NearestIndex
 
-	if showColors {
+	if showColors && l.supersample > 1 {
+		// Colored version with antialiased cell edges
+		voronoi = createSupersampledVoronoiDiagram(l.bounds, l.points, l.cellMetric, l.pointIndex, l.supersample)
+		index = l.pointIndex.build(l.points, l.cellMetric)
+	} else if showColors {
 		// Normal colored version
-		voronoi, kdtree = createVoronoiDiagramWithProgress(bounds, quantizedPoints, nil)
+		voronoi, index = createVoronoiDiagramWithMetric(l.bounds, l.points, l.cellMetric, l.pointIndex, l.fill, nil)
 	} else {
 		// White/blank version (for coloring in)
-		voronoi, kdtree = createBlankVoronoiDiagram(bounds, quantizedPoints)
+		voronoi, index = createBlankVoronoiDiagram(l.bounds, l.points, l.cellMetric, l.pointIndex, l.trace, l.traceOpacity)
+	}
+	if showColors {
+		applyCanvasTexture(voronoi, l.texture)
+	}
+	if l.stage == StageQuantized {
+		l.applyMask(voronoi, 0)
+		return voronoi, l.palette
 	}
 
-	// Step 5: Add borders with specified width
-	result := addVoronoiBordersWithWidth(voronoi, quantizedPoints, lineWidth)
-
-	// Step 6: Add region numbers if there's space
-	if lineWidth <= 2 {
-		result = addRegionNumbers(result, quantizedPoints, kdtree)
+	// Step 5: Add borders with specified width, around every cell or only between colors
+	l.report("Drawing borders", 70)
+	result := voronoi
+	if l.colorBorders {
+		drawColorBorders(result, l.assignment(), lineWidth, l.connectivity, l.borderColor)
+	} else {
+		result = addVoronoiBordersWithWidth(voronoi, l.points, lineWidth, l.cellMetric, l.connectivity, l.borderColor)
+	}
+	l.applyMask(result, lineWidth)
+
+	// Step 6: Add region numbers if there's space. Masked regions come from the clipped
+	// assignment, since cells cut by the mask edge only count their inside part.
+	palette := l.palette
+	if l.showNumbers(lineWidth) && l.mask != nil {
+		l.report("Adding numbers", 85)
+		result, palette = addGridRegionNumbers(result, l.assignment(), l.bounds, palette, l.minArea, l.labeling, l.keepAllColors, l.numbers)
+	} else if l.showNumbers(lineWidth) {
+		result, palette = addRegionNumbers(result, l.points, index, palette, l.minArea, l.labeling, l.keepAllColors, l.numbers, l.progress)
 	}
 
 	return result, palette
 }
 
-// createBlankVoronoiDiagram creates a white diagram with regions defined but not colored
-func createBlankVoronoiDiagram(bounds image.Rectangle, points []Point) (*image.RGBA, *KDTree) {
+// createSupersampledVoronoiDiagram fills each pixel with the average color of factor×factor
+// subpixel samples, so pixels straddling a cell edge blend the neighboring colors
+func createSupersampledVoronoiDiagram(bounds image.Rectangle, points []Point, metric CellMetric, index PointIndex, factor int) *image.RGBA {
+	img := image.NewRGBA(bounds)
+
+	// Seed points sit at the center of their pixel on the finer grid
+	scaled := make([]Point, len(points))
+	for i, p := range points {
+		scaled[i] = p
+		scaled[i].X = (p.X-bounds.Min.X)*factor + factor/2
+		scaled[i].Y = (p.Y-bounds.Min.Y)*factor + factor/2
+	}
+	nearestIndex := index.build(scaled, metric)
+
+	samples := uint32(factor * factor)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			var sumR, sumG, sumB, sumA uint32
+			for sy := 0; sy < factor; sy++ {
+				for sx := 0; sx < factor; sx++ {
+					nearest := nearestIndex.FindNearest(x*factor+sx, y*factor+sy)
+					r, g, b, a := points[nearest].Color.RGBA()
+					sumR += r >> 8
+					sumG += g >> 8
+					sumB += b >> 8
+					sumA += a >> 8
+				}
+			}
+			img.SetRGBA(x+bounds.Min.X, y+bounds.Min.Y, color.RGBA{
+				R: uint8((sumR + samples/2) / samples),
+				G: uint8((sumG + samples/2) / samples),
+				B: uint8((sumB + samples/2) / samples),
+				A: uint8((sumA + samples/2) / samples),
+			})
+		}
+	}
+
+	return img
+}
+
+// createBlankVoronoiDiagram creates a white diagram with regions defined but not colored,
+// with trace (if any) showing through the white at the given opacity
+func createBlankVoronoiDiagram(bounds image.Rectangle, points []Point, metric CellMetric, index PointIndex, trace image.Image, traceOpacity float64) (*image.RGBA, NearestIndex) {
 	img := image.NewRGBA(bounds)
 
 	// Fill with white
-	white := color.RGBA{255, 255, 255, 255}
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			img.Set(x, y, white)
+			img.Set(x, y, blankFill(trace, traceOpacity, x, y))
 		}
 	}
 
-	// Build k-d tree for region identification
-	kdtree := NewKDTree(points)
+	// Build the k-d tree or spatial hash for region identification
+	return img, index.build(points, metric)
+}
 
-	return img, kdtree
+// traceImage returns img when blank fills should show it at opacity, nil otherwise
+func traceImage(img image.Image, opacity float64) image.Image {
+	if opacity <= 0 {
+		return nil
+	}
+	return img
+}
+
+// blankFill is the color of pixel (x, y) on a blank sheet: white, or white blended with
+// trace at opacity so the original shows through faintly as a tracing guide
+func blankFill(trace image.Image, opacity float64, x, y int) color.RGBA {
+	if trace == nil || opacity <= 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	r, g, b, _ := trace.At(x, y).RGBA()
+	blend := func(v uint32) uint8 {
+		return uint8(255*(1-opacity) + float64(v>>8)*opacity + 0.5)
+	}
+	return color.RGBA{blend(r), blend(g), blend(b), 255}
 }
 
-// addVoronoiBordersWithWidth adds borders with configurable width
-func addVoronoiBordersWithWidth(img *image.RGBA, points []Point, width int) *image.RGBA {
+// addVoronoiBordersWithWidth adds borders with configurable width, connectivity and color (nil = black)
+func addVoronoiBordersWithWidth(img *image.RGBA, points []Point, width int, metric CellMetric, connectivity Connectivity, borderColor color.Color) *image.RGBA {
 	if width == 0 {
 		return img // No borders
 	}
+	if borderColor == nil {
+		borderColor = color.RGBA{0, 0, 0, 255}
+	}
 
 	bounds := img.Bounds()
 	result := image.NewRGBA(bounds)
@@ -92,8 +435,8 @@ func addVoronoiBordersWithWidth(img *image.RGBA, points []Point, width int) *ima
 	// Draw borders
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			if isBorderPixelWithWidth(x, y, img, points, width) {
-				result.Set(x, y, color.RGBA{0, 0, 0, 255})
+			if isBorderPixelWithWidth(x, y, img, points, width, metric, connectivity) {
+				result.Set(x, y, borderColor)
 			}
 		}
 	}
@@ -101,23 +444,18 @@ func addVoronoiBordersWithWidth(img *image.RGBA, points []Point, width int) *ima
 	return result
 }
 
-// isBorderPixelWithWidth checks if pixel should be part of border with given width
-func isBorderPixelWithWidth(x, y int, img *image.RGBA, points []Point, width int) bool {
+// isBorderPixelWithWidth checks if pixel should be part of border with given width, comparing
+// the neighbors connectivity selects within the line radius
+func isBorderPixelWithWidth(x, y int, img *image.RGBA, points []Point, width int, metric CellMetric, connectivity Connectivity) bool {
 	bounds := img.Bounds()
-	current := findNearestPoint(x, y, points)
+	current := findNearestPointWithMetric(x, y, points, metric)
 
 	// Check neighbors in a radius based on width
 	radius := (width + 1) / 2
 
 	for dy := -radius; dy <= radius; dy++ {
 		for dx := -radius; dx <= radius; dx++ {
-			// Skip center pixel
-			if dx == 0 && dy == 0 {
-				continue
-			}
-
-			// Only check neighbors within radius
-			if dx*dx+dy*dy > radius*radius {
+			if !connectivity.within(dx, dy, radius) {
 				continue
 			}
 
@@ -128,7 +466,7 @@ func isBorderPixelWithWidth(x, y int, img *image.RGBA, points []Point, width int
 				continue
 			}
 
-			neighbor := findNearestPoint(nx, ny, points)
+			neighbor := findNearestPointWithMetric(nx, ny, points, metric)
 			if neighbor != current {
 				return true
 			}
@@ -171,57 +509,185 @@ func colorToHex(c color.Color) string {
 	return fmt.Sprintf("#%02x%02x%02x", uint8(r>>8), uint8(g>>8), uint8(b>>8))
 }
 
+// parseHexColor parses a "#rrggbb" color as written by colorToHex, or "#rrggbbaa" with alpha
+func parseHexColor(s string) (color.RGBA, bool) {
+	var r, g, b uint8
+	a := uint8(255)
+	if (len(s) != 7 && len(s) != 9) || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	if _, err := fmt.Sscanf(s[1:7], "%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{}, false
+	}
+	if len(s) == 9 {
+		if _, err := fmt.Sscanf(s[7:], "%02x", &a); err != nil {
+			return color.RGBA{}, false
+		}
+	}
+	return color.RGBA{r, g, b, a}, true
+}
+
 // convertToGridPaintByNumbers creates a grid-based paint by numbers (no voronoi)
-func convertToGridPaintByNumbers(img image.Image, numColors, lineWidth int, showColors bool) (image.Image, []color.Color) {
-	bounds := img.Bounds()
+func convertToGridPaintByNumbers(img image.Image, numColors, lineWidth int, showColors bool, opts ProcessOptions) (image.Image, []color.Color) {
+	return prepareGridLayout(img, numColors, opts).render(lineWidth, showColors)
+}
 
+// prepareGridLayout generates the palette and quantizes each pixel to it
+func prepareGridLayout(img image.Image, numColors int, opts ProcessOptions) *sheetLayout {
+	opts = opts.reproducible()
 	// Step 1: Generate color palette
-	palette := generatePalette(img, numColors)
+	if opts.Progress != nil {
+		opts.Progress("Generating color palette", 0)
+	}
+	palette := layoutPalette(img, numColors, opts, newRequestRand(opts.Seed))
 
 	// Step 2: Quantize each pixel to nearest palette color
-	quantized := image.NewRGBA(bounds)
-	colorIndices := make([]int, bounds.Dx()*bounds.Dy())
+	if opts.Progress != nil {
+		opts.Progress("Quantizing pixels", 20)
+	}
+	return newGridLayout(img, palette, opts)
+}
 
+// newGridLayout quantizes every pixel of img to an existing palette
+func newGridLayout(img image.Image, palette []color.Color, opts ProcessOptions) *sheetLayout {
+	bounds := img.Bounds()
+	colorIndices := quantizeGrid(img, palette, opts.ColorMetric, 8)
+	despeckleAssignment(colorIndices, bounds, opts.Despeckle)
+	inside := maskInside(opts.Mask, bounds)
+	clipAssignment(colorIndices, inside)
+
+	layout := &sheetLayout{
+		bounds:        bounds,
+		palette:       palette,
+		colorIndices:  colorIndices,
+		minArea:       opts.MinRegionArea.resolve(bounds),
+		labeling:      opts.Labeling,
+		keepAllColors: opts.KeepAllColors,
+		numbers:       opts.numberStyle(),
+		borderColor:   opts.borderColor(),
+		connectivity:  opts.Connectivity,
+		style:         sheetStyles[opts.Style],
+		trace:         traceImage(img, opts.TraceOpacity),
+		traceOpacity:  opts.TraceOpacity,
+		texture:       opts.Texture,
+
+		mask:           inside,
+		maskBackground: opts.MaskBackground,
+
+		stage:  opts.Stage,
+		source: stageSource(img, opts.Stage),
+
+		progress: opts.Progress,
+	}
+
+	// Grid recipes re-quantize from the image, so recoloring belongs here to replay alike
+	if opts.LocalContrast {
+		layout.applyLocalContrast(img)
+	}
+	return layout
+}
+
+// renderGrid draws the quantized pixels, borders between colors and numbers
+func (l *sheetLayout) renderGrid(lineWidth int, showColors bool) (image.Image, []color.Color) {
+	bounds := l.bounds
+	colorIndices := l.colorIndices
+
+	// Fill with palette colors or white
+	l.report("Creating regions", 30)
+	result := image.NewRGBA(bounds)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			nearestIdx := findNearestColor(img.At(x, y), palette)
-			colorIndices[(y-bounds.Min.Y)*bounds.Dx()+(x-bounds.Min.X)] = nearestIdx
+			idx := colorIndices[(y-bounds.Min.Y)*bounds.Dx()+(x-bounds.Min.X)]
+			if idx == maskedOut {
+				continue // Painted by applyMask
+			}
 			if showColors {
-				quantized.Set(x, y, palette[nearestIdx])
+				result.Set(x, y, l.palette[idx])
 			} else {
-				quantized.Set(x, y, color.RGBA{255, 255, 255, 255}) // White
+				result.Set(x, y, blankFill(l.trace, l.traceOpacity, x, y)) // White
 			}
 		}
 	}
+	if showColors {
+		applyCanvasTexture(result, l.texture)
+	}
+	if l.stage == StageQuantized {
+		l.applyMask(result, 0)
+		return result, l.palette
+	}
 
 	// Step 3: Add borders between different colors
-	result := image.NewRGBA(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			result.Set(x, y, quantized.At(x, y))
-		}
+	l.report("Drawing borders", 70)
+	drawColorBorders(result, colorIndices, lineWidth, l.connectivity, l.borderColor)
+	l.applyMask(result, lineWidth)
+
+	// Step 4: Add region numbers for small line widths
+	palette := l.palette
+	if l.showNumbers(lineWidth) {
+		l.report("Adding numbers", 85)
+		result, palette = addGridRegionNumbers(result, colorIndices, bounds, palette, l.minArea, l.labeling, l.keepAllColors, l.numbers)
+	}
+
+	return result, palette
+}
+
+// quantizeGrid maps every pixel to its nearest palette index, splitting the rows into
+// blocks for numWorkers goroutines. Each worker writes only its own rows, so the result
+// does not depend on the worker count.
+func quantizeGrid(img image.Image, palette []color.Color, metric ColorMetric, numWorkers int) []int {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	colorIndices := make([]int, width*height)
+	if numWorkers < 1 {
+		numWorkers = 1
 	}
+	rowsPerWorker := (height + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		startY := w * rowsPerWorker
+		endY := min(startY+rowsPerWorker, height)
+		if startY >= endY {
+			break
+		}
 
-	if lineWidth > 0 {
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				if isGridBorder(x, y, bounds, colorIndices, lineWidth) {
-					result.Set(x, y, color.RGBA{0, 0, 0, 255})
+		wg.Add(1)
+		go func(sy, ey int) {
+			defer wg.Done()
+			for y := sy; y < ey; y++ {
+				for x := 0; x < width; x++ {
+					colorIndices[y*width+x] = findNearestColorWithMetric(img.At(x+bounds.Min.X, y+bounds.Min.Y), palette, metric)
 				}
 			}
-		}
+		}(startY, endY)
 	}
+	wg.Wait()
 
-	// Step 4: Add region numbers for small line widths
-	if lineWidth <= 2 {
-		result = addGridRegionNumbers(result, colorIndices, bounds)
+	return colorIndices
+}
+
+// drawColorBorders draws borders (nil borderColor = black) into img wherever the palette
+// index in colorIndices changes, so borders outline paint regions rather than individual cells
+func drawColorBorders(img *image.RGBA, colorIndices []int, width int, connectivity Connectivity, borderColor color.Color) {
+	if width == 0 {
+		return
+	}
+	if borderColor == nil {
+		borderColor = color.RGBA{0, 0, 0, 255}
 	}
 
-	return result, palette
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if isGridBorder(x, y, bounds, colorIndices, width, connectivity) {
+				img.Set(x, y, borderColor)
+			}
+		}
+	}
 }
 
 // isGridBorder checks if a pixel should be a border in grid mode
-func isGridBorder(x, y int, bounds image.Rectangle, colorIndices []int, width int) bool {
+func isGridBorder(x, y int, bounds image.Rectangle, colorIndices []int, width int, connectivity Connectivity) bool {
 	w := bounds.Dx()
 	currentIdx := (y-bounds.Min.Y)*w + (x - bounds.Min.X)
 	if currentIdx < 0 || currentIdx >= len(colorIndices) {
@@ -232,10 +698,7 @@ func isGridBorder(x, y int, bounds image.Rectangle, colorIndices []int, width in
 	radius := (width + 1) / 2
 	for dy := -radius; dy <= radius; dy++ {
 		for dx := -radius; dx <= radius; dx++ {
-			if dx == 0 && dy == 0 {
-				continue
-			}
-			if dx*dx+dy*dy > radius*radius {
+			if !connectivity.within(dx, dy, radius) {
 				continue
 			}
 
@@ -253,8 +716,8 @@ func isGridBorder(x, y int, bounds image.Rectangle, colorIndices []int, width in
 	return false
 }
 
-// addGridRegionNumbers adds numbers to regions in grid mode
-func addGridRegionNumbers(img *image.RGBA, colorIndices []int, bounds image.Rectangle) *image.RGBA {
+// addGridRegionNumbers adds numbers to regions in grid mode and returns the palette renumbered to match
+func addGridRegionNumbers(img *image.RGBA, colorIndices []int, bounds image.Rectangle, palette []color.Color, minArea int, labeling LabelAlgorithm, keepAllColors bool, style numberStyle) (*image.RGBA, []color.Color) {
 	result := image.NewRGBA(bounds)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
@@ -262,74 +725,13 @@ func addGridRegionNumbers(img *image.RGBA, colorIndices []int, bounds image.Rect
 		}
 	}
 
-	// Find regions using flood fill
-	visited := make([]bool, len(colorIndices))
-	width := bounds.Dx()
+	// Find numbered regions, folding tiny fragments into same-color neighbors
+	regions := buildLabeledRegions(colorIndices, bounds, palette, minArea, labeling, keepAllColors)
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			idx := (y-bounds.Min.Y)*width + (x - bounds.Min.X)
-			if visited[idx] || idx >= len(colorIndices) {
-				continue
-			}
+	// Renumber so only colors with a numbered region appear, as 1..N, unless the palette is locked
+	palette = style.numbering(regions, palette)
 
-			// Find region
-			pixels := gridFloodFill(x, y, colorIndices, visited, bounds)
-			if len(pixels) >= 100 {
-				// Calculate centroid
-				sumX, sumY := 0, 0
-				for _, p := range pixels {
-					sumX += p.X
-					sumY += p.Y
-				}
-				centerX := sumX / len(pixels)
-				centerY := sumY / len(pixels)
-
-				// Draw number (color index + 1)
-				colorNumber := colorIndices[idx] + 1
-				drawNumber(result, colorNumber, centerX, centerY)
-			}
-		}
-	}
-
-	return result
-}
-
-// gridFloodFill performs flood fill for grid regions
-func gridFloodFill(startX, startY int, colorIndices []int, visited []bool, bounds image.Rectangle) []image.Point {
-	width := bounds.Dx()
-	startIdx := (startY-bounds.Min.Y)*width + (startX - bounds.Min.X)
-	if startIdx >= len(colorIndices) {
-		return nil
-	}
-	targetColor := colorIndices[startIdx]
+	drawRegionNumbers(result, regions, palette, style)
 
-	queue := []image.Point{{X: startX, Y: startY}}
-	var pixels []image.Point
-
-	for len(queue) > 0 {
-		p := queue[0]
-		queue = queue[1:]
-
-		if p.X < bounds.Min.X || p.X >= bounds.Max.X || p.Y < bounds.Min.Y || p.Y >= bounds.Max.Y {
-			continue
-		}
-
-		idx := (p.Y-bounds.Min.Y)*width + (p.X - bounds.Min.X)
-		if idx >= len(colorIndices) || visited[idx] || colorIndices[idx] != targetColor {
-			continue
-		}
-
-		visited[idx] = true
-		pixels = append(pixels, p)
-
-		queue = append(queue,
-			image.Point{X: p.X - 1, Y: p.Y},
-			image.Point{X: p.X + 1, Y: p.Y},
-			image.Point{X: p.X, Y: p.Y - 1},
-			image.Point{X: p.X, Y: p.Y + 1},
-		)
-	}
-
-	return pixels
+	return result, palette
 }
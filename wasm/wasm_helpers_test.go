@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestRegionsByColorBoxesEncloseRegions(t *testing.T) {
+	layout := prepareLayout(syntheticImage(160), 200, 6, true, ProcessOptions{Seed: 5})
+	_, palette := layout.render(1, false)
+	byColor := layout.regionsByColor(palette)
+	if len(byColor) != len(palette) {
+		t.Fatalf("%d color entries for %d palette colors", len(byColor), len(palette))
+	}
+
+	regions := buildLabeledRegions(layout.assignment(), layout.bounds, layout.palette, layout.minArea, layout.labeling, layout.keepAllColors)
+	listed := 0
+	for _, entry := range byColor {
+		listed += len(entry.Regions)
+	}
+	if listed != len(regions) {
+		t.Fatalf("regionsByColor lists %d regions, the sheet has %d", listed, len(regions))
+	}
+
+	for _, region := range regions {
+		// Find the listed box for this region by its color and centroid
+		var box *RegionBox
+		for _, entry := range byColor {
+			if !colorsEqual(palette[entry.Number-1], layout.palette[region.ColorIndex]) {
+				continue
+			}
+			for i, b := range entry.Regions {
+				if b.CentroidX == region.Centroid.X && b.CentroidY == region.Centroid.Y && b.Area == region.Area {
+					box = &entry.Regions[i]
+				}
+			}
+		}
+		if box == nil {
+			t.Errorf("region of color %d at %v is not listed", region.ColorIndex, region.Centroid)
+			continue
+		}
+
+		rect := image.Rect(box.X, box.Y, box.X+box.W, box.Y+box.H)
+		for _, p := range region.Pixels {
+			if !p.In(rect) {
+				t.Errorf("pixel %v of the region at %v lies outside its box %v", p, region.Centroid, rect)
+				break
+			}
+		}
+		if box.Area > box.W*box.H {
+			t.Errorf("region area %d exceeds its %dx%d box", box.Area, box.W, box.H)
+		}
+	}
+}
+
+func TestQuantizeGridIsIndependentOfWorkerCount(t *testing.T) {
+	// A sub-image, so bounds do not start at the origin
+	img := syntheticImage(96).SubImage(image.Rect(5, 7, 96, 90))
+	palette := generatePalette(img, 8, rand.New(rand.NewSource(1)))
+
+	serial := quantizeGrid(img, palette, MetricEuclidean, 1)
+	for _, workers := range []int{2, 3, 7, 16, 200} {
+		if got := quantizeGrid(img, palette, MetricEuclidean, workers); !slices.Equal(got, serial) {
+			t.Errorf("%d workers quantized differently from one", workers)
+		}
+	}
+}
+
+func BenchmarkQuantizeGrid(b *testing.B) {
+	img := syntheticImage(512)
+	palette := generatePalette(img, 32, rand.New(rand.NewSource(1)))
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				quantizeGrid(img, palette, MetricEuclidean, workers)
+			}
+		})
+	}
+}
+
+// hardTransitions counts neighboring pixel pairs whose red channels differ by over 3/4 of
+// the range, i.e. edges drawn as an abrupt jump rather than a blend
+func hardTransitions(img *image.RGBA) int {
+	b := img.Bounds()
+	n := 0
+	jump := func(p, q color.RGBA) bool {
+		d := int(p.R) - int(q.R)
+		return d > 192 || d < -192
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if x+1 < b.Max.X && jump(img.RGBAAt(x, y), img.RGBAAt(x+1, y)) {
+				n++
+			}
+			if y+1 < b.Max.Y && jump(img.RGBAAt(x, y), img.RGBAAt(x, y+1)) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestSupersamplingSoftensCellEdges(t *testing.T) {
+	// A black and a white cell meeting along a slanted edge
+	bounds := image.Rect(0, 0, 64, 48)
+	points := []Point{
+		{X: 12, Y: 10, Color: color.RGBA{0, 0, 0, 255}, Index: 0},
+		{X: 50, Y: 34, Color: color.RGBA{255, 255, 255, 255}, ColorIndex: 1, Index: 1},
+	}
+
+	sharp := createSupersampledVoronoiDiagram(bounds, points, CellEuclidean, PointIndexKDTree, 1)
+	base := hardTransitions(sharp)
+	if base == 0 {
+		t.Fatal("1x render has no hard edge to soften")
+	}
+	for _, factor := range []int{2, 4} {
+		smooth := createSupersampledVoronoiDiagram(bounds, points, CellEuclidean, PointIndexKDTree, factor)
+		if n := hardTransitions(smooth); n >= base {
+			t.Errorf("%dx supersampling left %d hard transitions, 1x has %d", factor, n, base)
+		}
+	}
+}
+
+func TestColorBordersSkipSameColorCells(t *testing.T) {
+	// Two red cells meeting at x = 20, and a blue cell from x = 42
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	red, blue := color.RGBA{220, 40, 40, 255}, color.RGBA{40, 40, 220, 255}
+	palette := []color.Color{red, blue}
+	points := []Point{
+		{X: 10, Y: 16, Color: red, ColorIndex: 0, Index: 0},
+		{X: 30, Y: 16, Color: red, ColorIndex: 0, Index: 1},
+		{X: 54, Y: 16, Color: blue, ColorIndex: 1, Index: 2},
+	}
+
+	// darkColumn reports whether column x of img holds any border pixel
+	darkColumn := func(img image.Image, x int) bool {
+		return countPixels(img.(*image.RGBA).SubImage(image.Rect(x, 0, x+1, 32)), isDark) > 0
+	}
+
+	// Line width 3 leaves the numbers out, so every dark pixel is a border
+	for _, colorBorders := range []bool{false, true} {
+		layout := newVoronoiLayout(img, palette, points, ProcessOptions{ColorBorders: colorBorders})
+		sheet, _ := layout.render(3, true)
+
+		if got := darkColumn(sheet, 20); got == colorBorders {
+			t.Errorf("colorBorders=%v: border between the red cells = %v", colorBorders, got)
+		}
+		if !darkColumn(sheet, 42) {
+			t.Errorf("colorBorders=%v: no border between red and blue", colorBorders)
+		}
+	}
+}
+
+func TestSimplifyReducesRegionsMonotonically(t *testing.T) {
+	img := syntheticImage(192)
+	for _, useVoronoi := range []bool{true, false} {
+		previous := -1
+		var counts []int
+		for _, level := range []int{0, 25, 50, 75, 100} {
+			layout := prepareLayout(img, 400, 16, useVoronoi, ProcessOptions{Seed: 3, Simplify: level})
+			regions := buildLabeledRegions(layout.assignment(), layout.bounds, layout.palette, layout.minArea, layout.labeling, layout.keepAllColors)
+			counts = append(counts, len(regions))
+			if previous >= 0 && len(regions) > previous {
+				t.Errorf("useVoronoi=%v: simplify %d gave %d regions, more than the previous level's %d", useVoronoi, level, len(regions), previous)
+			}
+			previous = len(regions)
+		}
+		if counts[len(counts)-1] >= counts[0] {
+			t.Errorf("useVoronoi=%v: region counts %v, want fewer at simplify 100 than at 0", useVoronoi, counts)
+		}
+	}
+}
+
+func TestTraceOpacityBlendsOriginalIntoBlankFill(t *testing.T) {
+	bounds := image.Rect(0, 0, 32, 24)
+	original := image.NewUniform(color.RGBA{40, 70, 200, 255})
+	points := []Point{{X: 8, Y: 12, Index: 0}, {X: 24, Y: 12, Index: 1}}
+
+	blank, _ := createBlankVoronoiDiagram(bounds, points, CellEuclidean, PointIndexKDTree, original, 0.15)
+	want := color.RGBA{223, 227, 247, 255} // 85% white, 15% blue
+	if n := countPixels(blank, func(c color.RGBA) bool { return c != want }); n != 0 {
+		t.Errorf("%d pixels differ from the 15%% blend %v", n, want)
+	}
+
+	blank, _ = createBlankVoronoiDiagram(bounds, points, CellEuclidean, PointIndexKDTree, nil, 0)
+	if n := countPixels(blank, func(c color.RGBA) bool { return c != color.RGBA{255, 255, 255, 255} }); n != 0 {
+		t.Errorf("without a trace %d pixels are not white", n)
+	}
+
+	// Through processImage, every pixel of the blank sheet is a border, a number or the tint
+	img := image.NewRGBA(image.Rect(0, 0, 128, 96))
+	draw.Draw(img, img.Bounds(), original, image.Point{}, draw.Src)
+	sheet := decodeBase64PNG(t, mustProcessImage(t, img, testSheetArgs, map[string]interface{}{"traceOpacity": 0.15}).Image)
+	tinted := countPixels(sheet, func(c color.RGBA) bool { return c == want })
+	other := countPixels(sheet, func(c color.RGBA) bool { return c != want && !isDark(c) })
+	if total := sheet.Bounds().Dx() * sheet.Bounds().Dy(); other != 0 || tinted < total/2 {
+		t.Errorf("sheet has %d tinted and %d other light pixels of %d, want only the tint between lines", tinted, other, total)
+	}
+}
+
+func TestGridNumberThresholdIsConfigurable(t *testing.T) {
+	// Four 8×12 stripes of 96 pixels each: all below the default 100-pixel threshold
+	bounds := image.Rect(0, 0, 32, 12)
+	assignment := stripeAssignment(bounds.Dx(), bounds.Dy(), 0, 1, 2, 3)
+
+	for _, tc := range []struct {
+		name      string
+		threshold AreaThreshold
+		numbered  int
+	}{
+		{"default", AreaThreshold{}, 0},
+		{"40 pixels", AreaThreshold{Pixels: 40}, 4},
+		{"20 percent", AreaThreshold{Percent: 20}, 4}, // 76 pixels of this small image
+		{"30 percent", AreaThreshold{Percent: 30}, 0}, // 115 pixels
+	} {
+		img := image.NewRGBA(bounds)
+		draw.Draw(img, bounds, image.White, image.Point{}, draw.Src)
+		numbered, palette := addGridRegionNumbers(img, assignment, bounds, testPalette, tc.threshold.resolve(bounds), LabelUnionFind, false, numberStyle{})
+
+		digits := countPixels(numbered, isDark)
+		if len(palette) != tc.numbered || (digits > 0) != (tc.numbered > 0) {
+			t.Errorf("%s: %d numbered colors and %d digit pixels, want %d colors", tc.name, len(palette), digits, tc.numbered)
+		}
+	}
+}
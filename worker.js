@@ -4,6 +4,20 @@ importScripts('wasm_exec.js');
 let wasmReady = false;
 let go = new Go();
 
+// Loading the worker as worker.js?selftest checks the pipeline before reporting ready;
+// ?fillWorkers=N&fillRows=M pin the Voronoi fill's goroutine count and rows per chunk
+const workerParams = new URLSearchParams(self.location.search);
+go.env = {};
+if (workerParams.has('selftest')) {
+    go.env.PBN_SELFTEST = '1';
+}
+if (workerParams.has('fillWorkers')) {
+    go.env.PBN_FILL_WORKERS = workerParams.get('fillWorkers');
+}
+if (workerParams.has('fillRows')) {
+    go.env.PBN_FILL_ROWS = workerParams.get('fillRows');
+}
+
 // Load WASM
 WebAssembly.instantiateStreaming(fetch('paintbynumbers.wasm'), go.importObject)
     .then((result) => {
@@ -24,21 +38,37 @@ self.onmessage = function(e) {
             return;
         }
 
-        const { imageData, points, colors, lineWidth, maxDimension, showColors, mode } = e.data;
+        const { imageData, points, colors, lineWidth, maxDimension, showColors, mode, options } = e.data;
 
         try {
             // Call Go WASM function
             const useVoronoi = mode === 'voronoi';
-            const resultJSON = processImage(imageData, points, colors, lineWidth, maxDimension, showColors, useVoronoi);
+            const resultJSON = processImage(imageData, points, colors, lineWidth, maxDimension, showColors, useVoronoi, options || {});
             const result = JSON.parse(resultJSON);
 
             if (result.error) {
-                self.postMessage({ type: 'error', error: result.error });
+                self.postMessage({ type: 'error', error: result.error, code: result.errorCode });
             } else {
                 self.postMessage({ type: 'complete', result: result });
             }
         } catch (err) {
             self.postMessage({ type: 'error', error: err.message });
         }
+    } else if (e.data.type === 'edges') {
+        if (!wasmReady) {
+            self.postMessage({ type: 'error', error: 'WASM not ready' });
+            return;
+        }
+
+        try {
+            const result = JSON.parse(edgeMapImage(e.data.imageData, e.data.maxDimension));
+            if (result.error) {
+                self.postMessage({ type: 'error', error: result.error, code: result.errorCode });
+            } else {
+                self.postMessage({ type: 'edges', result: result });
+            }
+        } catch (err) {
+            self.postMessage({ type: 'error', error: err.message });
+        }
     }
 };